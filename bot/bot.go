@@ -0,0 +1,287 @@
+// Package bot 用于编写 EasyChat 机器人，负责连接服务端、登录、发送心跳与分发消息和命令
+//
+// 机器人使用注册账号登录，由服务端终端 /bot <nick> on 标记为机器人账号后，
+// 使用单独的限流策略且不参与活跃度排行榜。
+package bot
+
+import (
+	"bufio"
+	"context"
+	"easy-chat/proto"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAddr      = "localhost:8088"
+	defaultPrefix    = "!"
+	defaultHeartbeat = 30 * time.Second
+)
+
+// ErrClosed 连接已被服务端关闭，如被踢出或封禁
+var ErrClosed = errors.New("与服务端的连接已断开")
+
+// Config 机器人配置
+type Config struct {
+	Addr      string        // 服务端地址，默认 localhost:8088
+	Workspace string        // 工作区，为空时进入服务端默认工作区
+	NickName  string        // 机器人账号的昵称
+	Password  string        // 机器人账号的密码
+	Token     string        // 会话令牌，不为空时代替密码登录
+	Room      string        // 登录后进入的房间，为空时留在默认房间
+	Prefix    string        // 命令前缀，默认为 !，如 !roll 6
+	Heartbeat time.Duration // 心跳间隔，需小于服务端的超时时间，默认 30 秒
+}
+
+// Message 聊天消息
+type Message struct {
+	ID     string // 消息编号，可用于编辑、删除与回复
+	Parent string // 回复的消息编号，不是回复时为空
+	From   string
+	Room   string
+	Text   string
+	Time   time.Time
+}
+
+// Context 命令上下文
+type Context struct {
+	Message
+	Command string   // 命令名称，不含前缀
+	Args    []string // 命令参数
+	bot     *Bot
+}
+
+// Reply 在机器人所在的房间回复消息
+func (c *Context) Reply(text string) error {
+	return c.bot.Send(text)
+}
+
+// Bot 返回处理命令的机器人
+func (c *Context) Bot() *Bot {
+	return c.bot
+}
+
+// Handler 命令处理函数
+type Handler func(c *Context)
+
+// Bot 机器人，处理函数在接收协程中依次执行，耗时的操作需要另起协程
+type Bot struct {
+	conf Config
+
+	mu        sync.RWMutex
+	commands  map[string]Handler
+	onMessage []func(m Message)
+	onSystem  []func(text string)
+
+	wmu       sync.Mutex // 保护连接的写入
+	conn      net.Conn
+	nickName  string
+	workspace string
+	token     string
+}
+
+// New 创建机器人
+func New(conf Config) *Bot {
+	if conf.Addr == "" {
+		conf.Addr = defaultAddr
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = defaultPrefix
+	}
+	if conf.Heartbeat <= 0 {
+		conf.Heartbeat = defaultHeartbeat
+	}
+	return &Bot{conf: conf, commands: make(map[string]Handler)}
+}
+
+// Handle 注册命令处理函数，command 不含前缀，同名命令后注册的生效
+func (b *Bot) Handle(command string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands[strings.TrimPrefix(command, b.conf.Prefix)] = h
+}
+
+// OnMessage 注册聊天消息处理函数，机器人自己发送的消息与命令不会交给处理函数
+func (b *Bot) OnMessage(h func(m Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onMessage = append(b.onMessage, h)
+}
+
+// OnSystem 注册系统消息处理函数，如进出聊天室的提示与服务端命令的结果
+func (b *Bot) OnSystem(h func(text string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onSystem = append(b.onSystem, h)
+}
+
+// NickName 登录后的昵称
+func (b *Bot) NickName() string {
+	return b.nickName
+}
+
+// Workspace 登录后所在的工作区
+func (b *Bot) Workspace() string {
+	return b.workspace
+}
+
+// Token 服务端签发的会话令牌，可保存后用于下次登录
+func (b *Bot) Token() string {
+	return b.token
+}
+
+// Run 连接服务端并登录，之后接收消息直到 ctx 结束或连接断开
+// ctx 结束时返回 nil，被服务端断开时返回 ErrClosed
+func (b *Bot) Run(ctx context.Context) error {
+	conn, err := net.Dial("tcp", b.conf.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	b.wmu.Lock()
+	b.conn = conn
+	b.wmu.Unlock()
+	if err = b.login(reader); err != nil {
+		return err
+	}
+	if b.conf.Room != "" {
+		if err = b.Join(b.conf.Room); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	go b.heartbeat(done)
+
+	for {
+		frame, err := proto.DecodeFrame(reader)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				return ErrClosed
+			}
+			return err
+		}
+		b.dispatch(frame)
+	}
+}
+
+// login 发送登录帧并等待登录结果
+func (b *Bot) login(reader *bufio.Reader) error {
+	frame := proto.Frame{Type: proto.TypeLogin, From: b.conf.NickName, Password: b.conf.Password, Workspace: b.conf.Workspace}
+	if b.conf.Token != "" {
+		frame = proto.Frame{Type: proto.TypeLogin, Token: b.conf.Token, Workspace: b.conf.Workspace}
+	}
+	if err := b.write(frame); err != nil {
+		return err
+	}
+	reply, err := proto.DecodeFrame(reader)
+	if err != nil {
+		return err
+	}
+	// 服务端在握手时发送系统消息后断开连接，如 IP 被封禁
+	if reply.Type == proto.TypeSys || !reply.OK {
+		return errors.New("登录失败: " + reply.Text)
+	}
+	b.nickName, b.workspace, b.token = reply.From, reply.Workspace, reply.Token
+	return nil
+}
+
+// heartbeat 定期发送心跳包
+func (b *Bot) heartbeat(done <-chan struct{}) {
+	ticker := time.NewTicker(b.conf.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if b.write(proto.Frame{Type: proto.TypePing}) != nil {
+				return
+			}
+		}
+	}
+}
+
+// dispatch 将收到的消息交给处理函数
+func (b *Bot) dispatch(frame proto.Frame) {
+	b.mu.RLock()
+	onMessage, onSystem := b.onMessage, b.onSystem
+	b.mu.RUnlock()
+	switch frame.Type {
+	case proto.TypeMsg:
+		if frame.From == b.nickName {
+			return
+		}
+		m := Message{ID: frame.ID, Parent: frame.Parent, From: frame.From, Room: frame.Room, Text: frame.Text, Time: time.Unix(frame.Time, 0)}
+		if args := strings.Fields(strings.TrimPrefix(m.Text, b.conf.Prefix)); len(args) > 0 && strings.HasPrefix(m.Text, b.conf.Prefix) {
+			b.mu.RLock()
+			h, ok := b.commands[args[0]]
+			b.mu.RUnlock()
+			if ok {
+				h(&Context{Message: m, Command: args[0], Args: args[1:], bot: b})
+				return
+			}
+		}
+		for _, h := range onMessage {
+			h(m)
+		}
+	case proto.TypeSys:
+		for _, h := range onSystem {
+			h(frame.Text)
+		}
+	}
+}
+
+// Send 在机器人所在的房间发送消息
+func (b *Bot) Send(text string) error {
+	return b.write(proto.Frame{Type: proto.TypeMsg, Text: text})
+}
+
+// ReplyTo 在机器人所在的房间回复指定编号的消息，消息需在同一房间
+func (b *Bot) ReplyTo(id string, text string) error {
+	return b.write(proto.Frame{Type: proto.TypeMsg, Parent: id, Text: text})
+}
+
+// Command 发送服务端命令，如 /join lobby，结果以系统消息返回
+func (b *Bot) Command(text string) error {
+	if !strings.HasPrefix(text, "/") {
+		text = "/" + text
+	}
+	return b.write(proto.Frame{Type: proto.TypeCmd, Text: text})
+}
+
+// Join 进入房间
+func (b *Bot) Join(room string) error {
+	return b.Command("/join " + room)
+}
+
+// write 向服务端发送消息帧
+func (b *Bot) write(frame proto.Frame) error {
+	data, err := proto.EncodeFrame(frame)
+	if err != nil {
+		return err
+	}
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	if b.conn == nil {
+		return ErrClosed
+	}
+	_, err = b.conn.Write(data)
+	return err
+}
@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"easy-chat/proto"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer 测试用的服务端，接受一个连接后交给 serve 处理
+func fakeServer(t *testing.T, serve func(conn net.Conn, reader *bufio.Reader)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn, bufio.NewReader(conn))
+	}()
+	return listener.Addr().String()
+}
+
+// sendFrame 服务端向机器人发送消息帧
+func sendFrame(t *testing.T, conn net.Conn, frame proto.Frame) {
+	data, err := proto.EncodeFrame(frame)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = conn.Write(data)
+}
+
+func TestBotRun(t *testing.T) {
+	frames := make(chan proto.Frame, 16)
+	addr := fakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		// 转发机器人发送的消息帧，心跳除外
+		go func() {
+			for {
+				frame, err := proto.DecodeFrame(reader)
+				if err != nil {
+					close(frames)
+					return
+				}
+				if frame.Type != proto.TypePing {
+					frames <- frame
+				}
+			}
+		}()
+		login := <-frames
+		if login.Type != proto.TypeLogin || login.From != "dice" || login.Password != "secret1" || login.Workspace != "teamA" {
+			t.Errorf("登录帧 = %+v", login)
+		}
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeLogin, OK: true, From: "dice", Workspace: "teamA", Token: "tok"})
+		if join := <-frames; join.Type != proto.TypeCmd || join.Text != "/join games" {
+			t.Errorf("进入房间的命令 = %+v", join)
+		}
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeMsg, ID: "1", From: "dice", Room: "games", Text: "!roll 6"})
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeMsg, ID: "2", From: "bob", Room: "games", Text: "!roll 6 2"})
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeMsg, ID: "3", From: "bob", Room: "games", Text: "!unknown"})
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeMsg, ID: "4", From: "bob", Room: "games", Text: "hello", Parent: "2"})
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeSys, Text: "bob 进入了聊天室"})
+		if reply := <-frames; reply.Type != proto.TypeMsg || reply.Text != "rolled 6 2" {
+			t.Errorf("命令的回复 = %+v", reply)
+		}
+		if reply := <-frames; reply.Type != proto.TypeMsg || reply.Parent != "4" || reply.Text != "hi bob" {
+			t.Errorf("消息的回复 = %+v", reply)
+		}
+		// 服务端断开连接
+	})
+
+	b := New(Config{Addr: addr, Workspace: "teamA", NickName: "dice", Password: "secret1", Room: "games"})
+	var commands []*Context
+	var messages []Message
+	var system []string
+	b.Handle("!roll", func(c *Context) {
+		commands = append(commands, c)
+		_ = c.Reply("rolled " + strings.Join(c.Args, " "))
+	})
+	b.OnMessage(func(m Message) {
+		messages = append(messages, m)
+		if m.Text == "hello" {
+			_ = b.ReplyTo(m.ID, "hi "+m.From)
+		}
+	})
+	b.OnSystem(func(text string) { system = append(system, text) })
+
+	if err := b.Run(context.Background()); err != ErrClosed {
+		t.Fatalf("Run() = %v, want %v", err, ErrClosed)
+	}
+	if b.NickName() != "dice" || b.Workspace() != "teamA" || b.Token() != "tok" {
+		t.Errorf("登录结果 = %s, %s, %s", b.NickName(), b.Workspace(), b.Token())
+	}
+	// 自己发送的命令不处理，未注册的命令作为普通消息
+	if len(commands) != 1 || commands[0].Command != "roll" || !reflect.DeepEqual(commands[0].Args, []string{"6", "2"}) || commands[0].From != "bob" {
+		t.Errorf("命令 = %+v", commands)
+	}
+	if len(messages) != 2 || messages[0].Text != "!unknown" || messages[1].Parent != "2" {
+		t.Errorf("消息 = %+v", messages)
+	}
+	if !reflect.DeepEqual(system, []string{"bob 进入了聊天室"}) {
+		t.Errorf("系统消息 = %v", system)
+	}
+}
+
+func TestBotLoginFailed(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply proto.Frame
+	}{
+		{name: "登录被拒绝", reply: proto.Frame{Type: proto.TypeLogin, OK: false, Text: "密码错误"}},
+		{name: "握手时被断开", reply: proto.Frame{Type: proto.TypeSys, Text: "你已被封禁"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := fakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+				login, err := proto.DecodeFrame(reader)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// 使用令牌登录时不发送密码
+				if login.Token != "tok" || login.Password != "" || login.From != "" {
+					t.Errorf("登录帧 = %+v", login)
+				}
+				sendFrame(t, conn, tt.reply)
+			})
+			b := New(Config{Addr: addr, NickName: "dice", Password: "secret1", Token: "tok"})
+			err := b.Run(context.Background())
+			if err == nil || !strings.Contains(err.Error(), "登录失败: "+tt.reply.Text) {
+				t.Errorf("Run() = %v, want 登录失败", err)
+			}
+		})
+	}
+}
+
+func TestBotCancel(t *testing.T) {
+	addr := fakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		if _, err := proto.DecodeFrame(reader); err != nil {
+			return
+		}
+		sendFrame(t, conn, proto.Frame{Type: proto.TypeLogin, OK: true, From: "dice"})
+		// 保持连接直到机器人退出
+		for {
+			if _, err := proto.DecodeFrame(reader); err != nil {
+				return
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	b := New(Config{Addr: addr, NickName: "dice", Password: "secret1"})
+	b.OnSystem(func(string) {})
+	errs := make(chan error, 1)
+	go func() { errs <- b.Run(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("ctx 结束后 Run() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ctx 结束后 Run() 没有返回")
+	}
+}
+
+func TestNew(t *testing.T) {
+	b := New(Config{})
+	if b.conf.Addr != defaultAddr || b.conf.Prefix != defaultPrefix || b.conf.Heartbeat != defaultHeartbeat {
+		t.Errorf("New() 默认配置 = %+v", b.conf)
+	}
+	// 命令名称可以带前缀注册
+	b.Handle("!roll", func(*Context) {})
+	b.Handle("flip", func(*Context) {})
+	if _, ok := b.commands["roll"]; !ok {
+		t.Error("带前缀的命令注册失败")
+	}
+	if _, ok := b.commands["flip"]; !ok {
+		t.Error("不带前缀的命令注册失败")
+	}
+	if err := b.Send("hi"); err != ErrClosed {
+		t.Errorf("连接前 Send() = %v, want %v", err, ErrClosed)
+	}
+}
@@ -3,25 +3,34 @@ package main
 import (
 	"bufio"
 	"easy-chat/proto"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
 var (
-	userName string
-	mu       sync.Mutex // 用于保护输入和消息显示的同步
+	userName  string
+	workspace string     // 当前所在工作区
+	mu        sync.Mutex // 用于保护输入和消息显示的同步
 )
 
 const heartbeatInterval = 30 * time.Second // 心跳包发送间隔
 
 func main() {
+	addr := flag.String("addr", "localhost:8088", "服务端地址")
+	flag.StringVar(&workspace, "workspace", "", "要进入的工作区，为空时进入服务端默认工作区")
+	flag.Parse()
+
 	//连接服务端
-	conn, err := net.Dial("tcp", "localhost:8088")
+	conn, err := net.Dial("tcp", *addr)
 	if err != nil {
 		fmt.Println("Client connection to server failed, err=", err)
 		return
@@ -32,38 +41,77 @@ func main() {
 	homeText() //起始界面
 
 	reader := bufio.NewReader(conn)
-	for {
-		//填写昵称
-		userName, _ = bufio.NewReader(os.Stdin).ReadString('\n')
-		userName = strings.Trim(userName, " \r\n")
-		// 验证昵称
-		if userName == "" {
-			fmt.Println("昵称不能为空，请重新输入！")
-			continue
+	input := bufio.NewReader(os.Stdin)
+	pending := "" // 已注册、等待输入密码的昵称
+	loggedIn := false
+
+	// 优先使用上次登录保存的令牌
+	tokenKey := *addr + "/" + workspace
+	if token := loadTokens()[tokenKey]; token != "" {
+		reply, err := login(conn, reader, proto.Frame{Type: proto.TypeLogin, Workspace: workspace, Token: token})
+		if err == io.EOF {
+			return
 		}
-		//发送昵称到服务端
-		data, err := proto.Encode(userName)
 		if err != nil {
-			fmt.Println("encode msg failed, err:", err)
+			fmt.Println("login failed, err:", err)
 			return
 		}
-		_, err = conn.Write(data)
-		if err != nil {
-			fmt.Println("conn.Write err=", err)
+		if reply.OK {
+			userName, workspace, loggedIn = reply.From, reply.Workspace, true
+		} else {
+			saveToken(tokenKey, "")
+			fmt.Println("登录令牌已失效(" + reply.Text + ")，请输入昵称！")
+			fmt.Printf(" >")
 		}
-
-		msg, err := proto.Decode(reader)
+	}
+	for !loggedIn {
+		//填写昵称
+		line, _ := input.ReadString('\n')
+		line = strings.Trim(line, " \r\n")
+		frame := proto.Frame{Type: proto.TypeLogin, From: line, Workspace: workspace}
+		switch {
+		case pending != "":
+			// 上一次输入的昵称已注册，本次输入的是密码
+			frame.From = pending
+			frame.Password = line
+		case strings.HasPrefix(line, "/register"):
+			// 注册账号：/register 昵称 密码
+			args := strings.Fields(line)
+			if len(args) != 3 {
+				fmt.Println("用法: /register 昵称 密码")
+				fmt.Printf(" >")
+				continue
+			}
+			frame = proto.Frame{Type: proto.TypeRegister, From: args[1], Password: args[2], Workspace: workspace}
+		case line == "":
+			// 验证昵称
+			fmt.Println("昵称不能为空，请重新输入！")
+			continue
+		}
+		userName = frame.From
+		//发送登录信息到服务端
+		reply, err := login(conn, reader, frame)
 		if err == io.EOF {
 			return
 		}
 		if err != nil {
-			fmt.Println("decode msg failed, err:", err)
+			fmt.Println("login failed, err:", err)
 			return
 		}
-		if msg == "true" {
+		if reply.OK {
+			if reply.Token != "" {
+				saveToken(tokenKey, reply.Token)
+			}
+			workspace = reply.Workspace
 			break
+		}
+		if reply.Text == proto.ReasonNeedPassword && pending == "" {
+			pending = frame.From
+			fmt.Println(" *该昵称已注册，请输入密码↓↓↓")
+			fmt.Printf(" >")
 		} else {
-			fmt.Println("昵称重复，请重新输入！")
+			pending = ""
+			fmt.Println(reply.Text + "，请重新输入！")
 			fmt.Println(" *请重新输入昵称↓↓↓")
 			fmt.Printf(" >")
 		}
@@ -80,9 +128,11 @@ func main() {
 	//接收服务端广播
 	go func() {
 		for {
-			msg, err := proto.Decode(reader)
+			frame, err := proto.DecodeFrame(reader)
 			if err == io.EOF {
-				return
+				// 被服务端断开，如被踢出或封禁
+				fmt.Println("\n与服务端的连接已断开")
+				os.Exit(0)
 			}
 			if err != nil {
 				fmt.Println("decode msg failed, err:", err)
@@ -91,16 +141,15 @@ func main() {
 			mu.Lock()
 			// 使用 ANSI 转义序列移动光标
 			fmt.Print("\033[G\033[K") // 移动光标到上一行并清除当前行
-			fmt.Println(msg)          // 打印新消息
+			fmt.Println(frame)        // 打印新消息
 			fmt.Printf("> %s", "")    // 重新打印输入提示符
 			mu.Unlock()
 		}
 	}()
-	rd := bufio.NewReader(os.Stdin)
 	//发送单行数据
 	for {
 		fmt.Print("> ")
-		line, err := rd.ReadString('\n')
+		line, err := input.ReadString('\n')
 		if err != nil {
 			fmt.Println("readString err=", err)
 			continue
@@ -113,18 +162,29 @@ func main() {
 		if line == "" {
 			continue
 		}
-		//消息拼接
-		date := time.Now().Format("[15:04:05]")
-		massage := userName + date + ": " + line
-
 		// 本地显示消息
 		//mu.Lock()
 		fmt.Printf("\033[1A\033[K") // 移动光标到上一行并清除当前行
 		//fmt.Printf("%s\n", massage)
 		//mu.Unlock()
 
-		// 发送给服务器
-		data, err := proto.Encode(massage)
+		// 发送给服务器，以 / 开头的为命令
+		frame := proto.Frame{Type: proto.TypeMsg, Text: line}
+		if strings.HasPrefix(line, "/") {
+			frame.Type = proto.TypeCmd
+		}
+		// 回复以带父消息编号的聊天消息发送
+		if args := strings.SplitN(line, " ", 3); args[0] == "/reply" {
+			if len(args) != 3 || strings.TrimSpace(args[2]) == "" {
+				fmt.Println("用法: /reply <id> <text>")
+				continue
+			}
+			frame = proto.Frame{Type: proto.TypeMsg, Parent: strings.TrimPrefix(args[1], "#"), Text: strings.TrimSpace(args[2])}
+		}
+		if line == "/logout" {
+			saveToken(tokenKey, "")
+		}
+		data, err := proto.EncodeFrame(frame)
 		if err != nil {
 			fmt.Println("encode msg failed, err:", err)
 			return
@@ -137,14 +197,80 @@ func main() {
 	}
 }
 
+// login 发送登录帧并等待登录结果
+func login(conn net.Conn, reader *bufio.Reader, frame proto.Frame) (proto.Frame, error) {
+	data, err := proto.EncodeFrame(frame)
+	if err != nil {
+		return proto.Frame{}, err
+	}
+	_, err = conn.Write(data)
+	if err != nil {
+		return proto.Frame{}, err
+	}
+	reply, err := proto.DecodeFrame(reader)
+	if err != nil {
+		return reply, err
+	}
+	// 服务端在握手时发送系统消息后断开连接，如 IP 被封禁
+	if reply.Type == proto.TypeSys {
+		return reply, errors.New(reply.Text)
+	}
+	return reply, nil
+}
+
+// tokenFile 令牌缓存文件，按 服务端地址/工作区 保存登录令牌
+func tokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "easy-chat", "tokens.json")
+}
+
+// loadTokens 读取缓存的令牌
+func loadTokens() map[string]string {
+	tokens := make(map[string]string)
+	path := tokenFile()
+	if path == "" {
+		return tokens
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tokens
+	}
+	_ = json.Unmarshal(data, &tokens)
+	return tokens
+}
+
+// saveToken 缓存令牌，token 为空时删除
+func saveToken(key string, token string) {
+	path := tokenFile()
+	if path == "" {
+		return
+	}
+	tokens := loadTokens()
+	if token == "" {
+		delete(tokens, key)
+	} else {
+		tokens[key] = token
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(path), 0700) != nil {
+		return
+	}
+	_ = os.WriteFile(path, data, 0600)
+}
+
 // sendHeartbeat 定期发送心跳包
 func sendHeartbeat(conn net.Conn) {
 	ticker := time.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		msg := "###PING"
-		data, err := proto.Encode(msg)
+		data, err := proto.EncodeFrame(proto.Frame{Type: proto.TypePing})
 		if err != nil {
 			fmt.Println("encode msg failed, err:", err)
 			return
@@ -176,6 +302,7 @@ func homeText() {
 ─────────────╚══╝ 
 `)
 	fmt.Println("\n *欢迎来到EasyChat聊天室(^_^)/")
+	fmt.Println(" *注册账号请输入: /register 昵称 密码")
 	fmt.Println(" *请输入昵称↓↓↓")
 	fmt.Printf(" >")
 }
@@ -207,6 +334,6 @@ func loadText() {
 // mainText 聊天页面上方文本
 func mainText() {
 	clearConsole()
-	fmt.Printf("EasyChat-Go    [currentUser:%v]\n", userName)
+	fmt.Printf("EasyChat-Go    [currentUser:%v]    [workspace:%v]\n", userName, workspace)
 	fmt.Printf("-----------------------------------------\n")
 }
@@ -6,6 +6,7 @@ require (
 	github.com/go-ini/ini v1.67.0
 	github.com/redis/go-redis/v9 v9.7.0
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/crypto v0.31.0
 )
 
 require (
@@ -0,0 +1,121 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/json"
+	"time"
+)
+
+// 帧类型
+const (
+	TypeLogin    = "login"    // 登录请求与登录结果
+	TypeRegister = "register" // 注册账号并登录
+	TypeMsg      = "msg"      // 聊天消息
+	TypeSys      = "sys"      // 系统消息
+	TypePing     = "ping"     // 心跳
+	TypeCmd      = "cmd"      // 客户端命令
+	TypeKick     = "kick"     // 集群内部的断开连接通知，From 为昵称、IP 或网段，Text 为发给用户的提示，不转发给客户端
+	TypeFlag     = "flag"     // 集群内部的审核提示，只以系统消息转发给版主与管理员
+	TypeEdit     = "edit"     // 消息被编辑，ID 为消息编号，Text 为新内容
+	TypeDelete   = "delete"   // 消息被删除，ID 为消息编号，From 为执行删除的用户
+)
+
+// DeletedText 已删除消息的显示文本
+const DeletedText = "[消息已删除]"
+
+// ReasonNeedPassword 登录失败原因：昵称已注册，需要输入密码
+const ReasonNeedPassword = "该昵称已注册，请输入密码"
+
+// Frame 消息帧，客户端与服务端之间以 JSON 编码的帧通信
+type Frame struct {
+	Type      string `json:"type"`
+	From      string `json:"from,omitempty"`      // 发送者昵称
+	Workspace string `json:"workspace,omitempty"` // 工作区
+	Room      string `json:"room,omitempty"`      // 房间
+	Text      string `json:"text,omitempty"`      // 消息内容，登录失败时为失败原因
+	Time      int64  `json:"time,omitempty"`      // 发送时间(Unix 秒)
+	OK        bool   `json:"ok,omitempty"`        // 登录是否成功
+	Password  string `json:"password,omitempty"`  // 登录或注册时的密码
+	Token     string `json:"token,omitempty"`     // 会话令牌，登录时代替密码，登录成功时由服务端签发
+	ID        string `json:"id,omitempty"`        // 消息编号，由服务端在投递聊天消息时分配
+	Edited    int64  `json:"edited,omitempty"`    // 最后编辑时间(Unix 秒)，未编辑过时为 0
+	Deleted   bool   `json:"deleted,omitempty"`   // 消息是否已被删除，删除后 Text 为空
+	Parent    string `json:"parent,omitempty"`    // 回复的消息编号，父消息需在同一房间
+	Quote     string `json:"quote,omitempty"`     // 父消息的预览，由服务端在发送与显示时填写，历史中不保存
+	Bot       bool   `json:"bot,omitempty"`       // 发送者是否为机器人，包括机器人账号、插件与入站 Webhook
+}
+
+// Marshal 将帧编码为 JSON 字符串
+func (f Frame) Marshal() (string, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// String 帧的显示文本
+func (f Frame) String() string {
+	switch f.Type {
+	case TypeMsg:
+		text := f.Text
+		if f.Deleted {
+			text = DeletedText
+		} else if f.Edited > 0 {
+			text += " (已编辑)"
+		}
+		from := f.From
+		// 机器人、插件与 Webhook 的消息带有标记，与同名用户区分
+		if f.Bot {
+			from += " [bot]"
+		}
+		line := f.idPrefix() + from + time.Unix(f.Time, 0).Format("[15:04:05]") + ": " + text
+		if f.Parent == "" {
+			return line
+		}
+		quote := f.Quote
+		if quote == "" {
+			quote = "回复的消息"
+		}
+		return "  ┌ #" + f.Parent + " " + quote + "\n" + line
+	case TypeEdit:
+		return f.idPrefix() + f.From + " 编辑了消息: " + f.Text
+	case TypeDelete:
+		return f.idPrefix() + "消息已被 " + f.From + " 删除"
+	default:
+		return f.Text
+	}
+}
+
+// idPrefix 消息编号的显示前缀，如 #12
+func (f Frame) idPrefix() string {
+	if f.ID == "" {
+		return ""
+	}
+	return "#" + f.ID + " "
+}
+
+// ParseFrame 解析 JSON 字符串为帧
+func ParseFrame(s string) (Frame, error) {
+	var f Frame
+	err := json.Unmarshal([]byte(s), &f)
+	return f, err
+}
+
+// EncodeFrame 编码帧
+func EncodeFrame(f Frame) ([]byte, error) {
+	s, err := f.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return Encode(s)
+}
+
+// DecodeFrame 解码帧
+func DecodeFrame(reader *bufio.Reader) (Frame, error) {
+	s, err := Decode(reader)
+	if err != nil {
+		return Frame{}, err
+	}
+	return ParseFrame(s)
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 )
 
 // Encode 将消息编码
@@ -24,26 +26,25 @@ func Encode(message string) ([]byte, error) {
 	return pkg.Bytes(), nil
 }
 
+// MaxLength 单条消息的最大长度
+const MaxLength = 1 << 20
+
 // Decode 解码消息
 func Decode(reader *bufio.Reader) (string, error) {
-	// 读取消息的长度
-	lengthByte, _ := reader.Peek(4) // 读取前4个字节的数据
-	lengthBuff := bytes.NewBuffer(lengthByte)
+	// 读取消息的长度(前4个字节)
 	var length int32
-	err := binary.Read(lengthBuff, binary.LittleEndian, &length)
+	err := binary.Read(reader, binary.LittleEndian, &length)
 	if err != nil {
 		return "", err
 	}
-	// Buffered返回缓冲中现有的可读取的字节数。
-	if int32(reader.Buffered()) < length+4 {
-		return "", err
+	if length < 0 || length > MaxLength {
+		return "", errors.New("invalid message length")
 	}
-
-	// 读取真正的消息数据
-	pack := make([]byte, int(4+length))
-	_, err = reader.Read(pack)
+	// 读取真正的消息数据，数据可能分多次到达
+	pack := make([]byte, length)
+	_, err = io.ReadFull(reader, pack)
 	if err != nil {
 		return "", err
 	}
-	return string(pack[4:]), nil
+	return string(pack), nil
 }
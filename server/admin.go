@@ -0,0 +1,254 @@
+package main
+
+import (
+	"easy-chat/proto"
+	"easy-chat/server/pkg"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminAPI HTTP 管理接口，未配置监听地址时为 nil
+var adminAPI *pkg.AdminAPI
+
+// startAdminAPI 开启 HTTP 管理接口，/api 下的接口都需要携带令牌访问
+// 未配置令牌密钥时无法签发与校验令牌，只开放使用各自令牌的入站 Webhook
+func startAdminAPI() {
+	if config.Admin.Addr == "" {
+		return
+	}
+	adminAPI = pkg.CreateAdminAPI()
+	if tokenManager.Enabled() {
+		adminAPI.SetAuthenticator(func(token string) (*pkg.TokenClaims, error) {
+			_, claims, err := verifyToken(token)
+			return claims, err
+		})
+		adminAPI.HandlePublic(http.MethodPost, "/api/login", loginHandler)
+		adminAPI.Handle(http.MethodPost, "/api/logout", logoutHandler)
+		adminAPI.Handle(http.MethodGet, "/api/stats", statsHandler)
+		adminAPI.Handle(http.MethodGet, "/api/audit", auditHandler)
+	} else {
+		console.Add("未配置令牌密钥，管理接口只开放入站 Webhook")
+	}
+	adminAPI.HandlePublic(http.MethodPost, "/hooks/", incomingWebhookHandler)
+	err := adminAPI.Start(config.Admin.Addr)
+	if err != nil {
+		console.Add("管理接口开启失败: " + err.Error())
+		logger.Error("start admin api failed, err:", err)
+		adminAPI = nil
+		return
+	}
+	console.Add("管理接口已开启: http://" + config.Admin.Addr)
+}
+
+// requestWorkspace 请求访问的工作区，即令牌所属的工作区，参数 workspace 与令牌不符时返回错误信息
+func requestWorkspace(w http.ResponseWriter, r *http.Request) (*pkg.Workspace, bool) {
+	claims := pkg.RequestClaims(r)
+	if claims == nil {
+		pkg.WriteError(w, http.StatusUnauthorized, "缺少令牌")
+		return nil, false
+	}
+	if name := r.URL.Query().Get("workspace"); name != "" && name != claims.Workspace {
+		pkg.WriteError(w, http.StatusForbidden, "令牌与工作区不匹配")
+		return nil, false
+	}
+	ws, ok := workspaces[claims.Workspace]
+	if !ok {
+		pkg.WriteError(w, http.StatusNotFound, "工作区不存在")
+	}
+	return ws, ok
+}
+
+// requestPermission 检查令牌的用户是否拥有权限，请求未携带令牌时拒绝
+func requestPermission(w http.ResponseWriter, r *http.Request, ws *pkg.Workspace, perm string) bool {
+	claims := pkg.RequestClaims(r)
+	if claims == nil {
+		pkg.WriteError(w, http.StatusUnauthorized, "缺少令牌")
+		return false
+	}
+	role, err := pkg.GetRole(r.Context(), ws.Store, claims.Subject, true)
+	if err != nil {
+		logger.Error(err.Error())
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !pkg.HasPermission(role, perm) {
+		pkg.WriteError(w, http.StatusForbidden, "权限不足，"+pkg.RoleName(role)+"没有 "+perm+" 权限")
+		return false
+	}
+	return true
+}
+
+// loginRequest 登录请求
+type loginRequest struct {
+	Workspace string `json:"workspace"`
+	NickName  string `json:"nickName"`
+	Password  string `json:"password"`
+}
+
+// loginHandler POST /api/login 使用账号密码换取令牌
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if accountPolicy.Mode() == pkg.AuthOpen {
+		pkg.WriteError(w, http.StatusForbidden, "服务端未开启账号")
+		return
+	}
+	var req loginRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req)
+	if err != nil {
+		pkg.WriteError(w, http.StatusBadRequest, "请求格式错误")
+		return
+	}
+	ws := defaultWorkspace
+	if req.Workspace != "" {
+		ws = workspaces[req.Workspace]
+	}
+	if ws == nil {
+		pkg.WriteError(w, http.StatusNotFound, "工作区不存在")
+		return
+	}
+	keys := pkg.LoginKeys(r.RemoteAddr, ws.Name, req.NickName)
+	if wait := loginGuard.Wait(keys, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		pkg.WriteError(w, http.StatusTooManyRequests, backoffReason(wait))
+		return
+	}
+	account, err := pkg.GetAccount(r.Context(), ws.Store, req.NickName)
+	if err != nil {
+		logger.Error(err.Error())
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if account == nil || !account.Verify(req.Password) {
+		loginGuard.Fail(keys, time.Now())
+		pkg.WriteError(w, http.StatusUnauthorized, "昵称或密码错误")
+		return
+	}
+	loginGuard.Succeed(ws.Name, req.NickName)
+	token, claims, err := tokenManager.Issue(ws.Name, account.Name)
+	if err != nil {
+		logger.Error("issue token failed, err:", err)
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pkg.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"token":     token,
+		"workspace": claims.Workspace,
+		"expiresAt": claims.ExpiresAt,
+	})
+}
+
+// logoutHandler POST /api/logout 吊销请求携带的令牌
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims := pkg.RequestClaims(r)
+	err := pkg.RevokeToken(r.Context(), workspaces[claims.Workspace].Store, claims)
+	if err != nil {
+		logger.Error(err.Error())
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pkg.WriteJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// statsHandler GET /api/stats 查看工作区统计数据
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	ws, ok := requestWorkspace(w, r)
+	if !ok || !requestPermission(w, r, ws, pkg.PermStats) {
+		return
+	}
+	report, err := pkg.CollectStats(r.Context(), ws.Store, time.Now())
+	if err != nil {
+		logger.Error(err.Error())
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pkg.WriteJSON(w, http.StatusOK, report)
+}
+
+// auditHandler GET /api/audit 查看工作区的审计事件，参数 nick 按操作者或目标过滤，limit 为返回最近的条数
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	ws, ok := requestWorkspace(w, r)
+	if !ok || !requestPermission(w, r, ws, pkg.PermAudit) {
+		return
+	}
+	query := r.URL.Query()
+	filter := pkg.AuditFilter{Workspace: ws.Name, NickName: query.Get("nick"), Limit: auditLimit}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			pkg.WriteError(w, http.StatusBadRequest, "无效的 limit: "+limit)
+			return
+		}
+		filter.Limit = n
+	}
+	events, err := auditLog.Query(filter)
+	if err == pkg.ErrAuditDisabled {
+		pkg.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pkg.WriteJSON(w, http.StatusOK, events)
+}
+
+// incomingWebhookRequest 入站 Webhook 请求
+type incomingWebhookRequest struct {
+	Text string `json:"text"`
+}
+
+// incomingWebhookHandler POST /hooks/<workspace>/<id>/<token> 以 Webhook 的名称向房间发送消息
+// 消息与客户端消息一样经过中间件链后广播并记录历史，不参与排行榜
+func incomingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/hooks/"), "/")
+	if len(parts) != 3 {
+		pkg.WriteError(w, http.StatusNotFound, "Webhook 不存在")
+		return
+	}
+	ws, ok := workspaces[parts[0]]
+	if !ok {
+		pkg.WriteError(w, http.StatusNotFound, "Webhook 不存在")
+		return
+	}
+	hook, err := pkg.VerifyIncomingWebhook(r.Context(), ws.Store, parts[1], parts[2])
+	if err != nil {
+		logger.Error(err.Error())
+		pkg.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if hook == nil {
+		pkg.WriteError(w, http.StatusNotFound, "Webhook 不存在")
+		return
+	}
+	var req incomingWebhookRequest
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req)
+	if err != nil {
+		pkg.WriteError(w, http.StatusBadRequest, "请求格式错误")
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		pkg.WriteError(w, http.StatusBadRequest, "text 不能为空")
+		return
+	}
+	frame := proto.Frame{Type: proto.TypeMsg, From: hook.Name, Room: hook.Room, Text: req.Text, Time: time.Now().Unix()}
+	mc := pkg.NewMessageContext(r.Context(), ws, nil, frame, false)
+	mc.Bot = true
+	messageChain.Run(mc)
+	for _, f := range mc.Emitted() {
+		publish(ws, f)
+	}
+	if !mc.Delivered() {
+		reason := mc.DropReason()
+		if replies := mc.Replies(); len(replies) > 0 {
+			reason = replies[0].Text
+		}
+		pkg.WriteError(w, http.StatusUnprocessableEntity, reason)
+		return
+	}
+	pkg.WriteJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
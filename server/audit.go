@@ -0,0 +1,52 @@
+package main
+
+import (
+	"easy-chat/server/pkg"
+	"strconv"
+	"time"
+)
+
+// auditLimit 审计日志默认查看的条数
+const auditLimit = 20
+
+// recordAudit 记录工作区内的管理操作，写入失败时只记录运行日志
+func recordAudit(ws *pkg.Workspace, event pkg.AuditEvent) {
+	event.Workspace = ws.Name
+	err := auditLog.Record(event)
+	if err != nil {
+		console.Add("审计日志写入失败: " + err.Error())
+		logger.Error(err.Error())
+	}
+}
+
+// durationText 处罚时长的显示文本
+func durationText(d time.Duration) string {
+	if d == 0 {
+		return "永久"
+	}
+	return d.String()
+}
+
+// auditCommand 服务端终端查看工作区最近的审计事件，可按昵称过滤
+func auditCommand(ws *pkg.Workspace, args []string) string {
+	if len(args) > 1 {
+		return "用法: /audit [nick]"
+	}
+	filter := pkg.AuditFilter{Workspace: ws.Name, Limit: auditLimit}
+	if len(args) == 1 {
+		filter.NickName = args[0]
+	}
+	events, err := auditLog.Query(filter)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	if len(events) == 0 {
+		return "暂无审计事件"
+	}
+	msg := "最近 " + strconv.Itoa(len(events)) + " 条审计事件:"
+	for _, e := range events {
+		msg += "\n" + e.String()
+	}
+	return msg
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"easy-chat/proto"
+	"easy-chat/server/pkg"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxRoomNameLength 房间名称的最大长度
+const maxRoomNameLength = 32
+
+// mutedCommands 发布内容的命令，被禁言的用户不能执行
+var mutedCommands = map[string]bool{
+	"/topic":    true,
+	"/announce": true,
+}
+
+// handleCommand 处理客户端命令，执行前检查发送者的权限，结果只发送给命令发送者
+func handleCommand(ws *pkg.Workspace, conn net.Conn, frame proto.Frame) {
+	args := strings.Fields(frame.Text)
+	if len(args) == 0 {
+		return
+	}
+	var reply string
+	if perm, ok := pkg.CommandPermission(args[0]); ok {
+		reply = checkPermission(ws, conn, perm)
+	}
+	if reply == "" && mutedCommands[args[0]] {
+		reply = checkMuted(ws, conn)
+	}
+	switch {
+	case reply != "":
+	case args[0] == "/help":
+		reply = "/help\t帮助\n" +
+			"/rank [day|week|month|all] [room]\t查看用户活跃排行榜\n" +
+			"/stats\t查看聊天统计\n" +
+			"/register <password>\t将当前昵称注册为账号\n" +
+			"/logout\t吊销本次登录的令牌，下次登录需要输入密码\n" +
+			"/nick <new>\t修改昵称(仅限游客)\n" +
+			"/role\t查看自己的角色\n" +
+			"/room\t查看当前房间信息\n" +
+			"/join <room>\t进入房间，房间不存在时创建(需要 createRoom 权限)\n" +
+			"/reply <id> <text>\t回复当前房间的消息\n" +
+			"/thread <id>\t查看消息所在的讨论\n" +
+			"/edit <id> <text>\t编辑自己在当前房间发送的消息\n" +
+			"/delete <id> [reason]\t删除当前房间的消息，删除他人的消息需要 delete 权限\n" +
+			"/topic <text>\t设置当前房间的话题(需要 topic 权限)\n" +
+			"/announce <text>\t向工作区发送公告(需要 announce 权限)\n" +
+			"/kick <nick> [reason]\t将用户踢出聊天室(需要 kick 权限)\n" +
+			"/mute <nick> <duration>\t禁言用户，时长如 30m、2h、7d，0 表示永久(需要 mute 权限)\n" +
+			"/unmute <nick>\t解除禁言(需要 mute 权限)\n" +
+			"/ban <nick|ip|cidr> <duration> [reason]\t封禁用户、IP 或网段(需要 ban 权限)\n" +
+			"/unban <nick|ip|cidr>\t解除封禁(需要 ban 权限)\n" +
+			"/bans\t查看封禁列表(需要 ban 权限)\n" +
+			"exit\t退出聊天室"
+	case args[0] == "/rank":
+		reply = rankCommand(ws, args[1:])
+	case args[0] == "/stats":
+		reply = statsCommand(ws)
+	case args[0] == "/logout":
+		reply = logoutCommand(ws, conn)
+	case args[0] == "/nick":
+		reply = nickCommand(ws, conn, args[1:])
+	case args[0] == "/role":
+		role, err := userRole(ws, conn)
+		if err != nil {
+			logger.Error(err.Error())
+			reply = err.Error()
+			break
+		}
+		reply = "你的角色: " + pkg.RoleName(role) + "(" + role + ")"
+	case args[0] == "/room":
+		state, _ := connList.Get(conn)
+		room, err := pkg.ShowRoom(ctx, ws.Store, state.Room)
+		if err != nil {
+			logger.Error(err.Error())
+			reply = err.Error()
+			break
+		}
+		reply = room
+	case args[0] == "/join":
+		reply = joinCommand(ws, conn, args[1:])
+	case args[0] == "/thread":
+		reply = threadCommand(ws, conn, args[1:])
+	case args[0] == "/edit":
+		if reply = editCommand(ws, conn, frame.Text); reply == "" {
+			return
+		}
+	case args[0] == "/delete":
+		if reply = deleteCommand(ws, conn, args[1:]); reply == "" {
+			return
+		}
+	case args[0] == "/topic":
+		reply = topicCommand(ws, conn, frame.Text)
+	case args[0] == "/announce":
+		text := strings.TrimSpace(strings.TrimPrefix(frame.Text, "/announce"))
+		if text == "" {
+			reply = "用法: /announce <text>"
+			break
+		}
+		publish(ws, proto.Frame{Type: proto.TypeSys, Text: "[公告] " + text})
+		return
+	case moderationUsage[args[0]] != "":
+		role, err := userRole(ws, conn)
+		if err != nil {
+			logger.Error(err.Error())
+			reply = err.Error()
+			break
+		}
+		state, _ := connList.Get(conn)
+		reply = moderationCommand(ws, state.NickName, role, args)
+	default:
+		var ok bool
+		if reply, ok = pluginCommand(ws, frame, args); !ok {
+			reply = "无效命令，输入/help获取帮助"
+		} else if reply == "" {
+			return // 由插件回复
+		}
+	}
+	err := connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: reply})
+	if err != nil {
+		logger.Error("send command reply failed, err:", err)
+	}
+}
+
+// userRole 连接的用户的角色
+func userRole(ws *pkg.Workspace, conn net.Conn) (string, error) {
+	state, _ := connList.Get(conn)
+	return pkg.GetRole(ctx, ws.Store, state.NickName, state.Registered)
+}
+
+// checkPermission 检查连接的用户是否拥有权限，没有权限时返回提示
+func checkPermission(ws *pkg.Workspace, conn net.Conn, perm string) string {
+	role, err := userRole(ws, conn)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return pkg.CheckPermission(role, perm)
+}
+
+// checkMuted 检查连接的用户是否被禁言，被禁言时返回提示
+func checkMuted(ws *pkg.Workspace, conn net.Conn) string {
+	state, _ := connList.Get(conn)
+	muted, until, err := pkg.MutedUntil(ctx, ws.Store, state.NickName)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	if muted {
+		return "你已被禁言，" + pkg.UntilText(until)
+	}
+	return ""
+}
+
+// nickCommand 修改昵称，同时更新在线用户登记并迁移排行榜分数
+// 注册用户的昵称与账号绑定，不能修改
+func nickCommand(ws *pkg.Workspace, conn net.Conn, args []string) string {
+	if len(args) != 1 {
+		return "用法: /nick <new>"
+	}
+	state, _ := connList.Get(conn)
+	old, nickName := state.NickName, args[0]
+	presence, err := ws.Store.GetPresence(ctx)
+	if err != nil {
+		logger.Error("get presence failed, err:", err)
+		return "获取在线用户失败: " + err.Error()
+	}
+	names := make([]string, 0, len(presence))
+	for name := range presence {
+		names = append(names, name)
+	}
+	if reason := nickPolicy.CheckRename(old, nickName, state.Registered, names); reason != "" {
+		return reason
+	}
+	if reason := webhookNameReason(ws, nickName); reason != "" {
+		return reason
+	}
+	if reason := pluginNameReason(nickName); reason != "" {
+		return reason
+	}
+	if findBan(ws, nickName, state.Add) != nil {
+		return "昵称 " + nickName + " 已被封禁"
+	}
+	// 禁言按昵称记录，改名会绕过禁言
+	muted, _, err := pkg.MutedUntil(ctx, ws.Store, old)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	if muted {
+		return "禁言期间不能修改昵称"
+	}
+	if accountPolicy.Mode() != pkg.AuthOpen {
+		account, err := pkg.GetAccount(ctx, ws.Store, nickName)
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if account != nil {
+			return "昵称 " + nickName + " 已注册"
+		}
+	}
+	// 先在集群中登记新昵称，成功后再释放旧昵称
+	ok, err := ws.Store.AddPresence(ctx, nickName)
+	if err != nil {
+		logger.Error("add presence failed, err:", err)
+		return "修改昵称失败，请稍后重试"
+	}
+	if !ok || !connList.Rename(conn, nickName) {
+		if ok {
+			_ = ws.Store.DelPresence(ctx, nickName)
+		}
+		return "昵称重复"
+	}
+	if err = ws.Store.DelPresence(ctx, old); err != nil {
+		logger.Error("del presence failed, err:", err)
+	}
+	if err = pkg.RenameActivity(ctx, ws.Store, scorePolicy, old, nickName); err != nil {
+		logger.Error(err.Error())
+	}
+	scorePolicy.Forget(ws.Name, old)
+	publish(ws, proto.Frame{Type: proto.TypeSys, Room: state.Room, Text: old + " 改名为 " + nickName})
+	console.Add("用户改名，工作区:" + ws.Name + "，" + old + " -> " + nickName)
+	return "昵称已修改为 " + nickName
+}
+
+// joinCommand 进入房间，房间不存在时需要 createRoom 权限
+func joinCommand(ws *pkg.Workspace, conn net.Conn, args []string) string {
+	if len(args) != 1 {
+		return "用法: /join <room>"
+	}
+	room := args[0]
+	if len([]rune(room)) > maxRoomNameLength {
+		return "房间名称过长"
+	}
+	state, _ := connList.Get(conn)
+	if state.Room == room {
+		return "你已在房间 " + room + " 中"
+	}
+	meta, err := pkg.GetRoomMeta(ctx, ws.Store, room)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	if meta["created"] == "" {
+		if reason := checkPermission(ws, conn, pkg.PermCreateRoom); reason != "" {
+			return "房间 " + room + " 不存在，" + reason
+		}
+		err = pkg.SetRoomMeta(ctx, ws.Store, room, "created", time.Now().Format("2006-01-02 15:04:05"))
+		if err == nil {
+			err = pkg.SetRoomMeta(ctx, ws.Store, room, "creator", state.NickName)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+	}
+	old := state.Room
+	connList.Update(conn, func(info *pkg.ConnInfo) {
+		info.Room = room
+	})
+	publish(ws, proto.Frame{Type: proto.TypeSys, Room: old, Text: state.NickName + " 离开了房间 " + old})
+	publish(ws, proto.Frame{Type: proto.TypeSys, Room: room, Text: state.NickName + " 进入了房间 " + room})
+	if topic := meta["topic"]; topic != "" {
+		return "已进入房间 " + room + "，话题: " + topic
+	}
+	return "已进入房间 " + room
+}
+
+// topicCommand 设置当前房间的话题
+func topicCommand(ws *pkg.Workspace, conn net.Conn, text string) string {
+	topic := strings.TrimSpace(strings.TrimPrefix(text, "/topic"))
+	if topic == "" {
+		return "用法: /topic <text>"
+	}
+	state, _ := connList.Get(conn)
+	err := pkg.SetRoomMeta(ctx, ws.Store, state.Room, "topic", topic)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	publish(ws, proto.Frame{Type: proto.TypeSys, Room: state.Room, Text: state.NickName + " 将房间话题设置为: " + topic})
+	return "话题已更新"
+}
+
+// roleCommand 服务端终端查看权限矩阵、查看或指定用户角色
+func roleCommand(ws *pkg.Workspace, args []string) string {
+	switch len(args) {
+	case 0:
+		return pkg.ShowPermissions()
+	case 1:
+		account, err := pkg.GetAccount(ctx, ws.Store, args[0])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		role, err := pkg.GetRole(ctx, ws.Store, args[0], account != nil)
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		return "用户 " + args[0] + " 的角色: " + pkg.RoleName(role) + "(" + role + ")"
+	case 2:
+		err := pkg.SetRole(ctx, ws.Store, args[0], args[1])
+		if err != nil {
+			return err.Error()
+		}
+		recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditRole, Actor: consoleActor, Target: args[0], Detail: args[1]})
+		return "已将用户 " + args[0] + " 的角色设置为 " + pkg.RoleName(args[1])
+	default:
+		return "用法: /role [nick] [role]"
+	}
+}
+
+// botCommand 服务端终端查看机器人账号，标记或取消标记注册账号为机器人
+// 标记后从排行榜中移除，用户重新登录后按机器人账号限流
+func botCommand(ws *pkg.Workspace, args []string) string {
+	switch len(args) {
+	case 0:
+		bots, err := pkg.BotAccounts(ctx, ws.Store)
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if len(bots) == 0 {
+			return "工作区 " + ws.Name + " 没有机器人账号"
+		}
+		names := make([]string, 0, len(bots))
+		for name := range bots {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "机器人账号: " + strings.Join(names, ", ")
+	case 1:
+		account, err := pkg.GetAccount(ctx, ws.Store, args[0])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		switch {
+		case account == nil:
+			return "账号不存在: " + args[0]
+		case account.Bot:
+			return args[0] + " 是机器人账号"
+		default:
+			return args[0] + " 不是机器人账号"
+		}
+	case 2:
+		if args[1] != "on" && args[1] != "off" {
+			return "用法: /bot [nick] [on|off]"
+		}
+		err := pkg.SetBot(ctx, ws.Store, args[0], args[1] == "on")
+		if err != nil {
+			return err.Error()
+		}
+		recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditBot, Actor: consoleActor, Target: args[0], Detail: args[1]})
+		if args[1] == "off" {
+			return "已取消 " + args[0] + " 的机器人标记，重新登录后生效"
+		}
+		if err = pkg.RemoveActivity(ctx, ws.Store, scorePolicy, args[0]); err != nil {
+			logger.Error(err.Error())
+		}
+		return "已将 " + args[0] + " 标记为机器人账号，重新登录后生效"
+	default:
+		return "用法: /bot [nick] [on|off]"
+	}
+}
+
+// rankCommand 查看排行榜，参数为 [时间窗口] [房间]
+func rankCommand(ws *pkg.Workspace, args []string) string {
+	var window, room string
+	if len(args) > 0 {
+		window = args[0]
+	}
+	if len(args) > 1 {
+		room = args[1]
+	}
+	rank, err := pkg.ShowRank(ctx, ws.Store, scorePolicy, window, room)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return rank
+}
+
+// logoutCommand 吊销连接本次登录使用的令牌
+func logoutCommand(ws *pkg.Workspace, conn net.Conn) string {
+	state, _ := connList.Get(conn)
+	claims := state.Token
+	if claims == nil {
+		return "本次登录未使用令牌"
+	}
+	err := pkg.RevokeToken(ctx, ws.Store, claims)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return "令牌已吊销，下次登录需要输入密码"
+}
+
+// statsCommand 查看工作区统计数据
+func statsCommand(ws *pkg.Workspace) string {
+	report, err := pkg.CollectStats(ctx, ws.Store, time.Now())
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return report.String()
+}
+
+// registerCommand 游客将当前昵称注册为账号
+func registerCommand(ws *pkg.Workspace, conn net.Conn, text string) {
+	args := strings.Fields(text)
+	state, _ := connList.Get(conn)
+	var reply string
+	switch {
+	case args[0] != "/register":
+		reply = "无效命令，输入/help获取帮助"
+	case len(args) != 2:
+		reply = "用法: /register <password>"
+	case state.Registered:
+		reply = "当前昵称已注册"
+	default:
+		reason, err := accountPolicy.Register(ctx, ws.Store, state.NickName, args[1])
+		if err != nil {
+			logger.Error("register failed, err:", err)
+			reason = "注册失败，请稍后重试"
+		}
+		reply = reason
+		if reason == "" {
+			connList.Update(conn, func(info *pkg.ConnInfo) {
+				info.Registered = true
+			})
+			reply = "注册成功，下次登录时请输入密码"
+			console.Add("新用户注册，工作区:" + ws.Name + "，用户昵称:" + state.NickName)
+		}
+	}
+	err := connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: reply})
+	if err != nil {
+		logger.Error("send command reply failed, err:", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"easy-chat/proto"
+	"easy-chat/server/pkg"
+	"strconv"
+	"time"
+)
+
+// defaultFilterReload 默认检查规则文件变化的间隔
+const defaultFilterReload = 5 * time.Second
+
+// notifyModerators 将审核提示发送给本节点工作区内的版主与管理员
+func notifyModerators(ws *pkg.Workspace, frame proto.Frame) {
+	console.Add(frame.Text)
+	for _, conn := range connList.GetWorkspaceConns(ws.Name) {
+		state, ok := connList.Get(conn)
+		if !ok {
+			continue
+		}
+		role, err := pkg.GetRole(ctx, ws.Store, state.NickName, state.Registered)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		if !pkg.HasPermission(role, pkg.PermKick) {
+			continue
+		}
+		err = connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: frame.Text})
+		if err != nil {
+			logger.Error("send flag notice failed, err:", err)
+		}
+	}
+}
+
+// watchFilter 定期检查规则文件，变化后重新加载并记录审计事件
+func watchFilter() {
+	interval := time.Duration(config.Filter.ReloadInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultFilterReload
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, count, err := contentFilter.Reload()
+		if err != nil {
+			console.Add("内容过滤规则加载失败，继续使用原有规则: " + err.Error())
+			logger.Error("reload filter failed, err:", err)
+			continue
+		}
+		if !reloaded {
+			continue
+		}
+		console.Add("内容过滤规则已重新加载，共 " + strconv.Itoa(count) + " 条")
+		err = auditLog.Record(pkg.AuditEvent{
+			Action: pkg.AuditReload,
+			Actor:  systemActor,
+			Target: contentFilter.Path(),
+			Detail: strconv.Itoa(count) + " 条规则",
+		})
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
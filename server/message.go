@@ -0,0 +1,127 @@
+package main
+
+import (
+	"easy-chat/proto"
+	"easy-chat/server/pkg"
+	"net"
+	"strings"
+	"time"
+)
+
+// threadScanLimit 不限制历史消息条数时，查看讨论最多查找的历史消息条数
+const threadScanLimit = 1000
+
+// findMessage 在连接所在房间的历史中查找消息，编号可带 # 前缀，找不到时返回提示
+func findMessage(ws *pkg.Workspace, conn net.Conn, id string) (*proto.Frame, string) {
+	id = strings.TrimPrefix(id, "#")
+	state, _ := connList.Get(conn)
+	msg, err := pkg.GetMessage(ctx, ws.Store, state.Room, id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err.Error()
+	}
+	if msg == nil {
+		return nil, "当前房间没有消息 #" + id + "，消息可能已过期"
+	}
+	if msg.Deleted {
+		return nil, "消息 #" + id + " 已被删除"
+	}
+	return msg, ""
+}
+
+// editCommand 编辑自己发送的消息，新内容同样经过内容过滤，编辑结果广播给房间内的用户
+func editCommand(ws *pkg.Workspace, conn net.Conn, text string) string {
+	args := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(text, "/edit")), " ", 2)
+	if len(args) != 2 || strings.TrimSpace(args[1]) == "" {
+		return "用法: /edit <id> <text>"
+	}
+	state, _ := connList.Get(conn)
+	msg, reply := findMessage(ws, conn, args[0])
+	if reply != "" {
+		return reply
+	}
+	if msg.From != state.NickName {
+		return "只能编辑自己发送的消息"
+	}
+	if reply = checkMuted(ws, conn); reply != "" {
+		return reply
+	}
+	result := contentFilter.Check(strings.TrimSpace(args[1]))
+	if result.Blocked {
+		return "消息包含被禁止的内容，未修改"
+	}
+	if result.Flagged {
+		publish(ws, proto.Frame{
+			Type: proto.TypeFlag,
+			Text: "[审核] " + msg.From + " 在房间 " + msg.Room + " 编辑的消息 #" + msg.ID + " 命中规则 " + strings.Join(result.Rules, ", ") + ": " + result.Text,
+		})
+	}
+	now := time.Now().Unix()
+	msg.Text, msg.Edited = result.Text, now
+	if err := pkg.SaveMessage(ctx, ws.Store, *msg); err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	publish(ws, proto.Frame{Type: proto.TypeEdit, ID: msg.ID, From: msg.From, Room: msg.Room, Text: msg.Text, Time: now})
+	return ""
+}
+
+// deleteCommand 删除消息，历史中保留删除标记
+// 用户可以删除自己的消息，拥有 delete 权限的用户可以删除角色级别更低的用户的消息，并记录审计日志
+func deleteCommand(ws *pkg.Workspace, conn net.Conn, args []string) string {
+	if len(args) < 1 {
+		return "用法: /delete <id> [reason]"
+	}
+	state, _ := connList.Get(conn)
+	msg, reply := findMessage(ws, conn, args[0])
+	if reply != "" {
+		return reply
+	}
+	author := msg.From
+	if author != state.NickName {
+		role, err := userRole(ws, conn)
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if !pkg.HasPermission(role, pkg.PermDelete) {
+			return "只能删除自己发送的消息"
+		}
+		if reply = checkTarget(ws, state.NickName, role, author); reply != "" {
+			return reply
+		}
+	}
+	msg.Text, msg.Deleted = "", true
+	if err := pkg.SaveMessage(ctx, ws.Store, *msg); err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	publish(ws, proto.Frame{Type: proto.TypeDelete, ID: msg.ID, From: state.NickName, Room: msg.Room, Time: time.Now().Unix()})
+	if author != state.NickName {
+		recordAudit(ws, pkg.AuditEvent{
+			Action: pkg.AuditDelete,
+			Actor:  state.NickName,
+			Target: author,
+			Reason: strings.Join(args[1:], " "),
+			Detail: "消息 #" + msg.ID + " 房间 " + msg.Room,
+		})
+	}
+	return ""
+}
+
+// threadCommand 查看当前房间中消息所在的讨论
+func threadCommand(ws *pkg.Workspace, conn net.Conn, args []string) string {
+	if len(args) != 1 {
+		return "用法: /thread <id>"
+	}
+	n := config.App.HistoryLimit
+	if n <= 0 {
+		n = threadScanLimit
+	}
+	state, _ := connList.Get(conn)
+	thread, err := pkg.ShowThread(ctx, ws.Store, state.Room, strings.TrimPrefix(args[0], "#"), n)
+	if err != nil {
+		return err.Error()
+	}
+	return thread
+}
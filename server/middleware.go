@@ -0,0 +1,66 @@
+package main
+
+import (
+	"easy-chat/server/pkg"
+	"time"
+)
+
+// newMessageChain 创建消息中间件链，按注册顺序执行，全部放行后由 deliverMessage 投递
+func newMessageChain() *pkg.MessageChain {
+	chain := pkg.NewMessageChain(deliverMessage)
+	chain.Use(pkg.LoggingMiddleware(logger))
+	chain.Use(pkg.RankMiddleware(scorePolicy, logger))
+	chain.Use(pkg.MiddlewareFunc("mute", muteMiddleware))
+	chain.Use(pkg.FilterMiddleware(contentFilter, scorePolicy, logger))
+	chain.Use(pkg.ReplyMiddleware(scorePolicy, logger))
+	if len(plugins) > 0 {
+		chain.Use(pkg.MiddlewareFunc("plugin", pluginMiddleware))
+	}
+	if len(webhooks) > 0 {
+		chain.Use(pkg.MiddlewareFunc("webhook", webhookMiddleware))
+	}
+	return chain
+}
+
+// muteMiddleware 拦截被禁言用户的消息
+func muteMiddleware(mc *pkg.MessageContext, next func()) {
+	muted, until, err := pkg.MutedUntil(mc.Ctx, mc.Workspace.Store, mc.Frame.From)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	if muted {
+		mc.Reply("你已被禁言，" + pkg.UntilText(until))
+		mc.Drop("muted")
+		return
+	}
+	next()
+}
+
+// deliverMessage 广播消息并记录历史与统计
+// 消息编号分配失败时消息仍然投递，只是不能被编辑、删除
+func deliverMessage(mc *pkg.MessageContext) {
+	id, err := pkg.NextMessageID(mc.Ctx, mc.Workspace.Store)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	mc.Frame.ID, mc.Frame.Bot = id, mc.Bot
+	ws, frame := mc.Workspace, mc.Frame
+	// 历史中只保存父消息编号，预览在显示时按父消息的当前内容生成
+	stored := frame
+	stored.Quote = ""
+	msg, err := stored.Marshal()
+	if err != nil {
+		logger.Error("encode msg failed, err:", err)
+		mc.Drop("encode failed")
+		return
+	}
+	publish(ws, frame)
+	err = ws.Store.AddHistory(mc.Ctx, frame.Room, msg)
+	if err != nil {
+		logger.Error("add history failed,err:", err.Error())
+	}
+	err = pkg.RecordMessage(mc.Ctx, ws.Store, frame.Room, time.Now())
+	if err != nil {
+		logger.Error("record message failed,err:", err.Error())
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"easy-chat/proto"
+	"easy-chat/server/pkg"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// 操作者名称
+const (
+	consoleActor = "console" // 服务端终端执行的管理操作，不受角色限制
+	systemActor  = "system"  // 服务端自动执行的处罚，如刷屏自动禁言
+)
+
+// moderationUsage 管理命令用法
+var moderationUsage = map[string]string{
+	"/kick":   "/kick <nick> [reason]",
+	"/mute":   "/mute <nick> <duration>",
+	"/unmute": "/unmute <nick>",
+	"/ban":    "/ban <nick|ip|cidr> <duration> [reason]",
+	"/unban":  "/unban <nick|ip|cidr>",
+	"/bans":   "/bans",
+}
+
+// moderationCommand 执行管理命令，服务端终端与有权限的用户共用，actorRole 为操作者的角色
+func moderationCommand(ws *pkg.Workspace, actor string, actorRole string, args []string) string {
+	usage := "用法: " + moderationUsage[args[0]]
+	switch args[0] {
+	case "/kick":
+		if len(args) < 2 {
+			return usage
+		}
+		return kickUser(ws, actor, actorRole, args[1], strings.Join(args[2:], " "))
+	case "/mute":
+		if len(args) != 3 {
+			return usage
+		}
+		d, err := pkg.ParseDuration(args[2])
+		if err != nil {
+			return err.Error()
+		}
+		return muteUser(ws, actor, actorRole, args[1], d)
+	case "/unmute":
+		if len(args) != 2 {
+			return usage
+		}
+		if msg := checkTarget(ws, actor, actorRole, args[1]); msg != "" {
+			return msg
+		}
+		ok, err := pkg.Unmute(ctx, ws.Store, args[1])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if !ok {
+			return "用户 " + args[1] + " 未被禁言"
+		}
+		publish(ws, proto.Frame{Type: proto.TypeSys, Text: args[1] + " 已被解除禁言"})
+		recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditUnmute, Actor: actor, Target: args[1]})
+		return "已解除 " + args[1] + " 的禁言"
+	case "/ban":
+		if len(args) < 3 {
+			return usage
+		}
+		d, err := pkg.ParseDuration(args[2])
+		if err != nil {
+			return err.Error()
+		}
+		return banTarget(ws, actor, actorRole, args[1], d, strings.Join(args[3:], " "))
+	case "/unban":
+		if len(args) != 2 {
+			return usage
+		}
+		if msg := checkTarget(ws, actor, actorRole, args[1]); msg != "" {
+			return msg
+		}
+		ban, err := pkg.GetBan(ctx, ws.Store, args[1])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if ban == nil {
+			return args[1] + " 未被封禁"
+		}
+		if msg := checkIssuer(ws, actor, actorRole, ban.By); msg != "" {
+			return msg
+		}
+		ok, err := pkg.RemoveBan(ctx, ws.Store, args[1])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if !ok {
+			return args[1] + " 未被封禁"
+		}
+		recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditUnban, Actor: actor, Target: args[1]})
+		return "已解除 " + args[1] + " 的封禁"
+	case "/bans":
+		return showBans(ws)
+	}
+	return "无效命令，输入/help获取帮助"
+}
+
+// checkTarget 检查操作者能否处理目标用户，不能处理自己，只能处理角色级别更低的用户，服务端终端不受限制
+func checkTarget(ws *pkg.Workspace, actor string, actorRole string, target string) string {
+	if actor == consoleActor {
+		return ""
+	}
+	// IP 与网段没有角色，按本节点上工作区内地址匹配的在线用户检查
+	if pkg.IsAddressTarget(target) {
+		for _, info := range connList.FindInfos(ws.Name, target) {
+			if info.NickName == actor {
+				return "不能对自己所在的地址执行该操作"
+			}
+			role, err := pkg.GetRole(ctx, ws.Store, info.NickName, info.Registered)
+			if err != nil {
+				logger.Error(err.Error())
+				return err.Error()
+			}
+			if pkg.RoleLevel(role) >= pkg.RoleLevel(actorRole) {
+				return "权限不足，该地址上有" + pkg.RoleName(role) + " " + info.NickName
+			}
+		}
+		return ""
+	}
+	role, err := nickRole(ws, target)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return pkg.CanModerate(actor, actorRole, target, role)
+}
+
+// checkIssuer 检查操作者能否撤销 by 执行的处罚，服务端终端不受限制，自动处罚可以由有权限的用户撤销
+func checkIssuer(ws *pkg.Workspace, actor string, actorRole string, by string) string {
+	switch {
+	case actor == consoleActor || by == systemActor:
+		return ""
+	case by == consoleActor:
+		return "权限不足，该处罚由服务端终端执行"
+	}
+	role, err := nickRole(ws, by)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return pkg.CanRevoke(actor, actorRole, by, role)
+}
+
+// nickRole 按昵称获取用户的角色，昵称已注册时按注册用户处理
+func nickRole(ws *pkg.Workspace, nickName string) (string, error) {
+	account, err := pkg.GetAccount(ctx, ws.Store, nickName)
+	if err != nil {
+		return "", err
+	}
+	return pkg.GetRole(ctx, ws.Store, nickName, account != nil)
+}
+
+// kickUser 将在线用户踢出聊天室
+func kickUser(ws *pkg.Workspace, actor string, actorRole string, target string, reason string) string {
+	if msg := checkTarget(ws, actor, actorRole, target); msg != "" {
+		return msg
+	}
+	presence, err := ws.Store.GetPresence(ctx)
+	if err != nil {
+		logger.Error("get presence failed, err:", err)
+		return "获取在线用户失败: " + err.Error()
+	}
+	if _, ok := presence[target]; !ok {
+		return "用户 " + target + " 不在线"
+	}
+	text := "你已被 " + actor + " 踢出聊天室"
+	if reason != "" {
+		text += "，原因: " + reason
+	}
+	publish(ws, proto.Frame{Type: proto.TypeKick, From: target, Text: text})
+	publish(ws, proto.Frame{Type: proto.TypeSys, Text: target + " 被踢出聊天室"})
+	recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditKick, Actor: actor, Target: target, Reason: reason})
+	return "已将 " + target + " 踢出聊天室"
+}
+
+// muteUser 禁言用户
+func muteUser(ws *pkg.Workspace, actor string, actorRole string, target string, d time.Duration) string {
+	if msg := checkTarget(ws, actor, actorRole, target); msg != "" {
+		return msg
+	}
+	until, err := pkg.Mute(ctx, ws.Store, target, d)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	publish(ws, proto.Frame{Type: proto.TypeSys, Text: target + " 已被禁言，" + pkg.UntilText(until)})
+	recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditMute, Actor: actor, Target: target, Detail: durationText(d)})
+	return "已禁言 " + target
+}
+
+// banTarget 封禁昵称、IP 或网段，并断开匹配的连接
+func banTarget(ws *pkg.Workspace, actor string, actorRole string, target string, d time.Duration, reason string) string {
+	if msg := checkTarget(ws, actor, actorRole, target); msg != "" {
+		return msg
+	}
+	ban, err := pkg.AddBan(ctx, ws.Store, target, actor, d, reason)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	publish(ws, proto.Frame{Type: proto.TypeKick, From: target, Text: ban.String()})
+	recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditBan, Actor: actor, Target: target, Reason: reason, Detail: durationText(d)})
+	return "已封禁 " + target + "，" + pkg.UntilText(ban.Until)
+}
+
+// showBans 查看工作区未到期的封禁
+func showBans(ws *pkg.Workspace) string {
+	bans, err := pkg.ListBans(ctx, ws.Store)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	return banList("封禁列表:", "暂无封禁", bans)
+}
+
+// banList 封禁列表的显示文本
+func banList(title string, empty string, bans []*pkg.Ban) string {
+	if len(bans) == 0 {
+		return empty
+	}
+	msg := title
+	for _, ban := range bans {
+		msg += fmt.Sprintf("\n%s  操作者: %s  %s", ban.Target, ban.By, pkg.UntilText(ban.Until))
+		if ban.Reason != "" {
+			msg += "  原因: " + ban.Reason
+		}
+	}
+	return msg
+}
+
+// kickLocal 断开本节点上匹配目标的连接，断开前发送提示
+func kickLocal(ws *pkg.Workspace, frame proto.Frame) {
+	for _, conn := range connList.FindConns(ws.Name, frame.From) {
+		_ = connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: frame.Text})
+		console.Add("断开连接:" + conn.RemoteAddr().String() + "，" + frame.Text)
+		_ = conn.Close()
+	}
+}
+
+// floodControl 检查会话是否超出限流，超出时丢弃消息并逐步升级为警告、自动禁言或断开连接
+// 返回 false 时消息不进入消息队列
+func floodControl(ws *pkg.Workspace, conn net.Conn, frame proto.Frame) bool {
+	state, _ := connList.Get(conn)
+	verdict, reason := state.Flood.Check(frame.Text, frame.Type == proto.TypeMsg, time.Now())
+	var notice string
+	switch verdict {
+	case pkg.FloodAllow:
+		return true
+	case pkg.FloodThrottle:
+		notice = reason + "，消息未发送"
+	case pkg.FloodWarn:
+		notice = "警告: " + reason + "，继续刷屏将被"
+		if state.Flood.Limit().Action() == pkg.FloodDisconnect {
+			notice += "断开连接"
+		} else {
+			notice += "禁言"
+		}
+	case pkg.FloodPunish:
+		floodPunish(ws, conn, state.Flood.Limit(), reason)
+		return false
+	}
+	err := connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: notice})
+	if err != nil {
+		logger.Error("send flood notice failed, err:", err)
+	}
+	return false
+}
+
+// floodPunish 按触发处罚的限流策略处罚多次超出限流的用户，机器人账号按机器人的限流策略处罚
+func floodPunish(ws *pkg.Workspace, conn net.Conn, limit *pkg.RateLimit, reason string) {
+	state, _ := connList.Get(conn)
+	nickName := state.NickName
+	if limit.Action() == pkg.FloodDisconnect {
+		_ = connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: "你因刷屏被断开连接"})
+		recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditKick, Actor: systemActor, Target: nickName, Reason: reason})
+		console.Add("用户刷屏，断开连接，工作区:" + ws.Name + "，用户昵称:" + nickName)
+		_ = conn.Close()
+		return
+	}
+	// 已被禁言时不再重复处罚，避免处罚提示本身刷屏
+	muted, _, err := pkg.MutedUntil(ctx, ws.Store, nickName)
+	if err != nil || muted {
+		return
+	}
+	until, err := pkg.Mute(ctx, ws.Store, nickName, limit.MuteDuration())
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	publish(ws, proto.Frame{Type: proto.TypeSys, Text: nickName + " 因刷屏被禁言，" + pkg.UntilText(until)})
+	recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditMute, Actor: systemActor, Target: nickName, Reason: reason, Detail: durationText(limit.MuteDuration())})
+}
+
+// findBan 查找匹配的工作区封禁，只检查该工作区的昵称、IP 与网段封禁
+// 读取封禁失败时放行(fail open)：存储故障时拒绝所有登录会使整个聊天室不可用，
+// 因此选择在故障期间让封禁暂时失效，同时记录告警并在终端提示
+func findBan(ws *pkg.Workspace, nickName string, addr string) *pkg.Ban {
+	ban, err := pkg.FindBan(ctx, ws.Store, nickName, addr)
+	if err != nil {
+		banCheckFailed(ws, addr, err)
+		return nil
+	}
+	return ban
+}
+
+// findNetworkBan 查找匹配地址的全网封禁，读取失败时与 findBan 一样放行
+func findNetworkBan(addr string) *pkg.Ban {
+	ban, err := pkg.FindNetworkBan(ctx, defaultWorkspace.Store, addr)
+	if err != nil {
+		banCheckFailed(defaultWorkspace, addr, err)
+		return nil
+	}
+	return ban
+}
+
+// banCheckFailed 记录封禁检查失败，连接按 fail open 策略放行
+func banCheckFailed(ws *pkg.Workspace, addr string, err error) {
+	logger.Warn("check ban failed, allowing connection, workspace:", ws.Name, " addr:", addr, " err:", err)
+	console.Add("封禁检查失败，已放行连接，工作区:" + ws.Name + "，客户端地址:" + addr + "，" + err.Error())
+}
+
+// networkBanUsage 全网封禁命令用法
+var networkBanUsage = map[string]string{
+	"/netban":   "/netban <ip|cidr> <duration> [reason]",
+	"/netunban": "/netunban <ip|cidr>",
+	"/netbans":  "/netbans",
+}
+
+// networkBanCommand 服务端终端管理全网封禁，只能封禁 IP 或网段，对所有工作区生效
+func networkBanCommand(args []string) string {
+	usage := "用法: " + networkBanUsage[args[0]]
+	switch args[0] {
+	case "/netban":
+		if len(args) < 3 {
+			return usage
+		}
+		d, err := pkg.ParseDuration(args[2])
+		if err != nil {
+			return err.Error()
+		}
+		reason := strings.Join(args[3:], " ")
+		ban, err := pkg.AddNetworkBan(ctx, defaultWorkspace.Store, args[1], consoleActor, d, reason)
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		for _, ws := range workspaces {
+			publish(ws, proto.Frame{Type: proto.TypeKick, From: args[1], Text: ban.String()})
+		}
+		recordAudit(defaultWorkspace, pkg.AuditEvent{Action: pkg.AuditBan, Actor: consoleActor, Target: args[1], Reason: reason, Detail: "全网，" + durationText(d)})
+		return "已全网封禁 " + args[1] + "，" + pkg.UntilText(ban.Until)
+	case "/netunban":
+		if len(args) != 2 {
+			return usage
+		}
+		ok, err := pkg.RemoveNetworkBan(ctx, defaultWorkspace.Store, args[1])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if !ok {
+			return args[1] + " 未被全网封禁"
+		}
+		recordAudit(defaultWorkspace, pkg.AuditEvent{Action: pkg.AuditUnban, Actor: consoleActor, Target: args[1], Detail: "全网"})
+		return "已解除 " + args[1] + " 的全网封禁"
+	case "/netbans":
+		bans, err := pkg.ListNetworkBans(ctx, defaultWorkspace.Store)
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		return banList("全网封禁列表:", "暂无全网封禁", bans)
+	}
+	return "无效命令，输入/help获取帮助"
+}
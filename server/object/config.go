@@ -2,20 +2,126 @@ package object
 
 type Config struct {
 	App struct {
-		Host              string `ini:"host"`
-		Port              string `ini:"port"`
-		HeartbeatInterval int    `ini:"heartbeatInterval"`
-		TimeoutInterval   int    `ini:"timeoutInterval"`
+		Host              string   `ini:"host"`
+		Port              string   `ini:"port"`
+		HeartbeatInterval int      `ini:"heartbeatInterval"`
+		TimeoutInterval   int      `ini:"timeoutInterval"`
+		Node              string   `ini:"node"`                 // 节点标识，多实例部署时每个实例需唯一
+		Storage           string   `ini:"storage"`              // 存储类型：redis、memory 或 file
+		HistoryLimit      int      `ini:"historyLimit"`         // 每个房间保留的历史消息条数
+		Workspaces        []string `ini:"workspaces" delim:","` // 工作区列表，第一个为默认工作区
 	}
 	MyLog struct {
 		Dir    string `ini:"dir"`
 		Level  string `ini:"level"`
 		Format string `ini:"format"`
 	}
+	Audit struct {
+		File string `ini:"file"` // 审计日志文件，为空时不记录
+	}
+	Nick struct {
+		MinLength  int      `ini:"minLength"`            // 最短昵称长度(字符)
+		MaxLength  int      `ini:"maxLength"`            // 最长昵称长度(字符)
+		Categories []string `ini:"categories" delim:","` // 允许的 Unicode 类别
+		Reserved   []string `ini:"reserved" delim:","`   // 保留的昵称
+	}
+	RateLimit    RateLimitConfig
+	BotRateLimit RateLimitConfig // 机器人账号的限流，全部为 0 时不限制
+
+	Filter struct {
+		File           string `ini:"file"`           // 内容过滤规则文件，为空时不过滤
+		ReloadInterval int    `ini:"reloadInterval"` // 检查规则文件变化的间隔(秒)
+	}
+	Account struct {
+		Auth              string `ini:"auth"`              // 账号模式：open、mixed 或 registered
+		HashIterations    int    `ini:"hashIterations"`    // 密码哈希 PBKDF2 迭代次数
+		MinPasswordLength int    `ini:"minPasswordLength"` // 最短密码长度
+		LoginFreeAttempts int    `ini:"loginFreeAttempts"` // 连续密码错误多少次后开始退避
+		LoginBackoff      int    `ini:"loginBackoff"`      // 首次退避时长(秒)，之后每次失败翻倍
+		LoginMaxBackoff   int    `ini:"loginMaxBackoff"`   // 退避时长上限(秒)
+	}
+	Token struct {
+		Secret string `ini:"secret"` // 令牌签名密钥，为空时不签发令牌，多实例部署时需一致
+		TTL    int    `ini:"ttl"`    // 令牌有效期(小时)
+	}
+	Admin struct {
+		Addr string `ini:"addr"` // HTTP 管理接口监听地址，为空时不开启
+	}
+	File struct {
+		Dir         string `ini:"dir"`         // 数据目录
+		SegmentSize int64  `ini:"segmentSize"` // 单个日志段大小上限(字节)
+		MaxSegments int    `ini:"maxSegments"` // 日志段数量超过该值时压缩
+	}
+	Score struct {
+		JoinWeight         float64  `ini:"joinWeight"`              // 加入聊天室得分
+		LengthBuckets      []string `ini:"lengthBuckets" delim:","` // 消息长度分档 长度上限:分数
+		ActiveMinuteWeight float64  `ini:"activeMinuteWeight"`      // 每个新的活跃分钟额外得分
+		ReplyWeight        float64  `ini:"replyWeight"`             // 消息被回复时作者得分
+		ModeratedPenalty   float64  `ini:"moderatedPenalty"`        // 消息被审核处理时扣分
+		HalfLife           float64  `ini:"halfLife"`                // 衰减半衰期(小时)，0 表示不衰减
+	}
 	Redis struct {
-		Host string `ini:"host"`
-		Port string `ini:"port"`
-		Pwd  string `ini:"pwd"`
-		Db   int    `ini:"db"`
+		Mode   string `ini:"mode"`   // 部署方式：standalone、sentinel 或 cluster
+		Prefix string `ini:"prefix"` // 键前缀，共用同一个 Redis 库时用于隔离数据
+		Host   string `ini:"host"`
+		Port   string `ini:"port"`
+		Pwd    string `ini:"pwd"`
+		Db     int    `ini:"db"`
+
+		MasterName    string   `ini:"masterName"`              // 哨兵模式主节点名称
+		SentinelAddrs []string `ini:"sentinelAddrs" delim:","` // 哨兵地址
+		SentinelPwd   string   `ini:"sentinelPwd"`             // 哨兵密码
+		ClusterAddrs  []string `ini:"clusterAddrs" delim:","`  // 集群节点地址
+		TLS           bool     `ini:"tls"`                     // 是否使用 TLS 连接
+		TLSSkipVerify bool     `ini:"tlsSkipVerify"`           // 是否跳过证书校验
+		TLSCA         string   `ini:"tlsCA"`                   // CA 证书路径
+
+		BreakerThreshold int `ini:"breakerThreshold"` // 连续失败多少次后熔断
+		RetryMin         int `ini:"retryMin"`         // 熔断后首次重连间隔(毫秒)
+		RetryMax         int `ini:"retryMax"`         // 重连间隔上限(毫秒)
 	}
+	Plugins  map[string]PluginConfig  `ini:"-"` // 外部插件，插件名称->配置，来自 [Plugin.<name>] 小节
+	Webhooks map[string]WebhookConfig `ini:"-"` // 外发 Webhook，名称->配置，来自 [Webhook.<name>] 小节
+}
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	MessagesPerSecond float64 `ini:"messagesPerSecond"` // 每秒消息数，0 表示不限制
+	MessageBurst      float64 `ini:"messageBurst"`      // 消息突发上限
+	BytesPerSecond    float64 `ini:"bytesPerSecond"`    // 每秒字节数，0 表示不限制
+	ByteBurst         float64 `ini:"byteBurst"`         // 字节突发上限，同时是单条消息的长度上限
+	RepeatLimit       int     `ini:"repeatLimit"`       // 允许连续发送相同消息的条数，0 表示不检测
+	WarnAfter         int     `ini:"warnAfter"`         // 违规多少次后警告
+	PunishAfter       int     `ini:"punishAfter"`       // 违规多少次后处罚，0 表示不处罚
+	Window            int     `ini:"window"`            // 违规计数的时间窗口(秒)
+	Action            string  `ini:"action"`            // 处罚方式：mute 或 disconnect
+	MuteDuration      string  `ini:"muteDuration"`      // 自动禁言时长
+}
+
+// PluginConfig 外部插件配置
+type PluginConfig struct {
+	Command     string   `ini:"command"`            // 可执行文件路径
+	Args        []string `ini:"args" delim:","`     // 命令行参数
+	Nick        string   `ini:"nick"`               // 插件发送消息时使用的昵称，默认为插件名称
+	Role        string   `ini:"role"`               // 执行踢出、设置话题等动作时的角色
+	Events      []string `ini:"events" delim:","`   // 订阅的事件：message、join、leave，为空时订阅全部
+	Commands    []string `ini:"commands" delim:","` // 交给插件处理的客户端命令
+	Timeout     int      `ini:"timeout"`            // 等待插件响应的超时时间(毫秒)
+	Restart     string   `ini:"restart"`            // 重启策略：always、on-failure 或 never
+	MaxRestarts int      `ini:"maxRestarts"`        // 连续重启次数上限，0 表示不限制
+}
+
+// WebhookConfig 外发 Webhook 配置
+type WebhookConfig struct {
+	URL       string   `ini:"url"`              // 接收事件的地址
+	Workspace string   `ini:"workspace"`        // 只发送该工作区的事件，为空时为默认工作区
+	Secret    string   `ini:"secret"`           // HMAC-SHA256 签名密钥，为空时不签名
+	Events    []string `ini:"events" delim:","` // 事件：message、join、leave，为空时只发送 message
+	Rooms     []string `ini:"rooms" delim:","`  // 只发送这些房间的事件，为空时不限制
+	Pattern   string   `ini:"pattern"`          // 消息需要匹配的正则，为空时不限制
+	Timeout   int      `ini:"timeout"`          // 请求超时时间(毫秒)
+	Retries   int      `ini:"retries"`          // 失败后的重试次数，0 表示不重试
+	RetryMin  int      `ini:"retryMin"`         // 首次重试间隔(毫秒)
+	RetryMax  int      `ini:"retryMax"`         // 重试间隔上限(毫秒)
+	QueueSize int      `ini:"queueSize"`        // 等待发送的事件数上限，超过后丢弃
 }
@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// 账号模式
+const (
+	AuthOpen       = "open"       // 开放模式：不使用账号，任何空闲昵称都可以登录
+	AuthMixed      = "mixed"      // 混合模式：游客与注册用户并存，已注册的昵称需要密码
+	AuthRegistered = "registered" // 注册模式：只允许注册用户登录
+)
+
+// ReasonWrongPassword 密码错误时的失败原因
+const ReasonWrongPassword = "密码错误"
+
+// keyAccounts 账号哈希 昵称->账号
+const keyAccounts = "accounts"
+
+const (
+	saltSize          = 16     // 盐长度(字节)
+	passwordHashSize  = 32     // 密码哈希长度(字节)
+	defaultIterations = 100000 // 默认 PBKDF2 迭代次数
+	defaultMinPwdLen  = 6      // 默认最短密码长度
+)
+
+// Account 注册账号
+type Account struct {
+	Name       string `json:"name"`
+	Salt       string `json:"salt"`          // base64 编码的盐
+	Hash       string `json:"hash"`          // base64 编码的 PBKDF2-HMAC-SHA256 密码哈希
+	Iterations int    `json:"iterations"`    // 计算哈希时的迭代次数，调整配置后旧账号仍可校验
+	Created    int64  `json:"created"`       // 注册时间(Unix 秒)
+	Bot        bool   `json:"bot,omitempty"` // 是否为机器人账号
+}
+
+// AccountPolicy 账号策略
+type AccountPolicy struct {
+	mode        string
+	iterations  int
+	minPassword int
+}
+
+// NewAccountPolicy 根据配置创建账号策略
+func NewAccountPolicy(config object.Config) (*AccountPolicy, error) {
+	p := &AccountPolicy{
+		mode:        config.Account.Auth,
+		iterations:  config.Account.HashIterations,
+		minPassword: config.Account.MinPasswordLength,
+	}
+	switch p.mode {
+	case "":
+		p.mode = AuthOpen
+	case AuthOpen, AuthMixed, AuthRegistered:
+	default:
+		return nil, errors.New("未知的账号模式: " + p.mode)
+	}
+	if p.iterations <= 0 {
+		p.iterations = defaultIterations
+	}
+	if p.minPassword <= 0 {
+		p.minPassword = defaultMinPwdLen
+	}
+	return p, nil
+}
+
+// Mode 账号模式
+func (p *AccountPolicy) Mode() string {
+	return p.mode
+}
+
+// Login 校验登录请求，返回是否以注册用户身份登录，登录失败时 reason 为失败原因
+// 已注册的昵称必须使用密码登录，游客不能占用
+func (p *AccountPolicy) Login(ctx context.Context, s Store, nickName string, password string) (registered bool, reason string, err error) {
+	if p.mode == AuthOpen {
+		return false, "", nil
+	}
+	account, err := GetAccount(ctx, s, nickName)
+	if err != nil {
+		return false, "", err
+	}
+	switch {
+	case account == nil && p.mode == AuthRegistered:
+		return false, "服务端只允许注册用户登录，请先注册", nil
+	case account == nil && password != "":
+		return false, "账号不存在", nil
+	case account == nil:
+		return false, "", nil
+	case password == "":
+		return false, proto.ReasonNeedPassword, nil
+	case !account.Verify(password):
+		return false, ReasonWrongPassword, nil
+	}
+	return true, "", nil
+}
+
+// Register 注册账号，昵称已被注册或密码不符合要求时返回失败原因
+func (p *AccountPolicy) Register(ctx context.Context, s Store, nickName string, password string) (string, error) {
+	if p.mode == AuthOpen {
+		return "服务端未开启注册", nil
+	}
+	if utf8.RuneCountInString(password) < p.minPassword {
+		return "密码长度不能少于" + strconv.Itoa(p.minPassword) + "位", nil
+	}
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", errors.New("生成盐失败: " + err.Error())
+	}
+	account := Account{
+		Name:       nickName,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Hash:       base64.StdEncoding.EncodeToString(pbkdf2.Key([]byte(password), salt, p.iterations, passwordHashSize, sha256.New)),
+		Iterations: p.iterations,
+		Created:    time.Now().Unix(),
+	}
+	data, err := json.Marshal(account)
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.HashSetNX(ctx, keyAccounts, nickName, string(data))
+	if err != nil {
+		return "", errors.New("保存账号失败: " + err.Error())
+	}
+	if !ok {
+		return "昵称已被注册", nil
+	}
+	return "", nil
+}
+
+// GetAccount 获取账号，未注册时返回 nil
+func GetAccount(ctx context.Context, s Store, nickName string) (*Account, error) {
+	data, ok, err := s.HashGet(ctx, keyAccounts, nickName)
+	if err != nil {
+		return nil, errors.New("获取账号失败: " + err.Error())
+	}
+	if !ok {
+		return nil, nil
+	}
+	var account Account
+	err = json.Unmarshal([]byte(data), &account)
+	if err != nil {
+		return nil, errors.New("解析账号失败: " + err.Error())
+	}
+	return &account, nil
+}
+
+// SetBot 将注册账号标记为机器人账号或取消标记
+func SetBot(ctx context.Context, s Store, nickName string, bot bool) error {
+	account, err := GetAccount(ctx, s, nickName)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return errors.New("账号不存在: " + nickName)
+	}
+	account.Bot = bot
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	err = s.HashSet(ctx, keyAccounts, nickName, string(data))
+	if err != nil {
+		return errors.New("保存账号失败: " + err.Error())
+	}
+	return nil
+}
+
+// BotAccounts 工作区的机器人账号
+func BotAccounts(ctx context.Context, s Store) (map[string]bool, error) {
+	accounts, err := s.HashGetAll(ctx, keyAccounts)
+	if err != nil {
+		return nil, errors.New("获取账号失败: " + err.Error())
+	}
+	bots := make(map[string]bool)
+	for nickName, data := range accounts {
+		var account Account
+		if json.Unmarshal([]byte(data), &account) == nil && account.Bot {
+			bots[nickName] = true
+		}
+	}
+	return bots, nil
+}
+
+// Verify 校验密码
+func (a *Account) Verify(password string) bool {
+	salt, err := base64.StdEncoding.DecodeString(a.Salt)
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(a.Hash)
+	if err != nil || a.Iterations <= 0 {
+		return false
+	}
+	return hmac.Equal(hash, pbkdf2.Key([]byte(password), salt, a.Iterations, len(hash), sha256.New))
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"encoding/base64"
+	"encoding/hex"
+	"golang.org/x/crypto/pbkdf2"
+	"testing"
+)
+
+func TestPBKDF2Vectors(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 的公开测试向量，P = "password"，S = "salt"，dkLen = 32
+	tests := []struct {
+		iterations int
+		want       string
+	}{
+		{iterations: 1, want: "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{iterations: 2, want: "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{iterations: 4096, want: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2.Key([]byte("password"), []byte("salt"), tt.iterations, passwordHashSize, sha256.New))
+		if got != tt.want {
+			t.Errorf("c=%d: PBKDF2 = %s, want %s", tt.iterations, got, tt.want)
+		}
+		// 按向量构造的账号可以校验
+		hash, _ := hex.DecodeString(tt.want)
+		account := Account{
+			Salt:       base64.StdEncoding.EncodeToString([]byte("salt")),
+			Hash:       base64.StdEncoding.EncodeToString(hash),
+			Iterations: tt.iterations,
+		}
+		if !account.Verify("password") || account.Verify("passwore") {
+			t.Errorf("c=%d: Verify() 结果有误", tt.iterations)
+		}
+	}
+}
+
+func TestAccountRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	var config object.Config
+	config.Account.Auth = AuthMixed
+	config.Account.HashIterations = 16
+	s := NewMemoryStore(config)
+	p, err := NewAccountPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason, err := p.Register(ctx, s, "alice", "short"); err != nil || reason == "" {
+		t.Errorf("密码过短时 Register() = %q, %v", reason, err)
+	}
+	if reason, err := p.Register(ctx, s, "alice", "correct horse"); err != nil || reason != "" {
+		t.Fatalf("Register() = %q, %v", reason, err)
+	}
+	if reason, _ := p.Register(ctx, s, "alice", "another pass"); reason != "昵称已被注册" {
+		t.Errorf("重复注册 Register() = %q", reason)
+	}
+	account, err := GetAccount(ctx, s, "alice")
+	if err != nil || account == nil {
+		t.Fatalf("GetAccount() = %v, %v", account, err)
+	}
+	if account.Iterations != 16 || account.Hash == "" || account.Salt == "" {
+		t.Errorf("保存的账号 = %+v", account)
+	}
+
+	tests := []struct {
+		name       string
+		nick       string
+		password   string
+		registered bool
+		reason     string
+	}{
+		{name: "正确的密码", nick: "alice", password: "correct horse", registered: true},
+		{name: "错误的密码", nick: "alice", password: "wrong horse", reason: ReasonWrongPassword},
+		{name: "已注册的昵称缺少密码", nick: "alice", reason: proto.ReasonNeedPassword},
+		{name: "游客", nick: "bob"},
+		{name: "未注册的昵称使用密码", nick: "bob", password: "whatever", reason: "账号不存在"},
+	}
+	for _, tt := range tests {
+		registered, reason, err := p.Login(ctx, s, tt.nick, tt.password)
+		if err != nil || registered != tt.registered || reason != tt.reason {
+			t.Errorf("%s: Login() = %v, %q, %v, want %v, %q", tt.name, registered, reason, err, tt.registered, tt.reason)
+		}
+	}
+
+	// 调整迭代次数后旧账号仍按保存的迭代次数校验
+	config.Account.HashIterations = 32
+	p2, _ := NewAccountPolicy(config)
+	if registered, reason, _ := p2.Login(ctx, s, "alice", "correct horse"); !registered || reason != "" {
+		t.Errorf("调整迭代次数后 Login() = %v, %q", registered, reason)
+	}
+}
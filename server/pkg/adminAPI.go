@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Authenticator 校验请求携带的令牌
+type Authenticator func(token string) (*TokenClaims, error)
+
+// claimsKey 请求上下文中令牌内容的键
+type claimsKey struct{}
+
+// AdminAPI HTTP 管理接口，返回 JSON
+type AdminAPI struct {
+	mux    *http.ServeMux
+	server *http.Server
+	auth   Authenticator // 为 nil 时拒绝所有需要认证的请求
+}
+
+// CreateAdminAPI 创建管理接口
+func CreateAdminAPI() *AdminAPI {
+	mux := http.NewServeMux()
+	return &AdminAPI{
+		mux: mux,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// SetAuthenticator 设置认证方式，通过 Handle 注册的接口需要携带 Authorization: Bearer <令牌>
+func (a *AdminAPI) SetAuthenticator(auth Authenticator) {
+	a.auth = auth
+}
+
+// Handle 注册需要认证的接口，只接受指定的请求方法
+func (a *AdminAPI) Handle(method string, pattern string, handler http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			WriteError(w, http.StatusMethodNotAllowed, "不支持的请求方法: "+r.Method)
+			return
+		}
+		if a.auth == nil {
+			WriteError(w, http.StatusServiceUnavailable, "管理接口未配置认证")
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			WriteError(w, http.StatusUnauthorized, "缺少令牌")
+			return
+		}
+		claims, err := a.auth(token)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+		handler(w, r)
+	})
+}
+
+// HandlePublic 注册无需认证的接口
+func (a *AdminAPI) HandlePublic(method string, pattern string, handler http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			WriteError(w, http.StatusMethodNotAllowed, "不支持的请求方法: "+r.Method)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// RequestClaims 请求携带的令牌内容，无需认证的接口为 nil
+func RequestClaims(r *http.Request) *TokenClaims {
+	claims, _ := r.Context().Value(claimsKey{}).(*TokenClaims)
+	return claims
+}
+
+// bearerToken 从 Authorization 请求头中取出令牌
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok && strings.TrimSpace(token) != ""
+}
+
+// Start 开始监听，在后台处理请求
+func (a *AdminAPI) Start(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.New("admin api listen err=" + err.Error())
+	}
+	go func() {
+		_ = a.server.Serve(l)
+	}()
+	return nil
+}
+
+// Close 关闭管理接口
+func (a *AdminAPI) Close() {
+	_ = a.server.Close()
+}
+
+// WriteJSON 以 JSON 格式返回结果
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteError 以 JSON 格式返回错误信息
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	WriteJSON(w, status, map[string]string{"error": msg})
+}
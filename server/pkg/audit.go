@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 审计事件类型
+const (
+	AuditKick    = "kick"    // 踢出用户
+	AuditMute    = "mute"    // 禁言用户
+	AuditUnmute  = "unmute"  // 解除禁言
+	AuditBan     = "ban"     // 封禁
+	AuditUnban   = "unban"   // 解除封禁
+	AuditRole    = "role"    // 指定角色
+	AuditBot     = "bot"     // 标记或取消标记机器人账号
+	AuditWebhook = "webhook" // 创建或删除入站 Webhook
+	AuditDelete  = "delete"  // 删除消息
+	AuditReload  = "reload"  // 重新加载配置
+)
+
+// ErrAuditDisabled 未配置审计日志文件
+var ErrAuditDisabled = errors.New("未开启审计日志")
+
+// AuditEvent 审计事件
+type AuditEvent struct {
+	Time      int64  `json:"time"`                // 发生时间(Unix 秒)
+	Workspace string `json:"workspace,omitempty"` // 为空时不属于任何工作区
+	Action    string `json:"action"`
+	Actor     string `json:"actor"` // 操作者，服务端终端为 console
+	Target    string `json:"target,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Detail    string `json:"detail,omitempty"` // 附加信息，如处罚时长、指定的角色
+}
+
+// String 审计事件的显示文本
+func (e AuditEvent) String() string {
+	msg := time.Unix(e.Time, 0).Format("2006-01-02 15:04:05") + " [" + e.Action + "] " + e.Actor
+	if e.Target != "" {
+		msg += " -> " + e.Target
+	}
+	if e.Detail != "" {
+		msg += " " + e.Detail
+	}
+	if e.Reason != "" {
+		msg += " 原因: " + e.Reason
+	}
+	return msg
+}
+
+// AuditFilter 审计日志查询条件，字段为空时不过滤
+type AuditFilter struct {
+	Workspace string
+	NickName  string // 匹配操作者或目标
+	Limit     int    // 返回最近的条数，0 表示全部
+}
+
+// match 事件是否满足查询条件，不属于任何工作区的事件(如重新加载配置)匹配所有工作区
+func (f AuditFilter) match(e AuditEvent) bool {
+	if f.Workspace != "" && e.Workspace != "" && e.Workspace != f.Workspace {
+		return false
+	}
+	return f.NickName == "" || e.Actor == f.NickName || e.Target == f.NickName
+}
+
+// AuditLog 审计日志，每行一个 JSON 事件，只追加写入，与运行日志分开保存
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+// OpenAuditLog 打开审计日志文件，path 为空时返回 nil，此时记录事件不做任何操作
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, errors.New("create audit log dir failed: " + err.Error())
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.New("open audit log failed: " + err.Error())
+	}
+	return &AuditLog{file: file, path: path}, nil
+}
+
+// Record 记录审计事件，未指定时间时使用当前时间
+func (a *AuditLog) Record(e AuditEvent) error {
+	if a == nil {
+		return nil
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(data, '\n'))
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		return errors.New("write audit log failed: " + err.Error())
+	}
+	return nil
+}
+
+// Query 按条件查询审计事件，按时间先后排列
+func (a *AuditLog) Query(filter AuditFilter) ([]AuditEvent, error) {
+	if a == nil {
+		return nil, ErrAuditDisabled
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, errors.New("open audit log failed: " + err.Error())
+	}
+	defer file.Close()
+	events := make([]AuditEvent, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue // 跳过损坏的行
+		}
+		if !filter.match(e) {
+			continue
+		}
+		events = append(events, e)
+		if filter.Limit > 0 && len(events) > filter.Limit {
+			events = events[1:]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.New("read audit log failed: " + err.Error())
+	}
+	return events, nil
+}
+
+// Close 关闭审计日志文件
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_ = a.file.Close()
+}
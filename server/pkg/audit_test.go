@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+	a, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []AuditEvent{
+		{Time: 1, Workspace: "default", Action: AuditKick, Actor: "root", Target: "alice"},
+		{Time: 2, Workspace: "teamA", Action: AuditMute, Actor: "mod", Target: "bob"},
+		{Time: 3, Action: AuditReload, Actor: "console"},
+		{Time: 4, Workspace: "default", Action: AuditBan, Actor: "alice", Target: "10.0.0.1"},
+		{Time: 5, Workspace: "default", Action: AuditUnmute, Actor: "root", Target: "carol"},
+	}
+	for _, e := range events {
+		if err = a.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 损坏的行被跳过
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = file.WriteString("{broken\n")
+	_ = file.Close()
+	_ = a.Record(AuditEvent{Time: 6, Workspace: "teamA", Action: AuditRole, Actor: "console", Target: "alice", Detail: "moderator"})
+	a.Close()
+
+	a, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []int64 // 事件的时间
+	}{
+		{name: "全部", filter: AuditFilter{}, want: []int64{1, 2, 3, 4, 5, 6}},
+		{name: "按工作区，包括不属于工作区的事件", filter: AuditFilter{Workspace: "default"}, want: []int64{1, 3, 4, 5}},
+		{name: "按昵称匹配操作者或目标", filter: AuditFilter{NickName: "alice"}, want: []int64{1, 4, 6}},
+		{name: "工作区与昵称", filter: AuditFilter{Workspace: "teamA", NickName: "alice"}, want: []int64{6}},
+		{name: "最近的条数", filter: AuditFilter{Limit: 2}, want: []int64{5, 6}},
+		{name: "过滤后再取最近的条数", filter: AuditFilter{Workspace: "default", Limit: 3}, want: []int64{3, 4, 5}},
+		{name: "条数超过事件数", filter: AuditFilter{NickName: "root", Limit: 10}, want: []int64{1, 5}},
+		{name: "没有匹配的事件", filter: AuditFilter{NickName: "nobody"}, want: []int64{}},
+	}
+	for _, tt := range tests {
+		got, err := a.Query(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		times := make([]int64, 0, len(got))
+		for _, e := range got {
+			times = append(times, e.Time)
+		}
+		if !reflect.DeepEqual(times, tt.want) {
+			t.Errorf("%s: Query() = %v, want %v", tt.name, times, tt.want)
+		}
+	}
+	got, _ := a.Query(AuditFilter{Limit: 1})
+	if want := (AuditEvent{Time: 6, Workspace: "teamA", Action: AuditRole, Actor: "console", Target: "alice", Detail: "moderator"}); len(got) != 1 || got[0] != want {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	a, err := OpenAuditLog("")
+	if err != nil || a != nil {
+		t.Fatalf("OpenAuditLog(\"\") = %v, %v, want nil", a, err)
+	}
+	if err = a.Record(AuditEvent{Action: AuditKick}); err != nil {
+		t.Errorf("未开启时 Record() = %v", err)
+	}
+	if _, err = a.Query(AuditFilter{}); err != ErrAuditDisabled {
+		t.Errorf("未开启时 Query() = %v, want %v", err, ErrAuditDisabled)
+	}
+	a.Close()
+}
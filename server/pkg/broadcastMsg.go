@@ -3,38 +3,41 @@ package pkg
 import (
 	"easy-chat/proto"
 	"errors"
-	"net"
 )
 
+// broadcastItem 待广播的消息
+type broadcastItem struct {
+	workspace string
+	frame     proto.Frame
+}
+
 // BroadcastMsg 广播消息
 type BroadcastMsg struct {
-	msg chan string
+	msg chan broadcastItem
 }
 
 // CreateBroadcastMsg 创建广播消息处理
 func CreateBroadcastMsg() *BroadcastMsg {
 	return &BroadcastMsg{
-		msg: make(chan string),
+		msg: make(chan broadcastItem),
 	}
 }
 
-// Add 添加广播消息
-func (bc *BroadcastMsg) Add(message string) {
-	bc.msg <- message
+// Add 添加广播消息，消息只发送给同一工作区内的用户，指定房间时只发送给该房间的用户
+func (bc *BroadcastMsg) Add(workspace string, frame proto.Frame) {
+	bc.msg <- broadcastItem{workspace: workspace, frame: frame}
 }
 
-// SendMessage 发送广播消息
-func (bc *BroadcastMsg) SendMessage(conn map[net.Conn]*connState) error {
-	for message := range bc.msg {
-		for c, _ := range conn {
-			data, err := proto.Encode(message)
-			if err != nil {
-				return errors.New("encode msg failed, go: sendMessage(), err=" + err.Error())
-			}
-			_, err = c.Write(data)
-			if err != nil {
-				return errors.New("sendMessage failed, go: sendMessage(), err=" + err.Error())
-			}
+// SendMessage 发送广播消息，单个连接发送失败不影响其他连接
+// 在锁内只复制目标连接，写入在锁外进行并带有超时，慢连接不会阻塞连接的增删
+func (bc *BroadcastMsg) SendMessage(connList *ConnList) error {
+	for item := range bc.msg {
+		data, err := proto.EncodeFrame(item.frame)
+		if err != nil {
+			return errors.New("encode msg failed, go: sendMessage(), err=" + err.Error())
+		}
+		for _, t := range connList.targets(item.workspace, item.frame.Room) {
+			_ = t.state.write(t.conn, data)
 		}
 	}
 	return nil
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"easy-chat/proto"
 	"errors"
 	"fmt"
 	"net"
@@ -8,45 +9,85 @@ import (
 	"time"
 )
 
-// ConnList 连接列表
+// writeTimeout 单次写入的超时时间，超时的连接视为阻塞并被关闭
+const writeTimeout = 5 * time.Second
+
+// ConnList 连接列表，连接状态只能通过持有锁的方法读写
 type ConnList struct {
-	Connections map[net.Conn]*connState
-	rw          sync.RWMutex // 保护连接的读写
+	connections map[net.Conn]*connState
+	rw          sync.RWMutex // 保护连接列表与连接状态的读写
 }
 
-// connState 连接状态
-type connState struct {
+// ConnInfo 连接信息，Get 返回的是快照，修改需要通过 Update
+type ConnInfo struct {
 	NickName      string
+	Workspace     string       // 所在工作区
+	Registered    bool         // 是否为注册用户
+	Bot           bool         // 是否为机器人账号
+	Token         *TokenClaims // 本次会话使用的令牌，注销时吊销
+	Room          string       // 所在房间
 	Add           string
 	LoginTime     time.Time
 	LastHeartTime time.Time
+	Flood         *FloodGuard // 限流器
+}
+
+// connState 连接状态
+type connState struct {
+	ConnInfo
+	wmu sync.Mutex // 保护连接的写入，避免多个协程同时写入导致数据交错
 }
 
 // CreatConnList 连接列表初始化
 func CreatConnList() *ConnList {
 	return &ConnList{
-		Connections: make(map[net.Conn]*connState),
+		connections: make(map[net.Conn]*connState),
 		rw:          sync.RWMutex{},
 	}
 }
 
-// Add 添加客户端连接
-func (c *ConnList) Add(conn net.Conn, nickName string) {
-	state := &connState{
+// Add 添加客户端连接，用户进入默认房间
+func (c *ConnList) Add(conn net.Conn, workspace string, nickName string, registered bool) {
+	state := &connState{ConnInfo: ConnInfo{
 		NickName:      nickName,
+		Workspace:     workspace,
+		Registered:    registered,
+		Room:          DefaultRoom,
 		Add:           conn.RemoteAddr().String(),
 		LoginTime:     time.Now(),
 		LastHeartTime: time.Now(),
-	}
+	}}
 	c.rw.Lock()
-	c.Connections[conn] = state
+	c.connections[conn] = state
 	c.rw.Unlock()
 }
 
+// Get 获取连接信息的快照
+func (c *ConnList) Get(conn net.Conn) (ConnInfo, bool) {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	state, ok := c.connections[conn]
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return state.ConnInfo, true
+}
+
+// Update 持有锁修改连接信息，连接不存在时返回 false
+func (c *ConnList) Update(conn net.Conn, update func(info *ConnInfo)) bool {
+	c.rw.Lock()
+	defer c.rw.Unlock()
+	state, ok := c.connections[conn]
+	if ok {
+		update(&state.ConnInfo)
+	}
+	return ok
+}
+
 // Delete 删除客户端连接
 func (c *ConnList) Delete(conn net.Conn) {
 	c.rw.Lock()
-	delete(c.Connections, conn)
+	delete(c.connections, conn)
 	c.rw.Unlock()
 }
 
@@ -54,55 +95,208 @@ func (c *ConnList) Delete(conn net.Conn) {
 func (c *ConnList) GetList() string {
 	var message string
 	message = message + "---------------------------------------------------\n当前用户列表：\n"
-	message = message + fmt.Sprintf("IP              登录时间            昵称\n")
-	for n, v := range c.Connections {
-		message = message + fmt.Sprintf("%v %v %v\n", n.RemoteAddr().String(), v.LoginTime.Format("2006:01:02 15:04:05"), v.NickName)
+	message = message + fmt.Sprintf("IP              登录时间            工作区 昵称\n")
+	c.rw.RLock()
+	for n, v := range c.connections {
+		message = message + fmt.Sprintf("%v %v %v %v\n", n.RemoteAddr().String(), v.LoginTime.Format("2006:01:02 15:04:05"), v.Workspace, v.NickName)
+	}
+	c.rw.RUnlock()
+	message = message + "---------------------------------------------------"
+	return message
+}
+
+// GetClusterList 工作区的集群用户列表，presence 为 昵称->节点
+func (c *ConnList) GetClusterList(workspace string, node string, presence map[string]string, bots map[string]bool) string {
+	var message string
+	message = message + "---------------------------------------------------\n工作区 " + workspace + " 集群用户列表：\n"
+	message = message + fmt.Sprintf("IP              登录时间            节点            限流/重复/警告 昵称\n")
+	local := make(map[string]bool)
+	c.rw.RLock()
+	for n, v := range c.connections {
+		if v.Workspace != workspace {
+			continue
+		}
+		local[v.NickName] = true
+		message = message + fmt.Sprintf("%v %v %v %-14v %v\n", n.RemoteAddr().String(), v.LoginTime.Format("2006:01:02 15:04:05"), node, v.Flood.Counters(), botMark(v.NickName, v.Bot || bots[v.NickName]))
+	}
+	c.rw.RUnlock()
+	for nickName, n := range presence {
+		if local[nickName] {
+			continue
+		}
+		message = message + fmt.Sprintf("%-15v %-19v %v %-14v %v\n", "-", "-", n, "-", botMark(nickName, bots[nickName]))
 	}
 	message = message + "---------------------------------------------------"
 	return message
 }
 
+// botMark 在机器人账号的昵称后添加标记
+func botMark(nickName string, bot bool) string {
+	if bot {
+		return nickName + " [bot]"
+	}
+	return nickName
+}
+
 // GetLastHeardTime 显示心跳时间
 func (c *ConnList) GetLastHeardTime() string {
 	var message string
 	message = message + "---------------------------------------------------\n用户心跳列表：\n"
 	message = message + fmt.Sprintf("登录时间            最后心跳时间        昵称\n")
-	for _, v := range c.Connections {
+	c.rw.RLock()
+	for _, v := range c.connections {
 		message = message + fmt.Sprintf("%v %v %v\n", v.LoginTime.Format("2006:01:02 15:04:05"), v.LastHeartTime.Format("2006:01:02 15:04:05"), v.NickName)
 	}
+	c.rw.RUnlock()
 	message = message + "---------------------------------------------------"
 	return message
 }
 
+// Send 向指定连接发送消息帧
+func (c *ConnList) Send(conn net.Conn, frame proto.Frame) error {
+	c.rw.RLock()
+	state, ok := c.connections[conn]
+	c.rw.RUnlock()
+	if !ok {
+		return errors.New("no user")
+	}
+	data, err := proto.EncodeFrame(frame)
+	if err != nil {
+		return err
+	}
+	return state.write(conn, data)
+}
+
+// write 带超时写入连接，超时或失败时关闭连接，由连接的读协程清理
+func (s *connState) write(conn net.Conn, data []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := conn.Write(data)
+	if err != nil {
+		_ = conn.Close()
+	}
+	return err
+}
+
+// broadcastTarget 广播的目标连接
+type broadcastTarget struct {
+	conn  net.Conn
+	state *connState
+}
+
+// targets 工作区内需要接收广播的连接，room 不为空时只包含该房间的连接
+func (c *ConnList) targets(workspace string, room string) []broadcastTarget {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	var list []broadcastTarget
+	for conn, state := range c.connections {
+		if state.Workspace != workspace || (room != "" && state.Room != room) {
+			continue
+		}
+		list = append(list, broadcastTarget{conn: conn, state: state})
+	}
+	return list
+}
+
 // IsExist 连接是否存在
 func (c *ConnList) IsExist(conn net.Conn) bool {
 	c.rw.RLock()
-	_, exists := c.Connections[conn]
+	_, exists := c.connections[conn]
 	c.rw.RUnlock()
 	return exists
 }
 
-// IsNameExist 昵称是否已存在
-func (c *ConnList) IsNameExist(nickName string) bool {
-	for _, v := range c.Connections {
-		if v.NickName == nickName {
+// IsNameExist 工作区内昵称是否已存在
+func (c *ConnList) IsNameExist(workspace string, nickName string) bool {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	for _, v := range c.connections {
+		if v.Workspace == workspace && v.NickName == nickName {
 			return true
 		}
 	}
 	return false
 }
 
-// GetConnByNickName 通过昵称获取连接
-func (c *ConnList) GetConnByNickName(nickName string) (net.Conn, error) {
-	for k, v := range c.Connections {
-		if v.NickName == nickName {
+// Rename 修改连接的昵称，新昵称已被工作区内其他连接使用时返回 false
+func (c *ConnList) Rename(conn net.Conn, nickName string) bool {
+	c.rw.Lock()
+	defer c.rw.Unlock()
+	state, ok := c.connections[conn]
+	if !ok {
+		return false
+	}
+	for _, v := range c.connections {
+		if v.Workspace == state.Workspace && v.NickName == nickName {
+			return false
+		}
+	}
+	state.NickName = nickName
+	return true
+}
+
+// GetConnByNickName 通过工作区与昵称获取连接
+func (c *ConnList) GetConnByNickName(workspace string, nickName string) (net.Conn, error) {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	for k, v := range c.connections {
+		if v.Workspace == workspace && v.NickName == nickName {
 			return k, nil
 		}
 	}
 	return nil, errors.New("no user")
 }
 
-// GetAllConn 获取所有连接
-func (c *ConnList) GetAllConn() map[net.Conn]*connState {
-	return c.Connections
+// FindConns 查找工作区内匹配处罚目标的连接，按昵称、IP 或网段匹配
+func (c *ConnList) FindConns(workspace string, target string) []net.Conn {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	var conns []net.Conn
+	for k, v := range c.connections {
+		if v.Workspace != workspace {
+			continue
+		}
+		if MatchTarget(target, v.NickName, v.Add) {
+			conns = append(conns, k)
+		}
+	}
+	return conns
+}
+
+// FindInfos 工作区内地址匹配 IP 或网段的连接信息
+func (c *ConnList) FindInfos(workspace string, target string) []ConnInfo {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	var infos []ConnInfo
+	for _, v := range c.connections {
+		if v.Workspace == workspace && MatchTarget(target, "", v.Add) {
+			infos = append(infos, v.ConnInfo)
+		}
+	}
+	return infos
+}
+
+// GetWorkspaceConns 获取工作区内的所有连接
+func (c *ConnList) GetWorkspaceConns(workspace string) []net.Conn {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	var conns []net.Conn
+	for k, v := range c.connections {
+		if v.Workspace == workspace {
+			conns = append(conns, k)
+		}
+	}
+	return conns
+}
+
+// GetAllConn 获取所有连接信息的快照
+func (c *ConnList) GetAllConn() map[net.Conn]ConnInfo {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	conns := make(map[net.Conn]ConnInfo, len(c.connections))
+	for k, v := range c.connections {
+		conns[k] = v.ConnInfo
+	}
+	return conns
 }
@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnListRename(t *testing.T) {
+	c := CreatConnList()
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		client, server := net.Pipe()
+		t.Cleanup(func() {
+			_ = client.Close()
+			_ = server.Close()
+		})
+		conns[i] = server
+	}
+	c.Add(conns[0], "default", "guest1", false)
+	c.Add(conns[1], "default", "alice", false)
+	c.Add(conns[2], "teamA", "bob", false)
+
+	if c.Rename(conns[0], "alice") {
+		t.Error("Rename() 使用了工作区内其他连接的昵称")
+	}
+	if !c.Rename(conns[0], "bob") {
+		t.Error("Rename() 不能使用其他工作区的昵称")
+	}
+	if info, _ := c.Get(conns[0]); info.NickName != "bob" {
+		t.Errorf("改名后 NickName = %q, want bob", info.NickName)
+	}
+	if c.IsNameExist("default", "guest1") || !c.IsNameExist("default", "bob") {
+		t.Error("改名后旧昵称仍然存在或新昵称不存在")
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if c.Rename(server, "dave") {
+		t.Error("Rename() 修改了不存在的连接")
+	}
+}
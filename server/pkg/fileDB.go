@@ -0,0 +1,647 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"easy-chat/server/object"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志记录类型
+const (
+	opSnapshot = "snapshot" // 压缩快照起始，之前的段全部作废
+	opHistory  = "history"  // 历史消息
+	opHistSet  = "hisset"   // 按消息编号替换历史消息，Field 为消息编号
+	opZIncr    = "zincr"    // 有序集合成员加分
+	opZSet     = "zset"     // 有序集合成员分数绝对值，仅出现在快照中
+	opZRem     = "zrem"     // 删除有序集合成员
+	opHashSet  = "hset"     // 设置哈希字段
+	opHashDel  = "hdel"     // 删除哈希字段
+	opHashIncr = "hincr"    // 哈希字段整数加值
+)
+
+const (
+	recordHeaderSize = 8             // 记录头：4 字节负载长度 + 4 字节 crc32 校验
+	compactTmpName   = "compact.tmp" // 压缩过程中的临时快照文件
+)
+
+// fileRecord 日志记录
+type fileRecord struct {
+	Op    string  `json:"op"`
+	Room  string  `json:"room,omitempty"`
+	Key   string  `json:"key,omitempty"`
+	Field string  `json:"field,omitempty"`
+	Value string  `json:"value,omitempty"`
+	Delta float64 `json:"delta,omitempty"`
+	TTL   int64   `json:"ttl,omitempty"` // 过期时间(Unix 秒)
+}
+
+// recordPos 记录在日志中的位置
+type recordPos struct {
+	seg  int
+	off  int64
+	size int64
+	id   string // 历史消息的消息编号
+}
+
+// FileStore 基于追加写分段日志的文件存储，历史消息、活跃度与房间信息在重启后保留
+// 消息队列、广播与在线用户仍由内存存储处理
+type FileStore struct {
+	*MemoryStore
+	dir         string
+	segmentSize int64
+	maxSegments int
+	fmu         sync.Mutex
+	segments    []int                  // 日志段编号，升序
+	readers     map[int]*os.File       // 日志段读句柄
+	active      *os.File               // 当前写入的日志段
+	activeSize  int64                  // 当前日志段大小
+	superseded  int64                  // 上次快照以来被替换的历史消息占用的字节数
+	index       map[string][]recordPos // 房间 -> 历史消息位置
+}
+
+// NewFileStore 创建文件存储，不同 namespace 使用各自的子目录
+// 打开时回放日志并修复被截断的尾部记录
+func NewFileStore(config object.Config, namespace string) (*FileStore, error) {
+	f := &FileStore{
+		MemoryStore: NewMemoryStore(config),
+		dir:         filepath.Join(config.File.Dir, namespace),
+		segmentSize: config.File.SegmentSize,
+		maxSegments: config.File.MaxSegments,
+		readers:     make(map[int]*os.File),
+		index:       make(map[string][]recordPos),
+	}
+	if f.segmentSize <= 0 {
+		f.segmentSize = 4 << 20
+	}
+	if f.maxSegments <= 0 {
+		f.maxSegments = 8
+	}
+	err := os.MkdirAll(f.dir, 0755)
+	if err != nil {
+		return nil, errors.New("创建数据目录失败: " + err.Error())
+	}
+	// 上次压缩未完成留下的临时文件
+	_ = os.Remove(filepath.Join(f.dir, compactTmpName))
+	err = f.load()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// segmentPath 日志段文件路径
+func (f *FileStore) segmentPath(seg int) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%08d.log", seg))
+}
+
+// load 回放所有日志段
+func (f *FileStore) load() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return errors.New("读取数据目录失败: " + err.Error())
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		seg, err := strconv.Atoi(strings.TrimSuffix(name, ".log"))
+		if err != nil {
+			continue
+		}
+		f.segments = append(f.segments, seg)
+	}
+	sort.Ints(f.segments)
+
+	for i, seg := range f.segments {
+		last := i == len(f.segments)-1
+		err = f.replay(seg, last)
+		if err != nil {
+			return err
+		}
+	}
+	// 删除快照之前已作废的日志段
+	f.dropObsolete()
+
+	if len(f.segments) == 0 {
+		return f.roll()
+	}
+	seg := f.segments[len(f.segments)-1]
+	f.active, err = os.OpenFile(f.segmentPath(seg), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New("打开日志段失败: " + err.Error())
+	}
+	info, err := f.active.Stat()
+	if err != nil {
+		return errors.New("读取日志段信息失败: " + err.Error())
+	}
+	f.activeSize = info.Size()
+	return nil
+}
+
+// replay 回放单个日志段，最后一个日志段尾部的残缺记录会被截断
+func (f *FileStore) replay(seg int, last bool) error {
+	file, err := os.Open(f.segmentPath(seg))
+	if err != nil {
+		return errors.New("打开日志段失败: " + err.Error())
+	}
+	f.readers[seg] = file
+
+	reader := bufio.NewReader(file)
+	var off int64
+	for {
+		rec, size, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !last {
+				return fmt.Errorf("日志段 %08d 在偏移 %d 处损坏: %v", seg, off, err)
+			}
+			// 崩溃时写了一半的记录，截断到最后一条完整记录
+			err = os.Truncate(f.segmentPath(seg), off)
+			if err != nil {
+				return errors.New("截断日志段失败: " + err.Error())
+			}
+			return nil
+		}
+		f.apply(rec, recordPos{seg: seg, off: off, size: size})
+		off += size
+	}
+}
+
+// readRecord 读取一条记录，返回记录及其占用的字节数
+func readRecord(reader *bufio.Reader) (fileRecord, int64, error) {
+	var rec fileRecord
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err == io.EOF {
+		return rec, 0, io.EOF
+	}
+	if err != nil {
+		return rec, 0, fmt.Errorf("记录头不完整(%d 字节)", n)
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	sum := binary.LittleEndian.Uint32(header[4:8])
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return rec, 0, errors.New("记录内容不完整")
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return rec, 0, errors.New("记录校验失败")
+	}
+	err = json.Unmarshal(payload, &rec)
+	if err != nil {
+		return rec, 0, errors.New("记录解析失败: " + err.Error())
+	}
+	return rec, int64(recordHeaderSize + len(payload)), nil
+}
+
+// apply 将记录应用到内存状态
+func (f *FileStore) apply(rec fileRecord, pos recordPos) {
+	m := f.MemoryStore
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch rec.Op {
+	case opSnapshot:
+		m.zsets = make(map[string]map[string]float64)
+		m.expires = make(map[string]time.Time)
+		m.hashes = make(map[string]map[string]string)
+		f.index = make(map[string][]recordPos)
+		f.superseded = 0
+	case opHistory:
+		pos.id = historyID(rec.Value)
+		positions := append(f.index[rec.Room], pos)
+		if m.historyLimit > 0 && len(positions) > m.historyLimit {
+			positions = positions[len(positions)-m.historyLimit:]
+		}
+		f.index[rec.Room] = positions
+	case opHistSet:
+		pos.id = rec.Field
+		for i, old := range f.index[rec.Room] {
+			if old.id == rec.Field {
+				f.superseded += old.size
+				f.index[rec.Room][i] = pos
+				break
+			}
+		}
+	case opZIncr, opZSet:
+		var expire time.Time
+		if rec.TTL > 0 {
+			expire = time.Unix(rec.TTL, 0)
+		}
+		if rec.Op == opZSet {
+			delete(m.zsets[rec.Key], rec.Field)
+		}
+		m.zincr(rec.Key, rec.Field, rec.Delta, expire)
+	case opZRem:
+		delete(m.zsets[rec.Key], rec.Field)
+	case opHashSet:
+		m.expireKey(rec.Key, time.Now())
+		if m.hashes[rec.Key] == nil {
+			m.hashes[rec.Key] = make(map[string]string)
+		}
+		m.hashes[rec.Key][rec.Field] = rec.Value
+		if rec.TTL > 0 {
+			m.expires[rec.Key] = time.Unix(rec.TTL, 0)
+		}
+	case opHashIncr:
+		var expire time.Time
+		if rec.TTL > 0 {
+			expire = time.Unix(rec.TTL, 0)
+		}
+		_, _ = m.hincr(rec.Key, rec.Field, int64(rec.Delta), expire)
+	case opHashDel:
+		delete(m.hashes[rec.Key], rec.Field)
+	}
+}
+
+// encodeRecord 编码记录
+func encodeRecord(rec fileRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+	copy(data[recordHeaderSize:], payload)
+	return data, nil
+}
+
+// append 追加记录并应用到内存状态，需持有 fmu
+func (f *FileStore) append(rec fileRecord) error {
+	data, err := encodeRecord(rec)
+	if err != nil {
+		return errors.New("编码记录失败: " + err.Error())
+	}
+	if f.activeSize > 0 && f.activeSize+int64(len(data)) > f.segmentSize {
+		if len(f.segments) >= f.maxSegments {
+			err = f.compact()
+		} else {
+			err = f.roll()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.active.Write(data)
+	if err != nil {
+		return errors.New("写入日志失败: " + err.Error())
+	}
+	pos := recordPos{seg: f.segments[len(f.segments)-1], off: f.activeSize, size: int64(len(data))}
+	f.activeSize += int64(len(data))
+	f.apply(rec, pos)
+	return nil
+}
+
+// roll 切换到新的日志段，需持有 fmu
+func (f *FileStore) roll() error {
+	seg := 1
+	if len(f.segments) > 0 {
+		seg = f.segments[len(f.segments)-1] + 1
+	}
+	active, err := os.OpenFile(f.segmentPath(seg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New("创建日志段失败: " + err.Error())
+	}
+	reader, err := os.Open(f.segmentPath(seg))
+	if err != nil {
+		_ = active.Close()
+		return errors.New("打开日志段失败: " + err.Error())
+	}
+	if f.active != nil {
+		_ = f.active.Sync()
+		_ = f.active.Close()
+	}
+	f.active = active
+	f.activeSize = 0
+	f.readers[seg] = reader
+	f.segments = append(f.segments, seg)
+	return nil
+}
+
+// compact 压缩日志：把当前状态写成快照段，然后删除旧日志段，需持有 fmu
+func (f *FileStore) compact() error {
+	m := f.MemoryStore
+	// 收集需要保留的历史消息
+	m.mu.RLock()
+	rooms := make(map[string][]string, len(f.index))
+	for room, positions := range f.index {
+		for _, pos := range positions {
+			msg, err := f.readHistory(pos)
+			if err != nil {
+				m.mu.RUnlock()
+				return err
+			}
+			rooms[room] = append(rooms[room], msg)
+		}
+	}
+	records := []fileRecord{{Op: opSnapshot}}
+	now := time.Now()
+	for key, members := range m.zsets {
+		// 已过期的有序集合在压缩时丢弃
+		if expire, ok := m.expires[key]; ok && !now.Before(expire) {
+			continue
+		}
+		var ttl int64
+		if expire, ok := m.expires[key]; ok {
+			ttl = expire.Unix()
+		}
+		for member, score := range members {
+			records = append(records, fileRecord{Op: opZSet, Key: key, Field: member, Delta: score, TTL: ttl})
+		}
+	}
+	for key, fields := range m.hashes {
+		if expire, ok := m.expires[key]; ok && !now.Before(expire) {
+			continue
+		}
+		var ttl int64
+		if expire, ok := m.expires[key]; ok {
+			ttl = expire.Unix()
+		}
+		for field, value := range fields {
+			records = append(records, fileRecord{Op: opHashSet, Key: key, Field: field, Value: value, TTL: ttl})
+		}
+	}
+	m.mu.RUnlock()
+	for room, history := range rooms {
+		for _, msg := range history {
+			records = append(records, fileRecord{Op: opHistory, Room: room, Value: msg})
+		}
+	}
+
+	// 快照先写入临时文件，完整落盘后再改名为新的日志段，崩溃时旧日志段仍然完整
+	tmpPath := filepath.Join(f.dir, compactTmpName)
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.New("创建快照失败: " + err.Error())
+	}
+	var size int64
+	positions := make([]recordPos, 0, len(records))
+	for _, rec := range records {
+		data, err := encodeRecord(rec)
+		if err != nil {
+			_ = tmp.Close()
+			return errors.New("编码记录失败: " + err.Error())
+		}
+		_, err = tmp.Write(data)
+		if err != nil {
+			_ = tmp.Close()
+			return errors.New("写入快照失败: " + err.Error())
+		}
+		positions = append(positions, recordPos{off: size, size: int64(len(data))})
+		size += int64(len(data))
+	}
+	err = tmp.Sync()
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		return errors.New("写入快照失败: " + err.Error())
+	}
+	seg := f.segments[len(f.segments)-1] + 1
+	err = os.Rename(tmpPath, f.segmentPath(seg))
+	if err != nil {
+		return errors.New("保存快照失败: " + err.Error())
+	}
+	err = f.roll()
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		positions[i].seg = seg
+		f.apply(rec, positions[i])
+	}
+	f.activeSize = size
+	f.dropObsolete()
+	return nil
+}
+
+// dropObsolete 删除最近一次快照之前的日志段
+func (f *FileStore) dropObsolete() {
+	snapshot := -1
+	for i, seg := range f.segments {
+		file, ok := f.readers[seg]
+		if !ok {
+			continue
+		}
+		rec, _, err := readRecord(bufio.NewReader(io.NewSectionReader(file, 0, recordHeaderSize+64)))
+		if err == nil && rec.Op == opSnapshot {
+			snapshot = i
+		}
+	}
+	if snapshot <= 0 {
+		return
+	}
+	for _, seg := range f.segments[:snapshot] {
+		if file, ok := f.readers[seg]; ok {
+			_ = file.Close()
+			delete(f.readers, seg)
+		}
+		_ = os.Remove(f.segmentPath(seg))
+	}
+	f.segments = append([]int(nil), f.segments[snapshot:]...)
+}
+
+// readHistory 按位置读取历史消息
+func (f *FileStore) readHistory(pos recordPos) (string, error) {
+	file, ok := f.readers[pos.seg]
+	if !ok {
+		return "", fmt.Errorf("日志段 %08d 不存在", pos.seg)
+	}
+	rec, _, err := readRecord(bufio.NewReader(io.NewSectionReader(file, pos.off, pos.size)))
+	if err != nil {
+		return "", err
+	}
+	return rec.Value, nil
+}
+
+// Clean 清理运行时数据，持久化的数据保留
+func (f *FileStore) Clean(ctx context.Context) error {
+	return f.cleanRuntime()
+}
+
+// ZIncrBy 为有序集合成员加分
+func (f *FileStore) ZIncrBy(ctx context.Context, key string, member string, delta float64, ttl time.Duration) error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	rec := fileRecord{Op: opZIncr, Key: key, Field: member, Delta: delta}
+	if ttl > 0 {
+		rec.TTL = time.Now().Add(ttl).Unix()
+	}
+	return f.append(rec)
+}
+
+// ZRem 删除有序集合成员
+func (f *FileStore) ZRem(ctx context.Context, key string, member string) error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	return f.append(fileRecord{Op: opZRem, Key: key, Field: member})
+}
+
+// AddHistory 记录房间历史消息
+func (f *FileStore) AddHistory(ctx context.Context, room string, msg string) error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	return f.append(fileRecord{Op: opHistory, Room: room, Value: msg})
+}
+
+// History 通过索引获取房间最近 n 条历史消息
+func (f *FileStore) History(ctx context.Context, room string, n int) ([]string, error) {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	positions := f.index[room]
+	if n < len(positions) {
+		positions = positions[len(positions)-n:]
+	}
+	history := make([]string, 0, len(positions))
+	for _, pos := range positions {
+		msg, err := f.readHistory(pos)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, msg)
+	}
+	return history, nil
+}
+
+// GetHistory 按消息编号获取房间的历史消息
+func (f *FileStore) GetHistory(ctx context.Context, room string, id string) (string, bool, error) {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	pos, ok := f.findHistory(room, id)
+	if !ok {
+		return "", false, nil
+	}
+	msg, err := f.readHistory(pos)
+	if err != nil {
+		return "", false, err
+	}
+	return msg, true, nil
+}
+
+// SetHistory 按消息编号替换房间的历史消息，追加一条替换记录后索引指向新记录
+// 被替换的旧内容仍留在日志段中，在下次压缩时清除；被替换的内容累计达到一个日志段大小时立即压缩
+func (f *FileStore) SetHistory(ctx context.Context, room string, id string, msg string) (bool, error) {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	if _, ok := f.findHistory(room, id); !ok {
+		return false, nil
+	}
+	err := f.append(fileRecord{Op: opHistSet, Room: room, Field: id, Value: msg})
+	if err != nil {
+		return false, err
+	}
+	if f.superseded >= f.segmentSize {
+		err = f.compact()
+	}
+	return true, err
+}
+
+// findHistory 按消息编号查找历史消息的位置，需持有 fmu
+func (f *FileStore) findHistory(room string, id string) (recordPos, bool) {
+	m := f.MemoryStore
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, pos := range f.index[room] {
+		if pos.id == id {
+			return pos, true
+		}
+	}
+	return recordPos{}, false
+}
+
+// HashSet 设置哈希字段
+func (f *FileStore) HashSet(ctx context.Context, key string, field string, value string) error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	return f.append(fileRecord{Op: opHashSet, Key: key, Field: field, Value: value})
+}
+
+// HashSetNX 哈希字段不存在时设置，已存在时返回 false
+func (f *FileStore) HashSetNX(ctx context.Context, key string, field string, value string) (bool, error) {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	m := f.MemoryStore
+	m.mu.Lock()
+	m.expireKey(key, time.Now())
+	_, exists := m.hashes[key][field]
+	m.mu.Unlock()
+	if exists {
+		return false, nil
+	}
+	err := f.append(fileRecord{Op: opHashSet, Key: key, Field: field, Value: value})
+	return err == nil, err
+}
+
+// HashDel 删除哈希字段
+func (f *FileStore) HashDel(ctx context.Context, key string, field string) error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	return f.append(fileRecord{Op: opHashDel, Key: key, Field: field})
+}
+
+// HashIncr 为哈希字段加上整数增量并返回新值
+func (f *FileStore) HashIncr(ctx context.Context, key string, field string, delta int64, ttl time.Duration) (int64, error) {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	m := f.MemoryStore
+	m.mu.Lock()
+	m.expireKey(key, time.Now())
+	old, ok := m.hashes[key][field]
+	m.mu.Unlock()
+	var value int64
+	if ok {
+		v, err := strconv.ParseInt(old, 10, 64)
+		if err != nil {
+			return 0, errors.New("哈希字段不是整数: " + field)
+		}
+		value = v
+	}
+	rec := fileRecord{Op: opHashIncr, Key: key, Field: field, Delta: float64(delta)}
+	if ttl > 0 {
+		rec.TTL = time.Now().Add(ttl).Unix()
+	}
+	err := f.append(rec)
+	if err != nil {
+		return 0, err
+	}
+	return value + delta, nil
+}
+
+// Compact 手动压缩日志
+func (f *FileStore) Compact() error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	return f.compact()
+}
+
+// Close 关闭文件存储
+func (f *FileStore) Close() error {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	for seg, file := range f.readers {
+		_ = file.Close()
+		delete(f.readers, seg)
+	}
+	if f.active == nil {
+		return nil
+	}
+	_ = f.active.Sync()
+	return f.active.Close()
+}
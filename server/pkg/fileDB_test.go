@@ -0,0 +1,260 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// openFileStore 按配置打开默认工作区的文件存储，测试结束时关闭
+func openFileStore(t *testing.T, config object.Config) *FileStore {
+	t.Helper()
+	f, err := NewFileStore(config, "default")
+	if err != nil {
+		t.Fatalf("NewFileStore() error: %v", err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+// fileConfig 测试用的文件存储配置
+func fileConfig(t *testing.T, segmentSize int64, maxSegments int) object.Config {
+	var config object.Config
+	config.File.Dir = t.TempDir()
+	config.File.SegmentSize = segmentSize
+	config.File.MaxSegments = maxSegments
+	config.App.HistoryLimit = 100
+	return config
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	config := fileConfig(t, 0, 0)
+	ctx := context.Background()
+	f := openFileStore(t, config)
+	for i := 1; i <= 3; i++ {
+		_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: strconv.Itoa(i)}))
+	}
+	edited := testMessage(t, proto.Frame{ID: "2", Text: "edited"})
+	if ok, err := f.SetHistory(ctx, "lobby", "2", edited); !ok || err != nil {
+		t.Fatalf("SetHistory() = %v, %v", ok, err)
+	}
+	_ = f.HashSet(ctx, "rooms", "lobby", "大厅")
+	_, _ = f.HashIncr(ctx, "messages", "seq", 3, 0)
+	_ = f.ZIncrBy(ctx, "rank", "alice", 2, 0)
+	_ = f.Close()
+
+	f = openFileStore(t, config)
+	history, _ := f.History(ctx, "lobby", 10)
+	if got := historyIDs(history); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("重新打开后 History() = %v", got)
+	}
+	if got, _, _ := f.GetHistory(ctx, "lobby", "2"); got != edited {
+		t.Errorf("重新打开后 GetHistory() = %s, want %s", got, edited)
+	}
+	tests := []struct {
+		key   string
+		field string
+		want  string
+	}{
+		{key: "rooms", field: "lobby", want: "大厅"},
+		{key: "messages", field: "seq", want: "3"},
+	}
+	for _, tt := range tests {
+		if got, _, _ := f.HashGet(ctx, tt.key, tt.field); got != tt.want {
+			t.Errorf("重新打开后 HashGet(%s, %s) = %q, want %q", tt.key, tt.field, got, tt.want)
+		}
+	}
+	if score, ok, _ := f.ZScore(ctx, "rank", "alice"); !ok || score != 2 {
+		t.Errorf("重新打开后 ZScore() = %v, %v, want 2", score, ok)
+	}
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func(record []byte) []byte // 崩溃时写入的残缺记录
+	}{
+		{name: "记录头不完整", tail: func(record []byte) []byte { return record[:recordHeaderSize/2] }},
+		{name: "记录内容不完整", tail: func(record []byte) []byte { return record[:len(record)-3] }},
+		{name: "校验失败", tail: func(record []byte) []byte {
+			torn := append([]byte(nil), record...)
+			torn[len(torn)-2] ^= 0xff
+			return torn
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := fileConfig(t, 0, 0)
+			ctx := context.Background()
+			f := openFileStore(t, config)
+			_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "1"}))
+			_ = f.HashSet(ctx, "rooms", "lobby", "大厅")
+			path, size := f.segmentPath(f.segments[len(f.segments)-1]), f.activeSize
+			_ = f.Close()
+
+			record, err := encodeRecord(fileRecord{Op: opHistory, Room: "lobby", Value: testMessage(t, proto.Frame{ID: "2"})})
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = file.Write(tt.tail(record))
+			_ = file.Close()
+
+			f = openFileStore(t, config)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != size {
+				t.Fatalf("残缺记录未被截断: size = %d, want %d", info.Size(), size)
+			}
+			history, _ := f.History(ctx, "lobby", 10)
+			if got := historyIDs(history); !reflect.DeepEqual(got, []string{"1"}) {
+				t.Errorf("History() = %v, want [1]", got)
+			}
+			// 截断后继续写入，重新打开仍能读取
+			_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "3"}))
+			_ = f.Close()
+			f = openFileStore(t, config)
+			history, _ = f.History(ctx, "lobby", 10)
+			if got := historyIDs(history); !reflect.DeepEqual(got, []string{"1", "3"}) {
+				t.Errorf("继续写入后 History() = %v, want [1 3]", got)
+			}
+			if got, _, _ := f.HashGet(ctx, "rooms", "lobby"); got != "大厅" {
+				t.Errorf("HashGet() = %q, want 大厅", got)
+			}
+		})
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	config := fileConfig(t, 512, 3)
+	config.App.HistoryLimit = 5
+	ctx := context.Background()
+	f := openFileStore(t, config)
+	for i := 1; i <= 50; i++ {
+		_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: strconv.Itoa(i), Text: "hello"}))
+		_, _ = f.HashIncr(ctx, "messages", "seq", 1, 0)
+	}
+	if len(f.segments) > 3 {
+		t.Errorf("日志段数量 = %d，超过上限 3", len(f.segments))
+	}
+	entries, _ := os.ReadDir(f.dir)
+	if len(entries) != len(f.segments) {
+		t.Errorf("数据目录中有 %d 个文件，日志段 %d 个，旧日志段未删除", len(entries), len(f.segments))
+	}
+	_ = f.Close()
+
+	f = openFileStore(t, config)
+	history, _ := f.History(ctx, "lobby", 10)
+	if got := historyIDs(history); !reflect.DeepEqual(got, []string{"46", "47", "48", "49", "50"}) {
+		t.Errorf("压缩后 History() = %v", got)
+	}
+	if got, _, _ := f.HashGet(ctx, "messages", "seq"); got != "50" {
+		t.Errorf("压缩后 HashGet() = %q, want 50", got)
+	}
+}
+
+func TestFileStoreDeletePurge(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace proto.Frame
+	}{
+		{name: "删除消息", replace: proto.Frame{ID: "1", Deleted: true}},
+		{name: "编辑消息", replace: proto.Frame{ID: "1", Text: "edited", Edited: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := fileConfig(t, 0, 0)
+			ctx := context.Background()
+			f := openFileStore(t, config)
+			_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "1", Text: "secret-text"}))
+			_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "2", Text: "other"}))
+			if ok, err := f.SetHistory(ctx, "lobby", "1", testMessage(t, tt.replace)); !ok || err != nil {
+				t.Fatalf("SetHistory() = %v, %v", ok, err)
+			}
+			// 只追加替换记录，不立即压缩
+			if !dataContains(t, config.File.Dir, "secret-text") {
+				t.Error("SetHistory() 重写了日志")
+			}
+			history, _ := f.History(ctx, "lobby", 10)
+			if got := historyIDs(history); !reflect.DeepEqual(got, []string{"1", "2"}) {
+				t.Errorf("History() = %v, want [1 2]", got)
+			}
+			if msg, _, _ := f.GetHistory(ctx, "lobby", "1"); strings.Contains(msg, "secret-text") {
+				t.Errorf("GetHistory() = %s，仍是原文", msg)
+			}
+			// 压缩后原文从磁盘上清除
+			if err := f.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			if dataContains(t, config.File.Dir, "secret-text") {
+				t.Error("压缩后磁盘上仍有原文")
+			}
+		})
+	}
+}
+
+func TestFileStoreSupersededCompact(t *testing.T) {
+	// 日志段数量不设限，只有被替换的内容达到一个日志段大小时才会压缩
+	config := fileConfig(t, 2048, 100)
+	ctx := context.Background()
+	f := openFileStore(t, config)
+	padding := strings.Repeat("x", 800)
+	_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "1", Text: "secret-one " + padding}))
+	_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "2", Text: "secret-two " + padding}))
+	_ = f.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "3", Text: "secret-three " + padding}))
+	_, _ = f.SetHistory(ctx, "lobby", "1", testMessage(t, proto.Frame{ID: "1", Deleted: true}))
+	_, _ = f.SetHistory(ctx, "lobby", "2", testMessage(t, proto.Frame{ID: "2", Deleted: true}))
+	if !dataContains(t, config.File.Dir, "secret-one") {
+		t.Fatal("被替换的内容未达到日志段大小时就压缩了")
+	}
+	_ = f.Close()
+
+	// 重新打开后回放日志仍能统计被替换的内容
+	f = openFileStore(t, config)
+	_, _ = f.SetHistory(ctx, "lobby", "3", testMessage(t, proto.Frame{ID: "3", Deleted: true}))
+	for _, text := range []string{"secret-one", "secret-two", "secret-three"} {
+		if dataContains(t, config.File.Dir, text) {
+			t.Errorf("被替换的内容达到日志段大小后磁盘上仍有 %s", text)
+		}
+	}
+	if f.superseded != 0 {
+		t.Errorf("压缩后 superseded = %d, want 0", f.superseded)
+	}
+	history, _ := f.History(ctx, "lobby", 10)
+	if got := historyIDs(history); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("History() = %v, want [1 2 3]", got)
+	}
+}
+
+// dataContains 数据目录中是否有文件包含 text
+func dataContains(t *testing.T, dir string, text string) bool {
+	t.Helper()
+	found := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		found = found || bytes.Contains(data, []byte(text))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
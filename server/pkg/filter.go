@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 过滤规则的处理方式
+const (
+	FilterBlock = "block" // 拒绝发送并提示发送者
+	FilterMask  = "mask"  // 命中内容替换为星号后发送
+	FilterFlag  = "flag"  // 正常发送并提示版主
+)
+
+// filterRule 过滤规则
+type filterRule struct {
+	action  string
+	pattern string // 规则原文，用于日志
+	re      *regexp.Regexp
+}
+
+// FilterResult 消息过滤结果
+type FilterResult struct {
+	Text    string   // 处理后的消息
+	Blocked bool     // 是否拒绝发送
+	Masked  bool     // 是否有内容被替换
+	Flagged bool     // 是否需要提示版主
+	Rules   []string // 命中的规则
+}
+
+// ContentFilter 内容过滤器，从规则文件加载，文件变化后可重新加载
+type ContentFilter struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	size    int64
+	rules   []filterRule
+}
+
+// LoadContentFilter 加载规则文件，path 为空时返回 nil，此时不过滤任何消息
+// 文件不存在时规则为空，之后创建文件会被重新加载
+func LoadContentFilter(path string) (*ContentFilter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f := &ContentFilter{path: path}
+	if _, _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path 规则文件路径
+func (f *ContentFilter) Path() string {
+	return f.path
+}
+
+// Reload 规则文件发生变化时重新加载，返回是否重新加载与规则条数
+// 规则有误时保留原有规则并返回错误，同一版本的文件只报告一次
+func (f *ContentFilter) Reload() (bool, int, error) {
+	var modTime time.Time
+	var size int64
+	info, err := os.Stat(f.path)
+	switch {
+	case err == nil:
+		modTime, size = info.ModTime(), info.Size()
+	case !errors.Is(err, os.ErrNotExist):
+		return false, 0, errors.New("读取过滤规则失败: " + err.Error())
+	}
+	f.mu.RLock()
+	unchanged := modTime.Equal(f.modTime) && size == f.size
+	count := len(f.rules)
+	f.mu.RUnlock()
+	if unchanged {
+		return false, count, nil
+	}
+	var rules []filterRule
+	if !modTime.IsZero() {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return false, count, errors.New("读取过滤规则失败: " + err.Error())
+		}
+		rules, err = parseFilterRules(data)
+		if err != nil {
+			// 记录出错的版本，文件再次修改前不重复加载
+			f.mu.Lock()
+			f.modTime, f.size = modTime, size
+			f.mu.Unlock()
+			return false, count, err
+		}
+	}
+	f.mu.Lock()
+	f.rules, f.modTime, f.size = rules, modTime, size
+	f.mu.Unlock()
+	return true, len(rules), nil
+}
+
+// parseFilterRules 解析规则文件，每行一条规则：<block|mask|flag> <词语或 /正则/>
+// 词语不区分大小写，# 开头的行为注释
+func parseFilterRules(data []byte) ([]filterRule, error) {
+	var rules []filterRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, pattern, _ := strings.Cut(line, " ")
+		pattern = strings.TrimSpace(pattern)
+		switch action {
+		case FilterBlock, FilterMask, FilterFlag:
+		default:
+			return nil, fmt.Errorf("过滤规则第 %d 行: 未知的处理方式 %q，可选 block、mask、flag", n, action)
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("过滤规则第 %d 行: 缺少词语或正则", n)
+		}
+		expr := "(?i)" + regexp.QuoteMeta(pattern)
+		if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("过滤规则第 %d 行: 正则有误: %v", n, err)
+		}
+		rules = append(rules, filterRule{action: action, pattern: pattern, re: re})
+	}
+	return rules, scanner.Err()
+}
+
+// Check 按规则过滤消息，命中 block 规则时不再处理其他规则
+func (f *ContentFilter) Check(text string) FilterResult {
+	result := FilterResult{Text: text}
+	if f == nil {
+		return result
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, rule := range f.rules {
+		if rule.action == FilterBlock && rule.re.MatchString(text) {
+			result.Blocked = true
+			result.Rules = []string{rule.pattern}
+			return result
+		}
+	}
+	for _, rule := range f.rules {
+		switch {
+		case rule.action == FilterFlag && rule.re.MatchString(text):
+			result.Flagged = true
+		case rule.action == FilterMask && rule.re.MatchString(result.Text):
+			result.Masked = true
+			result.Text = rule.re.ReplaceAllStringFunc(result.Text, func(s string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(s))
+			})
+		default:
+			continue
+		}
+		result.Rules = append(result.Rules, rule.pattern)
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilterRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		patterns []string
+		err      string // 错误包含的文本
+	}{
+		{name: "空文件", data: "", patterns: nil},
+		{name: "注释与空行", data: "# 注释\n\n  \nblock spam\n", patterns: []string{"spam"}},
+		{name: "三种处理方式", data: "block a1\nmask b2\nflag c3", patterns: []string{"a1", "b2", "c3"}},
+		{name: "词语中的空格", data: "mask  bad word ", patterns: []string{"bad word"}},
+		{name: "正则", data: `flag /\d{3}-\d{4}/`, patterns: []string{`/\d{3}-\d{4}/`}},
+		{name: "未知的处理方式", data: "block a\ndeny b", err: "第 2 行: 未知的处理方式"},
+		{name: "缺少词语", data: "mask", err: "第 1 行: 缺少词语或正则"},
+		{name: "正则有误", data: "flag /a(/", err: "第 1 行: 正则有误"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseFilterRules([]byte(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseFilterRules() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var patterns []string
+			for _, rule := range rules {
+				patterns = append(patterns, rule.pattern)
+			}
+			if !reflect.DeepEqual(patterns, tt.patterns) {
+				t.Errorf("parseFilterRules() = %v, want %v", patterns, tt.patterns)
+			}
+		})
+	}
+}
+
+func TestContentFilterCheck(t *testing.T) {
+	rules, err := parseFilterRules([]byte("block forbidden\nmask darn\nmask /[0-9]{11}/\nflag scam\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &ContentFilter{rules: rules}
+	tests := []struct {
+		name string
+		text string
+		want FilterResult
+	}{
+		{name: "未命中", text: "hello", want: FilterResult{Text: "hello"}},
+		{name: "拒绝", text: "this is Forbidden darn", want: FilterResult{Text: "this is Forbidden darn", Blocked: true, Rules: []string{"forbidden"}}},
+		{name: "词语不区分大小写", text: "DARN it", want: FilterResult{Text: "**** it", Masked: true, Rules: []string{"darn"}}},
+		{name: "按字符数替换", text: "call 13800138000 darn", want: FilterResult{Text: "call *********** ****", Masked: true, Rules: []string{"darn", "/[0-9]{11}/"}}},
+		{name: "提示版主", text: "a scam", want: FilterResult{Text: "a scam", Flagged: true, Rules: []string{"scam"}}},
+		{name: "替换并提示", text: "scam darn", want: FilterResult{Text: "scam ****", Masked: true, Flagged: true, Rules: []string{"darn", "scam"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Check(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	var none *ContentFilter
+	if got := none.Check("forbidden"); got.Blocked || got.Text != "forbidden" {
+		t.Errorf("nil ContentFilter Check() = %+v", got)
+	}
+}
+
+func TestContentFilterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.txt")
+	f, err := LoadContentFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name     string
+		data     string // 为空时不修改文件
+		reloaded bool
+		count    int
+		wantErr  bool
+		blocked  bool // 修改后 "spam" 是否被拒绝
+	}{
+		{name: "文件不存在", reloaded: false, count: 0},
+		{name: "创建文件", data: "block spam\n", reloaded: true, count: 1, blocked: true},
+		{name: "文件未变化", reloaded: false, count: 1, blocked: true},
+		{name: "规则有误时保留原有规则", data: "deny spam\nblock x\n", reloaded: false, count: 1, wantErr: true, blocked: true},
+		{name: "有误的版本只报告一次", reloaded: false, count: 1, blocked: true},
+		{name: "修正规则", data: "flag spam\nflag eggs\n", reloaded: true, count: 2, blocked: false},
+	}
+	for _, s := range steps {
+		if s.data != "" {
+			if err := os.WriteFile(path, []byte(s.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reloaded, count, err := f.Reload()
+		if reloaded != s.reloaded || count != s.count || (err != nil) != s.wantErr {
+			t.Errorf("%s: Reload() = %v, %d, %v, want %v, %d, wantErr %v", s.name, reloaded, count, err, s.reloaded, s.count, s.wantErr)
+		}
+		if got := f.Check("spam").Blocked; got != s.blocked {
+			t.Errorf("%s: Check(spam).Blocked = %v, want %v", s.name, got, s.blocked)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// 入站 Webhook 记录
+const (
+	keyIncomingWebhooks   = "webhooks:incoming"     // 入站 Webhook 哈希 名称->JSON
+	keyIncomingWebhookIDs = "webhooks:incoming:ids" // 编号索引 编号->名称，按地址中的编号查找时使用
+)
+
+// IncomingWebhook 入站 Webhook，外部系统通过地址中的编号与令牌向房间发送消息，名称作为消息的发送者
+type IncomingWebhook struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Room      string `json:"room"`
+	TokenHash string `json:"tokenHash"` // 令牌的 SHA-256，令牌本身只在创建时显示一次
+	Creator   string `json:"creator"`
+	Created   int64  `json:"created"` // 创建时间(Unix 秒)
+}
+
+// CreateIncomingWebhook 创建入站 Webhook，返回 Webhook 与令牌，名称已存在时返回错误
+func CreateIncomingWebhook(ctx context.Context, s Store, room string, name string, creator string) (*IncomingWebhook, string, error) {
+	id := make([]byte, 8)
+	token := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", errors.New("生成编号失败: " + err.Error())
+	}
+	if _, err := rand.Read(token); err != nil {
+		return nil, "", errors.New("生成令牌失败: " + err.Error())
+	}
+	hook := &IncomingWebhook{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Room:      room,
+		TokenHash: hashWebhookToken(hex.EncodeToString(token)),
+		Creator:   creator,
+		Created:   time.Now().Unix(),
+	}
+	data, err := json.Marshal(hook)
+	if err != nil {
+		return nil, "", err
+	}
+	ok, err := s.HashSetNX(ctx, keyIncomingWebhooks, name, string(data))
+	if err != nil {
+		return nil, "", errors.New("保存 Webhook 失败: " + err.Error())
+	}
+	if !ok {
+		return nil, "", errors.New("Webhook 已存在: " + name)
+	}
+	err = s.HashSet(ctx, keyIncomingWebhookIDs, hook.ID, name)
+	if err != nil {
+		_ = s.HashDel(ctx, keyIncomingWebhooks, name)
+		return nil, "", errors.New("保存 Webhook 失败: " + err.Error())
+	}
+	return hook, hex.EncodeToString(token), nil
+}
+
+// DeleteIncomingWebhook 删除入站 Webhook，不存在时返回 false
+func DeleteIncomingWebhook(ctx context.Context, s Store, name string) (bool, error) {
+	hook, err := getIncomingWebhook(ctx, s, name)
+	if err != nil {
+		return false, err
+	}
+	if hook == nil {
+		return false, nil
+	}
+	err = s.HashDel(ctx, keyIncomingWebhooks, name)
+	if err != nil {
+		return false, errors.New("删除 Webhook 失败: " + err.Error())
+	}
+	_ = s.HashDel(ctx, keyIncomingWebhookIDs, hook.ID)
+	return true, nil
+}
+
+// getIncomingWebhook 按名称获取入站 Webhook，不存在时返回 nil
+func getIncomingWebhook(ctx context.Context, s Store, name string) (*IncomingWebhook, error) {
+	data, ok, err := s.HashGet(ctx, keyIncomingWebhooks, name)
+	if err != nil {
+		return nil, errors.New("获取 Webhook 失败: " + err.Error())
+	}
+	if !ok {
+		return nil, nil
+	}
+	var hook IncomingWebhook
+	if err = json.Unmarshal([]byte(data), &hook); err != nil {
+		return nil, errors.New("解析 Webhook 失败: " + err.Error())
+	}
+	return &hook, nil
+}
+
+// ListIncomingWebhooks 工作区的入站 Webhook，按名称排序
+func ListIncomingWebhooks(ctx context.Context, s Store) ([]IncomingWebhook, error) {
+	hooks, err := s.HashGetAll(ctx, keyIncomingWebhooks)
+	if err != nil {
+		return nil, errors.New("获取 Webhook 失败: " + err.Error())
+	}
+	list := make([]IncomingWebhook, 0, len(hooks))
+	for _, data := range hooks {
+		var hook IncomingWebhook
+		if json.Unmarshal([]byte(data), &hook) == nil {
+			list = append(list, hook)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// VerifyIncomingWebhook 按编号查找入站 Webhook 并校验令牌，不存在或令牌错误时返回 nil
+func VerifyIncomingWebhook(ctx context.Context, s Store, id string, token string) (*IncomingWebhook, error) {
+	name, ok, err := s.HashGet(ctx, keyIncomingWebhookIDs, id)
+	if err != nil {
+		return nil, errors.New("获取 Webhook 失败: " + err.Error())
+	}
+	if !ok {
+		return nil, nil
+	}
+	hook, err := getIncomingWebhook(ctx, s, name)
+	if err != nil || hook == nil || hook.ID != id {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hook.TokenHash), []byte(hashWebhookToken(token))) != 1 {
+		return nil, nil
+	}
+	return hook, nil
+}
+
+// hashWebhookToken 令牌的 SHA-256(十六进制)
+func hashWebhookToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/server/object"
+	"testing"
+)
+
+func TestVerifyIncomingWebhook(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(object.Config{})
+	hook, token, err := CreateIncomingWebhook(ctx, s, "dev", "ci", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherToken, err := CreateIncomingWebhook(ctx, s, "lobby", "deploy", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = CreateIncomingWebhook(ctx, s, "lobby", "ci", "console"); err == nil {
+		t.Error("重复的名称 CreateIncomingWebhook() 没有返回错误")
+	}
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  string
+	}{
+		{name: "编号与令牌正确", id: hook.ID, token: token, want: "ci"},
+		{name: "另一个 Webhook", id: other.ID, token: otherToken, want: "deploy"},
+		{name: "令牌错误", id: hook.ID, token: token + "0"},
+		{name: "使用其他 Webhook 的令牌", id: hook.ID, token: otherToken},
+		{name: "编号不存在", id: "0000000000000000", token: token},
+		{name: "编号为空", id: "", token: token},
+	}
+	for _, tt := range tests {
+		got, err := VerifyIncomingWebhook(ctx, s, tt.id, tt.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := ""
+		if got != nil {
+			name = got.Name
+			if got.Room == "" || got.TokenHash == token {
+				t.Errorf("%s: VerifyIncomingWebhook() = %+v", tt.name, got)
+			}
+		}
+		if name != tt.want {
+			t.Errorf("%s: VerifyIncomingWebhook() = %q, want %q", tt.name, name, tt.want)
+		}
+	}
+
+	// 删除后令牌失效，同名的新 Webhook 使用新的编号与令牌
+	if ok, err := DeleteIncomingWebhook(ctx, s, "ci"); err != nil || !ok {
+		t.Fatalf("DeleteIncomingWebhook() = %v, %v", ok, err)
+	}
+	if ok, _ := DeleteIncomingWebhook(ctx, s, "ci"); ok {
+		t.Error("重复删除 DeleteIncomingWebhook() = true")
+	}
+	if got, _ := VerifyIncomingWebhook(ctx, s, hook.ID, token); got != nil {
+		t.Errorf("删除后 VerifyIncomingWebhook() = %+v", got)
+	}
+	if _, ok, _ := s.HashGet(ctx, keyIncomingWebhookIDs, hook.ID); ok {
+		t.Error("删除后编号索引仍然存在")
+	}
+	renewed, renewedToken, err := CreateIncomingWebhook(ctx, s, "dev", "ci", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := VerifyIncomingWebhook(ctx, s, hook.ID, token); got != nil {
+		t.Errorf("重新创建后旧的地址 VerifyIncomingWebhook() = %+v", got)
+	}
+	if got, _ := VerifyIncomingWebhook(ctx, s, renewed.ID, renewedToken); got == nil || got.Name != "ci" {
+		t.Errorf("重新创建后 VerifyIncomingWebhook() = %+v", got)
+	}
+
+	list, err := ListIncomingWebhooks(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "ci" || list[1].Name != "deploy" {
+		t.Errorf("ListIncomingWebhooks() = %+v", list)
+	}
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoginFreeAttempts = 5                // 默认不限制的连续失败次数
+	defaultLoginBackoff      = time.Second      // 默认首次退避时长
+	defaultLoginMaxBackoff   = 5 * time.Minute  // 默认退避时长上限
+	loginFailureWindow       = time.Hour        // 超过该时长没有再失败时重新计数
+	loginGuardPrune          = 10 * time.Minute // 清理过期记录的间隔
+)
+
+// loginFailure 登录失败记录
+type loginFailure struct {
+	count int       // 连续失败次数
+	until time.Time // 退避结束时间
+	last  time.Time // 最近一次失败时间
+}
+
+// LoginGuard 登录失败退避，按来源 IP 与昵称分别记录连续失败次数
+// 超过不限制的次数后，每次失败的退避时长翻倍，退避期间不再校验密码
+// 记录保存在本节点内存中，多实例部署时各节点分别计数
+type LoginGuard struct {
+	mu         sync.Mutex
+	free       int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	failures   map[string]*loginFailure
+	lastPrune  time.Time
+}
+
+// NewLoginGuard 根据配置创建登录失败退避
+func NewLoginGuard(config object.Config) *LoginGuard {
+	g := &LoginGuard{
+		free:       config.Account.LoginFreeAttempts,
+		backoff:    time.Duration(config.Account.LoginBackoff) * time.Second,
+		maxBackoff: time.Duration(config.Account.LoginMaxBackoff) * time.Second,
+		failures:   make(map[string]*loginFailure),
+		lastPrune:  time.Now(),
+	}
+	if g.free <= 0 {
+		g.free = defaultLoginFreeAttempts
+	}
+	if g.backoff <= 0 {
+		g.backoff = defaultLoginBackoff
+	}
+	if g.maxBackoff <= 0 {
+		g.maxBackoff = defaultLoginMaxBackoff
+	}
+	if g.maxBackoff < g.backoff {
+		g.maxBackoff = g.backoff
+	}
+	if g.maxBackoff > loginFailureWindow {
+		g.maxBackoff = loginFailureWindow
+	}
+	return g
+}
+
+// LoginKeys 登录失败记录的键：来源 IP 与工作区内的昵称
+func LoginKeys(addr string, workspace string, nickName string) []string {
+	return []string{"ip:" + hostOf(addr), "nick:" + workspace + "/" + nickName}
+}
+
+// Wait 返回仍需等待的退避时长，0 表示可以尝试登录
+func (g *LoginGuard) Wait(keys []string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var wait time.Duration
+	for _, key := range keys {
+		if f, ok := g.failures[key]; ok && f.until.After(now) && f.until.Sub(now) > wait {
+			wait = f.until.Sub(now)
+		}
+	}
+	return wait
+}
+
+// Fail 记录一次密码错误，返回下次尝试前需要等待的时长
+func (g *LoginGuard) Fail(keys []string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prune(now)
+	var wait time.Duration
+	for _, key := range keys {
+		f, ok := g.failures[key]
+		if !ok || now.Sub(f.last) > loginFailureWindow {
+			f = &loginFailure{}
+			g.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count <= g.free {
+			continue
+		}
+		d := g.backoff
+		for i := g.free + 1; i < f.count && d < g.maxBackoff; i++ {
+			d *= 2
+		}
+		if d > g.maxBackoff {
+			d = g.maxBackoff
+		}
+		f.until = now.Add(d)
+		if d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Succeed 登录成功后清除昵称的失败记录
+// 来源 IP 的记录不清除，避免用自己的账号登录来重置对其他昵称的猜测次数
+func (g *LoginGuard) Succeed(workspace string, nickName string) {
+	g.mu.Lock()
+	delete(g.failures, "nick:"+workspace+"/"+nickName)
+	g.mu.Unlock()
+}
+
+// prune 清理退避已结束且超过计数窗口没有再失败的记录，需持有 mu
+func (g *LoginGuard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < loginGuardPrune {
+		return
+	}
+	g.lastPrune = now
+	for key, f := range g.failures {
+		if now.After(f.until) && now.Sub(f.last) > loginFailureWindow {
+			delete(g.failures, key)
+		}
+	}
+}
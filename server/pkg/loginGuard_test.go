@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"testing"
+	"time"
+)
+
+func TestLoginGuard(t *testing.T) {
+	var config object.Config
+	config.Account.LoginFreeAttempts = 2
+	config.Account.LoginBackoff = 1
+	config.Account.LoginMaxBackoff = 4
+	g := NewLoginGuard(config)
+	keys := LoginKeys("10.0.0.1:5000", "default", "alice")
+	start := time.Unix(1000, 0)
+	// 前两次失败不退避，之后每次翻倍，不超过上限
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		now := start.Add(time.Duration(i) * time.Minute)
+		if got := g.Fail(keys, now); got != want {
+			t.Errorf("第 %d 次 Fail() = %v, want %v", i+1, got, want)
+		}
+		if got := g.Wait(keys, now); got != want {
+			t.Errorf("第 %d 次失败后 Wait() = %v, want %v", i+1, got, want)
+		}
+	}
+	now := start.Add(5 * time.Minute)
+	tests := []struct {
+		name string
+		keys []string
+		at   time.Duration
+		want time.Duration
+	}{
+		{name: "退避期间", keys: keys, at: time.Second, want: 3 * time.Second},
+		{name: "退避结束", keys: keys, at: 4 * time.Second, want: 0},
+		{name: "同一 IP 的其他昵称", keys: LoginKeys("10.0.0.1:6000", "default", "bob"), at: time.Second, want: 3 * time.Second},
+		{name: "其他工作区的同名用户", keys: LoginKeys("10.0.0.2:5000", "teamA", "alice"), at: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := g.Wait(tt.keys, now.Add(tt.at)); got != tt.want {
+			t.Errorf("%s: Wait() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// 登录成功只清除昵称的记录，来源 IP 仍在退避
+	g.Succeed("default", "alice")
+	if got := g.Wait(LoginKeys("10.0.0.2:5000", "default", "alice"), now); got != 0 {
+		t.Errorf("登录成功后其他 IP 的 Wait() = %v, want 0", got)
+	}
+	if got := g.Wait(keys, now); got != 4*time.Second {
+		t.Errorf("登录成功后同一 IP 的 Wait() = %v, want 4s", got)
+	}
+	// 超过计数窗口没有再失败时重新计数
+	later := now.Add(loginFailureWindow + time.Minute)
+	if got := g.Fail(keys, later); got != 0 {
+		t.Errorf("超过计数窗口后 Fail() = %v, want 0", got)
+	}
+}
+
+func TestNewLoginGuard(t *testing.T) {
+	tests := []struct {
+		name       string
+		free       int
+		backoff    int
+		maxBackoff int
+		want       *LoginGuard
+	}{
+		{name: "默认值", want: &LoginGuard{free: defaultLoginFreeAttempts, backoff: defaultLoginBackoff, maxBackoff: defaultLoginMaxBackoff}},
+		{name: "上限小于首次退避", free: 3, backoff: 10, maxBackoff: 5, want: &LoginGuard{free: 3, backoff: 10 * time.Second, maxBackoff: 10 * time.Second}},
+		{name: "上限不超过计数窗口", free: 1, backoff: 1, maxBackoff: 86400, want: &LoginGuard{free: 1, backoff: time.Second, maxBackoff: loginFailureWindow}},
+	}
+	for _, tt := range tests {
+		var config object.Config
+		config.Account.LoginFreeAttempts = tt.free
+		config.Account.LoginBackoff = tt.backoff
+		config.Account.LoginMaxBackoff = tt.maxBackoff
+		g := NewLoginGuard(config)
+		if g.free != tt.want.free || g.backoff != tt.want.backoff || g.maxBackoff != tt.want.maxBackoff {
+			t.Errorf("%s: NewLoginGuard() = %d, %v, %v, want %d, %v, %v",
+				tt.name, g.free, g.backoff, g.maxBackoff, tt.want.free, tt.want.backoff, tt.want.maxBackoff)
+		}
+	}
+}
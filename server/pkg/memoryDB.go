@@ -0,0 +1,358 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/server/object"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存存储，适用于单机运行，无需 Redis
+type MemoryStore struct {
+	node         string
+	queue        chan string
+	mu           sync.RWMutex
+	subs         []chan string
+	presence     map[string]string
+	zsets        map[string]map[string]float64
+	expires      map[string]time.Time // 有序集合与哈希的过期时间
+	history      map[string][]string
+	hashes       map[string]map[string]string
+	historyLimit int
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore(config object.Config) *MemoryStore {
+	return &MemoryStore{
+		node:         config.App.Node,
+		queue:        make(chan string, 1024),
+		presence:     make(map[string]string),
+		zsets:        make(map[string]map[string]float64),
+		expires:      make(map[string]time.Time),
+		history:      make(map[string][]string),
+		hashes:       make(map[string]map[string]string),
+		historyLimit: config.App.HistoryLimit,
+	}
+}
+
+// Node 当前节点标识
+func (m *MemoryStore) Node() string {
+	return m.node
+}
+
+// Clean 清理数据
+func (m *MemoryStore) Clean(ctx context.Context) error {
+	m.mu.Lock()
+	m.zsets = make(map[string]map[string]float64)
+	m.expires = make(map[string]time.Time)
+	m.hashes = make(map[string]map[string]string)
+	m.mu.Unlock()
+	return m.cleanRuntime()
+}
+
+// cleanRuntime 清理在线用户与消息队列等运行时数据
+func (m *MemoryStore) cleanRuntime() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.presence = make(map[string]string)
+	for {
+		select {
+		case <-m.queue:
+		default:
+			return nil
+		}
+	}
+}
+
+// MsgQueuePush 消息入队
+func (m *MemoryStore) MsgQueuePush(ctx context.Context, msg string) error {
+	select {
+	case m.queue <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MsgQueuePop 消息出队
+func (m *MemoryStore) MsgQueuePop(ctx context.Context) (string, error) {
+	select {
+	case msg := <-m.queue:
+		return msg, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Publish 广播消息给所有订阅者
+func (m *MemoryStore) Publish(ctx context.Context, msg string) error {
+	m.mu.RLock()
+	subs := m.subs
+	m.mu.RUnlock()
+	for _, ch := range subs {
+		select {
+		case ch <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe 订阅广播消息
+func (m *MemoryStore) Subscribe(ctx context.Context) (<-chan string, error) {
+	ch := make(chan string, 64)
+	m.mu.Lock()
+	m.subs = append(m.subs, ch)
+	m.mu.Unlock()
+	return ch, nil
+}
+
+// AddPresence 登记在线用户
+func (m *MemoryStore) AddPresence(ctx context.Context, nickName string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.presence[nickName]; ok {
+		return false, nil
+	}
+	m.presence[nickName] = m.node
+	return true, nil
+}
+
+// DelPresence 注销在线用户
+func (m *MemoryStore) DelPresence(ctx context.Context, nickName string) error {
+	m.mu.Lock()
+	delete(m.presence, nickName)
+	m.mu.Unlock()
+	return nil
+}
+
+// GetPresence 获取在线用户
+func (m *MemoryStore) GetPresence(ctx context.Context) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	presence := make(map[string]string, len(m.presence))
+	for k, v := range m.presence {
+		presence[k] = v
+	}
+	return presence, nil
+}
+
+// ZIncrBy 为有序集合成员加分
+func (m *MemoryStore) ZIncrBy(ctx context.Context, key string, member string, delta float64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	m.zincr(key, member, delta, expire)
+	return nil
+}
+
+// zincr 为有序集合成员加分，expire 非零时更新过期时间，需持有 mu
+func (m *MemoryStore) zincr(key string, member string, delta float64, expire time.Time) {
+	m.expireKey(key, time.Now())
+	if m.zsets[key] == nil {
+		m.zsets[key] = make(map[string]float64)
+	}
+	m.zsets[key][member] += delta
+	if !expire.IsZero() {
+		m.expires[key] = expire
+	}
+}
+
+// expireKey 删除已过期的有序集合或哈希，需持有 mu
+func (m *MemoryStore) expireKey(key string, now time.Time) bool {
+	expire, ok := m.expires[key]
+	if !ok || now.Before(expire) {
+		return false
+	}
+	delete(m.zsets, key)
+	delete(m.hashes, key)
+	delete(m.expires, key)
+	return true
+}
+
+// ZRevRange 按分数从高到低获取有序集合前 n 个成员
+func (m *MemoryStore) ZRevRange(ctx context.Context, key string, n int) ([]RankItem, error) {
+	m.mu.Lock()
+	m.expireKey(key, time.Now())
+	items := make([]RankItem, 0, len(m.zsets[key]))
+	for k, v := range m.zsets[key] {
+		items = append(items, RankItem{Member: k, Score: v})
+	}
+	m.mu.Unlock()
+	sortRank(items)
+	if n > 0 && n < len(items) {
+		items = items[:n]
+	}
+	return items, nil
+}
+
+// ZScore 获取有序集合成员的分数
+func (m *MemoryStore) ZScore(ctx context.Context, key string, member string) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireKey(key, time.Now())
+	score, ok := m.zsets[key][member]
+	return score, ok, nil
+}
+
+// ZRem 删除有序集合成员
+func (m *MemoryStore) ZRem(ctx context.Context, key string, member string) error {
+	m.mu.Lock()
+	delete(m.zsets[key], member)
+	m.mu.Unlock()
+	return nil
+}
+
+// AddHistory 记录房间历史消息
+func (m *MemoryStore) AddHistory(ctx context.Context, room string, msg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history := append(m.history[room], msg)
+	if m.historyLimit > 0 && len(history) > m.historyLimit {
+		history = history[len(history)-m.historyLimit:]
+	}
+	m.history[room] = history
+	return nil
+}
+
+// History 获取房间最近 n 条历史消息
+func (m *MemoryStore) History(ctx context.Context, room string, n int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history := m.history[room]
+	if n < len(history) {
+		history = history[len(history)-n:]
+	}
+	return append([]string(nil), history...), nil
+}
+
+// GetHistory 按消息编号获取房间的历史消息
+func (m *MemoryStore) GetHistory(ctx context.Context, room string, id string) (string, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, msg := range m.history[room] {
+		if historyID(msg) == id {
+			return msg, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// SetHistory 按消息编号替换房间的历史消息
+func (m *MemoryStore) SetHistory(ctx context.Context, room string, id string, msg string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, old := range m.history[room] {
+		if historyID(old) == id {
+			m.history[room][i] = msg
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HashSet 设置哈希字段
+func (m *MemoryStore) HashSet(ctx context.Context, key string, field string, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireKey(key, time.Now())
+	if m.hashes[key] == nil {
+		m.hashes[key] = make(map[string]string)
+	}
+	m.hashes[key][field] = value
+	return nil
+}
+
+// HashSetNX 哈希字段不存在时设置，已存在时返回 false
+func (m *MemoryStore) HashSetNX(ctx context.Context, key string, field string, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireKey(key, time.Now())
+	if _, ok := m.hashes[key][field]; ok {
+		return false, nil
+	}
+	if m.hashes[key] == nil {
+		m.hashes[key] = make(map[string]string)
+	}
+	m.hashes[key][field] = value
+	return true, nil
+}
+
+// HashDel 删除哈希字段
+func (m *MemoryStore) HashDel(ctx context.Context, key string, field string) error {
+	m.mu.Lock()
+	delete(m.hashes[key], field)
+	m.mu.Unlock()
+	return nil
+}
+
+// HashGet 获取哈希字段
+func (m *MemoryStore) HashGet(ctx context.Context, key string, field string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireKey(key, time.Now())
+	value, ok := m.hashes[key][field]
+	return value, ok, nil
+}
+
+// HashGetAll 获取哈希所有字段
+func (m *MemoryStore) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireKey(key, time.Now())
+	values := make(map[string]string, len(m.hashes[key]))
+	for k, v := range m.hashes[key] {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// HashIncr 为哈希字段加上整数增量并返回新值
+func (m *MemoryStore) HashIncr(ctx context.Context, key string, field string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	return m.hincr(key, field, delta, expire)
+}
+
+// hincr 为哈希字段加上整数增量，expire 非零时更新过期时间，需持有 mu
+func (m *MemoryStore) hincr(key string, field string, delta int64, expire time.Time) (int64, error) {
+	m.expireKey(key, time.Now())
+	if m.hashes[key] == nil {
+		m.hashes[key] = make(map[string]string)
+	}
+	var value int64
+	if old, ok := m.hashes[key][field]; ok {
+		v, err := strconv.ParseInt(old, 10, 64)
+		if err != nil {
+			return 0, errors.New("哈希字段不是整数: " + field)
+		}
+		value = v
+	}
+	value += delta
+	m.hashes[key][field] = strconv.FormatInt(value, 10)
+	if !expire.IsZero() {
+		m.expires[key] = expire
+	}
+	return value, nil
+}
+
+// sortRank 按分数从高到低排序，分数相同按昵称排序
+func sortRank(items []RankItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Member < items[j].Member
+	})
+}
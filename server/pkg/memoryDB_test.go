@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testMessage 编码一条测试用的聊天消息
+func testMessage(t *testing.T, frame proto.Frame) string {
+	t.Helper()
+	if frame.Type == "" {
+		frame.Type = proto.TypeMsg
+	}
+	if frame.Room == "" {
+		frame.Room = "lobby"
+	}
+	msg, err := frame.Marshal()
+	if err != nil {
+		t.Fatalf("编码消息失败: %v", err)
+	}
+	return msg
+}
+
+// historyIDs 历史消息的消息编号
+func historyIDs(history []string) []string {
+	ids := make([]string, 0, len(history))
+	for _, msg := range history {
+		ids = append(ids, historyID(msg))
+	}
+	return ids
+}
+
+func TestMemoryStoreHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		add   int
+		n     int
+		want  []string
+	}{
+		{name: "不限制条数", limit: 0, add: 3, n: 10, want: []string{"1", "2", "3"}},
+		{name: "只取最近 n 条", limit: 0, add: 5, n: 2, want: []string{"4", "5"}},
+		{name: "超过上限时淘汰最早的消息", limit: 3, add: 5, n: 10, want: []string{"3", "4", "5"}},
+		{name: "没有消息", limit: 3, add: 0, n: 10, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config object.Config
+			config.App.HistoryLimit = tt.limit
+			m := NewMemoryStore(config)
+			ctx := context.Background()
+			for i := 1; i <= tt.add; i++ {
+				if err := m.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: strconv.Itoa(i)})); err != nil {
+					t.Fatal(err)
+				}
+			}
+			history, err := m.History(ctx, "lobby", tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := historyIDs(history); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("History() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreSetHistory(t *testing.T) {
+	var config object.Config
+	config.App.HistoryLimit = 2
+	m := NewMemoryStore(config)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		_ = m.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: strconv.Itoa(i), Text: "old"}))
+	}
+	tests := []struct {
+		name string
+		room string
+		id   string
+		ok   bool
+	}{
+		{name: "替换存在的消息", room: "lobby", id: "3", ok: true},
+		{name: "已被淘汰的消息", room: "lobby", id: "1", ok: false},
+		{name: "其他房间", room: "other", id: "3", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := testMessage(t, proto.Frame{ID: tt.id, Text: "new"})
+			ok, err := m.SetHistory(ctx, tt.room, tt.id, msg)
+			if err != nil || ok != tt.ok {
+				t.Fatalf("SetHistory() = %v, %v, want %v", ok, err, tt.ok)
+			}
+			got, found, err := m.GetHistory(ctx, tt.room, tt.id)
+			if err != nil || found != tt.ok {
+				t.Fatalf("GetHistory() found = %v, %v, want %v", found, err, tt.ok)
+			}
+			if tt.ok && got != msg {
+				t.Errorf("GetHistory() = %s, want %s", got, msg)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreHash(t *testing.T) {
+	m := NewMemoryStore(object.Config{})
+	ctx := context.Background()
+	if ok, err := m.HashSetNX(ctx, "accounts", "alice", "v1"); !ok || err != nil {
+		t.Fatalf("HashSetNX() = %v, %v, want true", ok, err)
+	}
+	if ok, _ := m.HashSetNX(ctx, "accounts", "alice", "v2"); ok {
+		t.Fatal("HashSetNX() 覆盖了已存在的字段")
+	}
+	tests := []struct {
+		field string
+		value string
+		ok    bool
+	}{
+		{field: "alice", value: "v1", ok: true},
+		{field: "bob", value: "", ok: false},
+	}
+	for _, tt := range tests {
+		value, ok, err := m.HashGet(ctx, "accounts", tt.field)
+		if err != nil || value != tt.value || ok != tt.ok {
+			t.Errorf("HashGet(%q) = %q, %v, %v, want %q, %v", tt.field, value, ok, err, tt.value, tt.ok)
+		}
+	}
+	_ = m.HashDel(ctx, "accounts", "alice")
+	if _, ok, _ := m.HashGet(ctx, "accounts", "alice"); ok {
+		t.Error("HashDel() 后字段仍然存在")
+	}
+
+	for i, want := range []int64{2, 5, 4} {
+		delta := []int64{2, 3, -1}[i]
+		got, err := m.HashIncr(ctx, "counter", "n", delta, time.Hour)
+		if err != nil || got != want {
+			t.Errorf("HashIncr(%d) = %d, %v, want %d", delta, got, err, want)
+		}
+	}
+	// 过期后重新计数
+	m.mu.Lock()
+	m.expires["counter"] = time.Now().Add(-time.Second)
+	m.mu.Unlock()
+	if got, _ := m.HashIncr(ctx, "counter", "n", 1, time.Hour); got != 1 {
+		t.Errorf("过期后 HashIncr() = %d, want 1", got)
+	}
+}
+
+func TestMemoryStoreRank(t *testing.T) {
+	m := NewMemoryStore(object.Config{})
+	ctx := context.Background()
+	for _, item := range []RankItem{{"alice", 3}, {"bob", 5}, {"carol", 3}, {"alice", 1}} {
+		_ = m.ZIncrBy(ctx, "rank", item.Member, item.Score, 0)
+	}
+	tests := []struct {
+		n    int
+		want []RankItem
+	}{
+		{n: 2, want: []RankItem{{"bob", 5}, {"alice", 4}}},
+		{n: 10, want: []RankItem{{"bob", 5}, {"alice", 4}, {"carol", 3}}},
+	}
+	for _, tt := range tests {
+		got, err := m.ZRevRange(ctx, "rank", tt.n)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ZRevRange(%d) = %v, %v, want %v", tt.n, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/proto"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// keyMessages 消息编号哈希，seq 字段为最近分配的消息编号
+const keyMessages = "messages"
+
+// quoteLength 父消息预览的最大字符数
+const quoteLength = 30
+
+// NextMessageID 分配工作区内递增的消息编号
+func NextMessageID(ctx context.Context, s Store) (string, error) {
+	id, err := s.HashIncr(ctx, keyMessages, "seq", 1, 0)
+	if err != nil {
+		return "", errors.New("分配消息编号失败: " + err.Error())
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// GetMessage 按消息编号获取房间历史中的消息，不存在或已被淘汰时返回 nil
+func GetMessage(ctx context.Context, s Store, room string, id string) (*proto.Frame, error) {
+	if id == "" {
+		return nil, nil
+	}
+	msg, ok, err := s.GetHistory(ctx, room, id)
+	if err != nil {
+		return nil, errors.New("获取历史消息失败: " + err.Error())
+	}
+	if !ok {
+		return nil, nil
+	}
+	frame, err := proto.ParseFrame(msg)
+	if err != nil {
+		return nil, errors.New("解析历史消息失败: " + err.Error())
+	}
+	return &frame, nil
+}
+
+// SaveMessage 按消息编号更新房间历史中的消息，如编辑后的内容与删除标记
+// 历史中不保存父消息预览，避免父消息编辑或删除后仍留有原内容
+func SaveMessage(ctx context.Context, s Store, frame proto.Frame) error {
+	frame.Quote = ""
+	msg, err := frame.Marshal()
+	if err != nil {
+		return errors.New("编码消息失败: " + err.Error())
+	}
+	ok, err := s.SetHistory(ctx, frame.Room, frame.ID, msg)
+	if err != nil {
+		return errors.New("更新历史消息失败: " + err.Error())
+	}
+	if !ok {
+		return errors.New("消息 #" + frame.ID + " 不存在或已过期")
+	}
+	return nil
+}
+
+// QuotePreview 回复时附带的父消息预览，如 alice: hello，过长时截断
+func QuotePreview(parent proto.Frame) string {
+	if parent.Deleted {
+		return parent.From + ": " + proto.DeletedText
+	}
+	text := strings.Join(strings.Fields(parent.Text), " ")
+	if utf8.RuneCountInString(text) > quoteLength {
+		text = string([]rune(text)[:quoteLength]) + "…"
+	}
+	return parent.From + ": " + text
+}
+
+// FillQuotes 按父消息的当前内容填写回复的预览，父消息已被淘汰时预览为空
+// 优先在同一批历史消息中查找父消息，找不到时再从存储中读取
+func FillQuotes(ctx context.Context, s Store, room string, frames []proto.Frame) {
+	index := make(map[string]int, len(frames))
+	for i, frame := range frames {
+		if frame.ID != "" {
+			index[frame.ID] = i
+		}
+	}
+	for i := range frames {
+		if frames[i].Parent == "" {
+			continue
+		}
+		frames[i].Quote = ""
+		if j, ok := index[frames[i].Parent]; ok {
+			frames[i].Quote = QuotePreview(frames[j])
+			continue
+		}
+		parent, err := GetMessage(ctx, s, room, frames[i].Parent)
+		if err == nil && parent != nil {
+			frames[i].Quote = QuotePreview(*parent)
+		}
+	}
+}
+
+// ShowThread 查看消息所在的讨论：从最早的父消息开始，按回复关系缩进显示所有回复
+// 只在房间最近 n 条历史消息中查找，更早的消息已被淘汰
+func ShowThread(ctx context.Context, s Store, room string, id string, n int) (string, error) {
+	history, err := s.History(ctx, room, n)
+	if err != nil {
+		return "", errors.New("获取历史消息失败: " + err.Error())
+	}
+	frames := make(map[string]proto.Frame, len(history))
+	children := make(map[string][]string)
+	for _, h := range history {
+		frame, err := proto.ParseFrame(h)
+		if err != nil || frame.ID == "" {
+			continue
+		}
+		frames[frame.ID] = frame
+		if frame.Parent != "" {
+			children[frame.Parent] = append(children[frame.Parent], frame.ID)
+		}
+	}
+	if _, ok := frames[id]; !ok {
+		return "", errors.New("当前房间没有消息 #" + id + "，消息可能已过期")
+	}
+	// 父消息在回复前已存在，编号总是更小，回复关系不会成环
+	root := id
+	for {
+		if _, ok := frames[frames[root].Parent]; !ok {
+			break
+		}
+		root = frames[root].Parent
+	}
+
+	var lines []string
+	var walk func(id string, depth int)
+	walk = func(id string, depth int) {
+		frame := frames[id]
+		frame.Parent = ""
+		lines = append(lines, strings.Repeat("  ", depth)+frame.String())
+		for _, child := range children[id] {
+			walk(child, depth+1)
+		}
+	}
+	walk(root, 0)
+	msg := fmt.Sprintf("消息 #%s 所在的讨论，共 %d 条:", id, len(lines))
+	if frames[root].Parent != "" {
+		msg += "\n(更早的消息已过期)"
+	}
+	return msg + "\n" + strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"strings"
+	"testing"
+)
+
+// threadStore 讨论测试用的房间历史：#2、#5 回复 #1，#3 回复 #2，#4 与讨论无关
+func threadStore(t *testing.T, limit int) Store {
+	t.Helper()
+	var config object.Config
+	config.App.HistoryLimit = limit
+	s := NewMemoryStore(config)
+	frames := []proto.Frame{
+		{ID: "1", From: "alice", Text: "root"},
+		{ID: "2", From: "bob", Text: "reply-2", Parent: "1"},
+		{ID: "3", From: "carol", Text: "reply-3", Parent: "2"},
+		{ID: "4", From: "dave", Text: "other"},
+		{ID: "5", From: "bob", Text: "reply-5", Parent: "1"},
+	}
+	for _, frame := range frames {
+		_ = s.AddHistory(context.Background(), "lobby", testMessage(t, frame))
+	}
+	return s
+}
+
+func TestShowThread(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		id      string
+		n       int
+		lines   []string // 每行包含的文本，按顺序
+		expired bool
+		err     string
+	}{
+		{
+			name:  "从最早的父消息开始",
+			limit: 10, id: "3", n: 10,
+			lines: []string{"#1 alice", "  #2 bob", "    #3 carol", "  #5 bob"},
+		},
+		{
+			name:  "根消息",
+			limit: 10, id: "1", n: 10,
+			lines: []string{"#1 alice", "  #2 bob", "    #3 carol", "  #5 bob"},
+		},
+		{
+			name:  "没有回复的消息",
+			limit: 10, id: "4", n: 10,
+			lines: []string{"#4 dave"},
+		},
+		{
+			name:  "父消息已被淘汰",
+			limit: 4, id: "3", n: 10,
+			lines:   []string{"#2 bob", "  #3 carol"},
+			expired: true,
+		},
+		{
+			name:  "只在最近 n 条中查找",
+			limit: 10, id: "1", n: 3,
+			err: "当前房间没有消息 #1",
+		},
+		{
+			name:  "消息不存在",
+			limit: 10, id: "9", n: 10,
+			err: "当前房间没有消息 #9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ShowThread(context.Background(), threadStore(t, tt.limit), "lobby", tt.id, tt.n)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ShowThread() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(msg, "\n")
+			head := "消息 #" + tt.id + " 所在的讨论"
+			if !strings.HasPrefix(lines[0], head) {
+				t.Errorf("标题 = %q, want %q", lines[0], head)
+			}
+			lines = lines[1:]
+			if hasExpired := len(lines) > 0 && lines[0] == "(更早的消息已过期)"; hasExpired != tt.expired {
+				t.Errorf("过期提示 = %v, want %v", hasExpired, tt.expired)
+			} else if hasExpired {
+				lines = lines[1:]
+			}
+			if len(lines) != len(tt.lines) {
+				t.Fatalf("ShowThread() =\n%s\nwant %d 条", msg, len(tt.lines))
+			}
+			for i, want := range tt.lines {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("第 %d 行 = %q, want 前缀 %q", i+1, lines[i], want)
+				}
+				// 讨论中按缩进显示回复关系，不再显示父消息预览
+				if strings.Contains(lines[i], "┌") {
+					t.Errorf("第 %d 行显示了父消息预览: %q", i+1, lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuotePreview(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent proto.Frame
+		want   string
+	}{
+		{name: "短消息", parent: proto.Frame{From: "alice", Text: "hello"}, want: "alice: hello"},
+		{name: "合并空白", parent: proto.Frame{From: "alice", Text: " a\n\tb  c "}, want: "alice: a b c"},
+		{name: "按字符截断", parent: proto.Frame{From: "alice", Text: strings.Repeat("好", quoteLength+5)}, want: "alice: " + strings.Repeat("好", quoteLength) + "…"},
+		{name: "已删除", parent: proto.Frame{From: "alice", Text: "secret", Deleted: true}, want: "alice: " + proto.DeletedText},
+	}
+	for _, tt := range tests {
+		if got := QuotePreview(tt.parent); got != tt.want {
+			t.Errorf("%s: QuotePreview() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFillQuotes(t *testing.T) {
+	ctx := context.Background()
+	s := threadStore(t, 10)
+	_ = SaveMessage(ctx, s, proto.Frame{Type: proto.TypeMsg, ID: "2", From: "bob", Room: "lobby", Parent: "1", Deleted: true})
+	_ = SaveMessage(ctx, s, proto.Frame{Type: proto.TypeMsg, ID: "1", From: "alice", Room: "lobby", Text: "edited", Edited: 1})
+	frames := []proto.Frame{
+		{ID: "3", Parent: "2", Quote: "bob: reply-2"}, // 父消息已删除，旧预览不再显示
+		{ID: "5", Parent: "1"},                        // 父消息不在这一批中，从存储中读取
+		{ID: "6", Parent: "7"},
+		{ID: "7", From: "erin", Text: "in batch"},
+		{ID: "8", Parent: "99", Quote: "stale"}, // 父消息已被淘汰
+	}
+	FillQuotes(ctx, s, "lobby", frames)
+	want := []string{"bob: " + proto.DeletedText, "alice: edited", "erin: in batch", "", ""}
+	for i, frame := range frames {
+		if frame.Quote != want[i] {
+			t.Errorf("#%s Quote = %q, want %q", frame.ID, frame.Quote, want[i])
+		}
+	}
+}
+
+func TestShowHistoryQuote(t *testing.T) {
+	ctx := context.Background()
+	s := threadStore(t, 10)
+	// 历史中不保存父消息预览，编辑父消息后显示新内容
+	_ = SaveMessage(ctx, s, proto.Frame{Type: proto.TypeMsg, ID: "3", From: "carol", Room: "lobby", Text: "reply-3", Parent: "2", Quote: "bob: reply-2"})
+	msg, _, _ := s.GetHistory(ctx, "lobby", "3")
+	if strings.Contains(msg, "quote") {
+		t.Errorf("SaveMessage() 保存了父消息预览: %s", msg)
+	}
+	_ = SaveMessage(ctx, s, proto.Frame{Type: proto.TypeMsg, ID: "2", From: "bob", Room: "lobby", Text: "changed", Parent: "1", Edited: 1})
+	out, err := ShowHistory(ctx, s, "lobby", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "┌ #2 bob: changed") || strings.Contains(out, "┌ #2 bob: reply-2") {
+		t.Errorf("ShowHistory() 未按父消息的当前内容显示预览:\n%s", out)
+	}
+}
@@ -0,0 +1,286 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/proto"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageContext 中间件处理的消息上下文
+type MessageContext struct {
+	Ctx        context.Context
+	Workspace  *Workspace
+	Conn       net.Conn          // 发送者的连接
+	Frame      proto.Frame       // 消息，From 为发送者，Room 为所在房间，中间件可以修改
+	Registered bool              // 发送者是否为注册用户
+	Bot        bool              // 发送者是否为机器人，包括机器人账号、插件与入站 Webhook，机器人不参与排行榜
+	Time       time.Time         // 消息出队时间
+	Meta       map[string]string // 中间件之间传递的附加信息
+
+	replies   []proto.Frame // 只发送给发送者的消息
+	emitted   []proto.Frame // 额外广播的消息
+	dropped   bool
+	reason    string
+	delivered bool
+}
+
+// NewMessageContext 创建消息上下文
+func NewMessageContext(ctx context.Context, ws *Workspace, conn net.Conn, frame proto.Frame, registered bool) *MessageContext {
+	return &MessageContext{
+		Ctx:        ctx,
+		Workspace:  ws,
+		Conn:       conn,
+		Frame:      frame,
+		Registered: registered,
+		Time:       time.Now(),
+		Meta:       make(map[string]string),
+	}
+}
+
+// Drop 丢弃消息，之后的中间件不再执行
+func (m *MessageContext) Drop(reason string) {
+	if !m.dropped {
+		m.dropped, m.reason = true, reason
+	}
+}
+
+// Dropped 消息是否被丢弃
+func (m *MessageContext) Dropped() bool {
+	return m.dropped
+}
+
+// DropReason 消息被丢弃的原因
+func (m *MessageContext) DropReason() string {
+	return m.reason
+}
+
+// Delivered 消息是否已经过全部中间件并完成投递
+func (m *MessageContext) Delivered() bool {
+	return m.delivered
+}
+
+// Reply 向发送者回复一条系统消息
+func (m *MessageContext) Reply(text string) {
+	m.replies = append(m.replies, proto.Frame{Type: proto.TypeSys, Text: text})
+}
+
+// Replies 需要发送给发送者的消息
+func (m *MessageContext) Replies() []proto.Frame {
+	return m.replies
+}
+
+// Emit 在工作区额外广播一条消息
+func (m *MessageContext) Emit(frame proto.Frame) {
+	m.emitted = append(m.emitted, frame)
+}
+
+// Emitted 需要额外广播的消息
+func (m *MessageContext) Emitted() []proto.Frame {
+	return m.emitted
+}
+
+// Middleware 消息中间件，调用 next 交给后续中间件处理，不调用时消息被丢弃
+// next 返回后可以根据 Delivered 做投递后的处理
+type Middleware interface {
+	Name() string
+	Handle(mc *MessageContext, next func())
+}
+
+// middlewareFunc 函数形式的中间件
+type middlewareFunc struct {
+	name   string
+	handle func(mc *MessageContext, next func())
+}
+
+func (m middlewareFunc) Name() string {
+	return m.name
+}
+
+func (m middlewareFunc) Handle(mc *MessageContext, next func()) {
+	m.handle(mc, next)
+}
+
+// MiddlewareFunc 将函数包装为中间件
+func MiddlewareFunc(name string, handle func(mc *MessageContext, next func())) Middleware {
+	return middlewareFunc{name: name, handle: handle}
+}
+
+// MessageChain 按注册顺序执行的中间件链，全部中间件放行后交给 final 投递
+type MessageChain struct {
+	mu    sync.RWMutex
+	items []Middleware
+	final func(mc *MessageContext)
+}
+
+// NewMessageChain 创建中间件链
+func NewMessageChain(final func(mc *MessageContext)) *MessageChain {
+	return &MessageChain{final: final}
+}
+
+// Use 注册中间件，先注册的先执行
+func (c *MessageChain) Use(m Middleware) {
+	c.mu.Lock()
+	c.items = append(c.items, m)
+	c.mu.Unlock()
+}
+
+// Names 已注册的中间件名称，按执行顺序排列
+func (c *MessageChain) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.items))
+	for _, m := range c.items {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
+// Run 依次执行中间件，中间件未调用 next 且未说明原因时以中间件名称作为丢弃原因
+func (c *MessageChain) Run(mc *MessageContext) {
+	c.mu.RLock()
+	items := c.items
+	c.mu.RUnlock()
+	var call func(i int)
+	call = func(i int) {
+		if mc.dropped {
+			return
+		}
+		if i == len(items) {
+			if c.final != nil {
+				c.final(mc)
+			}
+			mc.delivered = !mc.dropped
+			return
+		}
+		called := false
+		items[i].Handle(mc, func() {
+			if !called {
+				called = true
+				call(i + 1)
+			}
+		})
+		if !called {
+			mc.Drop(items[i].Name())
+		}
+	}
+	call(0)
+}
+
+// LoggingMiddleware 记录每条消息的处理结果
+func LoggingMiddleware(logger *logrus.Logger) Middleware {
+	return MiddlewareFunc("logging", func(mc *MessageContext, next func()) {
+		next()
+		entry := logger.WithFields(logrus.Fields{
+			"workspace": mc.Workspace.Name,
+			"room":      mc.Frame.Room,
+			"from":      mc.Frame.From,
+			"cost":      time.Since(mc.Time).String(),
+		})
+		if mc.Dropped() {
+			entry.Info("message dropped, reason: ", mc.DropReason())
+			return
+		}
+		entry.Debug("message delivered")
+	})
+}
+
+// RankMiddleware 消息投递后为发送者加分，机器人不加分
+func RankMiddleware(policy *ScorePolicy, logger *logrus.Logger) Middleware {
+	return MiddlewareFunc("rank", func(mc *MessageContext, next func()) {
+		next()
+		if !mc.Delivered() || mc.Bot {
+			return
+		}
+		score := policy.Message(mc.Workspace.Name, mc.Frame.From, mc.Frame.Text, mc.Time)
+		err := AddActivity(mc.Ctx, mc.Workspace.Store, policy, mc.Frame.Room, mc.Frame.From, score)
+		if err != nil {
+			logger.Error("add score failed,err:", err.Error())
+		}
+	})
+}
+
+// ReplyMiddleware 校验回复的父消息在同一房间且未被删除，并附上父消息的预览
+// 消息投递后父消息的作者按回复计分，回复自己、机器人发送或收到的回复不计分
+func ReplyMiddleware(policy *ScorePolicy, logger *logrus.Logger) Middleware {
+	return MiddlewareFunc("reply", func(mc *MessageContext, next func()) {
+		if mc.Frame.Parent == "" {
+			next()
+			return
+		}
+		id := strings.TrimPrefix(mc.Frame.Parent, "#")
+		parent, err := GetMessage(mc.Ctx, mc.Workspace.Store, mc.Frame.Room, id)
+		if err != nil {
+			logger.Error(err.Error())
+			mc.Reply("回复失败，请稍后重试")
+			mc.Drop("reply: " + err.Error())
+			return
+		}
+		if parent == nil {
+			mc.Reply("当前房间没有消息 #" + id + "，消息可能已过期")
+			mc.Drop("reply: parent not found")
+			return
+		}
+		if parent.Deleted {
+			mc.Reply("消息 #" + id + " 已被删除，不能回复")
+			mc.Drop("reply: parent deleted")
+			return
+		}
+		mc.Frame.Parent, mc.Frame.Quote = parent.ID, QuotePreview(*parent)
+		next()
+		if !mc.Delivered() || mc.Bot || parent.Bot || parent.From == mc.Frame.From {
+			return
+		}
+		err = AddActivity(mc.Ctx, mc.Workspace.Store, policy, mc.Frame.Room, parent.From, policy.Reply())
+		if err != nil {
+			logger.Error("add score failed,err:", err.Error())
+		}
+	})
+}
+
+// FilterMiddleware 按内容过滤规则拒绝、替换或标记消息
+// 拒绝与替换视为消息被审核处理，发送者扣分(机器人除外)；标记的消息以 TypeFlag 广播给版主
+func FilterMiddleware(filter *ContentFilter, policy *ScorePolicy, logger *logrus.Logger) Middleware {
+	return MiddlewareFunc("filter", func(mc *MessageContext, next func()) {
+		result := filter.Check(mc.Frame.Text)
+		if !result.Blocked && !result.Masked && !result.Flagged {
+			next()
+			return
+		}
+		frame := mc.Frame
+		rules := strings.Join(result.Rules, ", ")
+		entry := logger.WithFields(logrus.Fields{
+			"workspace": mc.Workspace.Name,
+			"room":      frame.Room,
+			"from":      frame.From,
+			"rules":     rules,
+		})
+		if (result.Blocked || result.Masked) && !mc.Bot {
+			err := AddActivity(mc.Ctx, mc.Workspace.Store, policy, frame.Room, frame.From, policy.Moderated())
+			if err != nil {
+				logger.Error("add score failed,err:", err.Error())
+			}
+		}
+		if result.Blocked {
+			entry.Warn("message blocked")
+			mc.Reply("消息包含被禁止的内容，未发送")
+			mc.Drop("filter: " + rules)
+			return
+		}
+		if result.Masked {
+			entry.Warn("message masked")
+			mc.Frame.Text = result.Text
+		}
+		if result.Flagged {
+			entry.Warn("message flagged")
+			mc.Emit(proto.Frame{
+				Type: proto.TypeFlag,
+				Text: "[审核] " + frame.From + " 在房间 " + frame.Room + " 的消息命中规则 " + rules + ": " + result.Text,
+			})
+		}
+		next()
+	})
+}
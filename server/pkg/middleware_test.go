@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"github.com/sirupsen/logrus"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// traceMiddleware 记录执行顺序的中间件，reason 不为空时先丢弃消息，drop 为真时不调用 next
+func traceMiddleware(name string, trace *[]string, drop bool, reason string) Middleware {
+	return MiddlewareFunc(name, func(mc *MessageContext, next func()) {
+		*trace = append(*trace, name)
+		if reason != "" {
+			mc.Drop(reason)
+		}
+		if drop {
+			return
+		}
+		next()
+		*trace = append(*trace, name+" done")
+	})
+}
+
+func TestMessageChain(t *testing.T) {
+	type step struct {
+		name   string
+		drop   bool   // 不调用 next
+		reason string // 调用 Drop 的原因
+	}
+	tests := []struct {
+		name      string
+		steps     []step
+		trace     []string
+		delivered bool
+		reason    string
+	}{
+		{
+			name:      "全部放行",
+			steps:     []step{{name: "a"}, {name: "b"}, {name: "c"}},
+			trace:     []string{"a", "b", "c", "final", "c done", "b done", "a done"},
+			delivered: true,
+		},
+		{
+			name:   "未调用 next 时以名称作为原因",
+			steps:  []step{{name: "a"}, {name: "b", drop: true}, {name: "c"}},
+			trace:  []string{"a", "b", "a done"},
+			reason: "b",
+		},
+		{
+			name:   "说明原因后丢弃",
+			steps:  []step{{name: "a", drop: true, reason: "too long"}, {name: "b"}},
+			trace:  []string{"a"},
+			reason: "too long",
+		},
+		{
+			name:   "丢弃后调用 next 不再执行后续中间件",
+			steps:  []step{{name: "a", reason: "muted"}, {name: "b"}},
+			trace:  []string{"a", "a done"},
+			reason: "muted",
+		},
+		{
+			name:      "没有中间件",
+			trace:     []string{"final"},
+			delivered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trace []string
+			chain := NewMessageChain(func(mc *MessageContext) {
+				trace = append(trace, "final")
+			})
+			var names []string
+			for _, s := range tt.steps {
+				chain.Use(traceMiddleware(s.name, &trace, s.drop, s.reason))
+				names = append(names, s.name)
+			}
+			if got := chain.Names(); len(names) > 0 && !reflect.DeepEqual(got, names) {
+				t.Errorf("Names() = %v, want %v", got, names)
+			}
+			mc := NewMessageContext(context.Background(), &Workspace{Name: "default"}, nil, proto.Frame{}, false)
+			chain.Run(mc)
+			if !reflect.DeepEqual(trace, tt.trace) {
+				t.Errorf("执行顺序 = %v, want %v", trace, tt.trace)
+			}
+			if mc.Delivered() != tt.delivered || mc.Dropped() == tt.delivered || mc.DropReason() != tt.reason {
+				t.Errorf("Delivered() = %v, DropReason() = %q, want %v, %q", mc.Delivered(), mc.DropReason(), tt.delivered, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMessageChainNextOnce(t *testing.T) {
+	finals := 0
+	chain := NewMessageChain(func(mc *MessageContext) { finals++ })
+	chain.Use(MiddlewareFunc("twice", func(mc *MessageContext, next func()) {
+		next()
+		next()
+	}))
+	chain.Run(NewMessageContext(context.Background(), &Workspace{}, nil, proto.Frame{}, false))
+	if finals != 1 {
+		t.Errorf("多次调用 next 后投递了 %d 次, want 1", finals)
+	}
+}
+
+func TestMessageChainFinalDrop(t *testing.T) {
+	chain := NewMessageChain(func(mc *MessageContext) { mc.Drop("encode failed") })
+	mc := NewMessageContext(context.Background(), &Workspace{}, nil, proto.Frame{}, false)
+	chain.Run(mc)
+	if mc.Delivered() || mc.DropReason() != "encode failed" {
+		t.Errorf("Delivered() = %v, DropReason() = %q", mc.Delivered(), mc.DropReason())
+	}
+}
+
+func TestBuiltinMiddleware(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	policy, err := NewScorePolicy(object.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := parseFilterRules([]byte("block forbidden\nmask darn\nflag scam\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ws := &Workspace{Name: "default", Store: NewMemoryStore(object.Config{})}
+	_ = ws.Store.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "1", From: "alice", Text: "hello"}))
+	_ = ws.Store.AddHistory(ctx, "lobby", testMessage(t, proto.Frame{ID: "2", From: "alice", Deleted: true}))
+
+	var delivered []proto.Frame
+	chain := NewMessageChain(func(mc *MessageContext) { delivered = append(delivered, mc.Frame) })
+	chain.Use(FilterMiddleware(&ContentFilter{rules: rules}, policy, logger))
+	chain.Use(ReplyMiddleware(policy, logger))
+
+	tests := []struct {
+		name    string
+		frame   proto.Frame
+		reason  string // 丢弃原因，为空时应投递
+		text    string // 投递的内容
+		quote   string
+		replies int
+		emitted int
+	}{
+		{name: "普通消息", frame: proto.Frame{Text: "hi"}, text: "hi"},
+		{name: "拒绝", frame: proto.Frame{Text: "forbidden", Parent: "1"}, reason: "filter: forbidden", replies: 1},
+		{name: "替换", frame: proto.Frame{Text: "darn"}, text: "****"},
+		{name: "标记", frame: proto.Frame{Text: "scam"}, text: "scam", emitted: 1},
+		{name: "回复", frame: proto.Frame{Text: "re", Parent: "#1"}, text: "re", quote: "alice: hello"},
+		{name: "父消息不存在", frame: proto.Frame{Text: "re", Parent: "9"}, reason: "reply: parent not found", replies: 1},
+		{name: "父消息已删除", frame: proto.Frame{Text: "re", Parent: "2"}, reason: "reply: parent deleted", replies: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delivered = nil
+			frame := tt.frame
+			frame.Type, frame.From, frame.Room = proto.TypeMsg, "bob", "lobby"
+			mc := NewMessageContext(ctx, ws, nil, frame, false)
+			chain.Run(mc)
+			if mc.DropReason() != tt.reason {
+				t.Fatalf("DropReason() = %q, want %q", mc.DropReason(), tt.reason)
+			}
+			if len(mc.Replies()) != tt.replies || len(mc.Emitted()) != tt.emitted {
+				t.Errorf("Replies() = %d, Emitted() = %d, want %d, %d", len(mc.Replies()), len(mc.Emitted()), tt.replies, tt.emitted)
+			}
+			if tt.reason != "" {
+				if len(delivered) != 0 {
+					t.Errorf("丢弃的消息被投递: %v", delivered)
+				}
+				return
+			}
+			if len(delivered) != 1 || delivered[0].Text != tt.text || delivered[0].Quote != tt.quote {
+				t.Errorf("投递 = %+v, want text %q quote %q", delivered, tt.text, tt.quote)
+			}
+		})
+	}
+}
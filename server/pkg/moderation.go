@@ -0,0 +1,275 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 处罚记录
+const (
+	keyBans        = "bans"         // 工作区封禁 目标->封禁记录，只对本工作区生效
+	keyNetworkBans = "network_bans" // 全网封禁 IP 或网段->封禁记录，保存在默认工作区的存储中，握手前检查
+	keyMutes       = "mutes"        // 禁言 昵称->解除时间(Unix 秒)，0 表示永久
+)
+
+// Ban 封禁记录，目标可以是昵称、IP 或 CIDR 网段
+type Ban struct {
+	Target  string `json:"target"`
+	Reason  string `json:"reason,omitempty"`
+	By      string `json:"by"`      // 操作者
+	Created int64  `json:"created"` // 封禁时间(Unix 秒)
+	Until   int64  `json:"until"`   // 解封时间(Unix 秒)，0 表示永久
+}
+
+// Expired 封禁是否已到期
+func (b *Ban) Expired(now time.Time) bool {
+	return b.Until > 0 && now.Unix() >= b.Until
+}
+
+// String 封禁的提示文本
+func (b *Ban) String() string {
+	msg := "你已被封禁"
+	if b.Reason != "" {
+		msg += "，原因: " + b.Reason
+	}
+	return msg + "，" + UntilText(b.Until)
+}
+
+// UntilText 解除时间的显示文本
+func UntilText(until int64) string {
+	if until == 0 {
+		return "永久有效"
+	}
+	return "解除时间: " + time.Unix(until, 0).Format("2006-01-02 15:04:05")
+}
+
+// ParseDuration 解析处罚时长，支持 time.ParseDuration 的格式以及天数(如 7d)，0 或 perm 表示永久
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "0" || s == "perm" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, errors.New("无效的时长: " + s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("无效的时长: " + s + "，例如 30m、2h、7d，0 或 perm 表示永久")
+	}
+	return d, nil
+}
+
+// untilUnix 按时长计算解除时间，0 表示永久
+func untilUnix(now time.Time, d time.Duration) int64 {
+	if d == 0 {
+		return 0
+	}
+	return now.Add(d).Unix()
+}
+
+// MatchTarget 处罚目标是否匹配用户，目标为 IP 或 CIDR 网段时按地址匹配，否则按昵称匹配
+func MatchTarget(target string, nickName string, addr string) bool {
+	ip := net.ParseIP(hostOf(addr))
+	if _, network, err := net.ParseCIDR(target); err == nil {
+		return ip != nil && network.Contains(ip)
+	}
+	if targetIP := net.ParseIP(target); targetIP != nil {
+		return ip != nil && targetIP.Equal(ip)
+	}
+	return nickName != "" && target == nickName
+}
+
+// IsAddressTarget 处罚目标是否为 IP 或 CIDR 网段
+func IsAddressTarget(target string) bool {
+	if net.ParseIP(target) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(target)
+	return err == nil
+}
+
+// hostOf 去掉地址中的端口
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// AddBan 添加工作区封禁
+func AddBan(ctx context.Context, s Store, target string, by string, d time.Duration, reason string) (*Ban, error) {
+	return addBan(ctx, s, keyBans, target, by, d, reason)
+}
+
+// RemoveBan 解除工作区封禁，目标未被封禁时返回 false
+func RemoveBan(ctx context.Context, s Store, target string) (bool, error) {
+	return removeBan(ctx, s, keyBans, target)
+}
+
+// GetBan 获取目标的工作区封禁，未被封禁时返回 nil
+func GetBan(ctx context.Context, s Store, target string) (*Ban, error) {
+	value, ok, err := s.HashGet(ctx, keyBans, target)
+	if err != nil {
+		return nil, errors.New("获取封禁失败: " + err.Error())
+	}
+	if !ok {
+		return nil, nil
+	}
+	var ban Ban
+	if err = json.Unmarshal([]byte(value), &ban); err != nil {
+		return nil, errors.New("解析封禁失败: " + err.Error())
+	}
+	return &ban, nil
+}
+
+// ListBans 获取未到期的工作区封禁，顺带清理已到期的封禁
+func ListBans(ctx context.Context, s Store) ([]*Ban, error) {
+	return listBans(ctx, s, keyBans)
+}
+
+// FindBan 查找匹配用户的工作区封禁，没有封禁时返回 nil
+func FindBan(ctx context.Context, s Store, nickName string, addr string) (*Ban, error) {
+	return findBan(ctx, s, keyBans, nickName, addr)
+}
+
+// AddNetworkBan 添加全网封禁，目标只能是 IP 或网段
+func AddNetworkBan(ctx context.Context, s Store, target string, by string, d time.Duration, reason string) (*Ban, error) {
+	if !IsAddressTarget(target) {
+		return nil, errors.New("全网封禁的目标只能是 IP 或网段: " + target)
+	}
+	return addBan(ctx, s, keyNetworkBans, target, by, d, reason)
+}
+
+// RemoveNetworkBan 解除全网封禁，目标未被封禁时返回 false
+func RemoveNetworkBan(ctx context.Context, s Store, target string) (bool, error) {
+	return removeBan(ctx, s, keyNetworkBans, target)
+}
+
+// ListNetworkBans 获取未到期的全网封禁
+func ListNetworkBans(ctx context.Context, s Store) ([]*Ban, error) {
+	return listBans(ctx, s, keyNetworkBans)
+}
+
+// FindNetworkBan 查找匹配地址的全网封禁，没有封禁时返回 nil
+func FindNetworkBan(ctx context.Context, s Store, addr string) (*Ban, error) {
+	return findBan(ctx, s, keyNetworkBans, "", addr)
+}
+
+// addBan 在指定的封禁哈希中添加封禁
+func addBan(ctx context.Context, s Store, key string, target string, by string, d time.Duration, reason string) (*Ban, error) {
+	now := time.Now()
+	ban := &Ban{Target: target, Reason: reason, By: by, Created: now.Unix(), Until: untilUnix(now, d)}
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return nil, err
+	}
+	err = s.HashSet(ctx, key, target, string(data))
+	if err != nil {
+		return nil, errors.New("保存封禁失败: " + err.Error())
+	}
+	return ban, nil
+}
+
+// removeBan 从指定的封禁哈希中解除封禁
+func removeBan(ctx context.Context, s Store, key string, target string) (bool, error) {
+	_, ok, err := s.HashGet(ctx, key, target)
+	if err != nil {
+		return false, errors.New("获取封禁失败: " + err.Error())
+	}
+	if !ok {
+		return false, nil
+	}
+	err = s.HashDel(ctx, key, target)
+	if err != nil {
+		return false, errors.New("解除封禁失败: " + err.Error())
+	}
+	return true, nil
+}
+
+// listBans 获取指定封禁哈希中未到期的封禁，顺带清理已到期的封禁
+func listBans(ctx context.Context, s Store, key string) ([]*Ban, error) {
+	values, err := s.HashGetAll(ctx, key)
+	if err != nil {
+		return nil, errors.New("获取封禁失败: " + err.Error())
+	}
+	now := time.Now()
+	bans := make([]*Ban, 0, len(values))
+	for target, value := range values {
+		var ban Ban
+		if json.Unmarshal([]byte(value), &ban) != nil {
+			continue
+		}
+		if ban.Expired(now) {
+			_ = s.HashDel(ctx, key, target)
+			continue
+		}
+		bans = append(bans, &ban)
+	}
+	return bans, nil
+}
+
+// findBan 在指定的封禁哈希中查找匹配的封禁，nickName 为空时只按地址匹配
+func findBan(ctx context.Context, s Store, key string, nickName string, addr string) (*Ban, error) {
+	bans, err := listBans(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, ban := range bans {
+		if MatchTarget(ban.Target, nickName, addr) {
+			return ban, nil
+		}
+	}
+	return nil, nil
+}
+
+// Mute 禁言用户，d 为 0 时永久禁言，返回解除时间
+func Mute(ctx context.Context, s Store, nickName string, d time.Duration) (int64, error) {
+	until := untilUnix(time.Now(), d)
+	err := s.HashSet(ctx, keyMutes, nickName, strconv.FormatInt(until, 10))
+	if err != nil {
+		return 0, errors.New("保存禁言失败: " + err.Error())
+	}
+	return until, nil
+}
+
+// Unmute 解除禁言，用户未被禁言时返回 false
+func Unmute(ctx context.Context, s Store, nickName string) (bool, error) {
+	_, ok, err := s.HashGet(ctx, keyMutes, nickName)
+	if err != nil {
+		return false, errors.New("获取禁言失败: " + err.Error())
+	}
+	if !ok {
+		return false, nil
+	}
+	err = s.HashDel(ctx, keyMutes, nickName)
+	if err != nil {
+		return false, errors.New("解除禁言失败: " + err.Error())
+	}
+	return true, nil
+}
+
+// MutedUntil 用户是否被禁言及解除时间，到期的禁言自动清理
+func MutedUntil(ctx context.Context, s Store, nickName string) (bool, int64, error) {
+	value, ok, err := s.HashGet(ctx, keyMutes, nickName)
+	if err != nil {
+		return false, 0, errors.New("获取禁言失败: " + err.Error())
+	}
+	if !ok {
+		return false, 0, nil
+	}
+	until, _ := strconv.ParseInt(value, 10, 64)
+	if until > 0 && time.Now().Unix() >= until {
+		_ = s.HashDel(ctx, keyMutes, nickName)
+		return false, 0, nil
+	}
+	return true, until, nil
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/server/object"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMute(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(object.Config{})
+	if muted, _, err := MutedUntil(ctx, s, "alice"); err != nil || muted {
+		t.Fatalf("未禁言时 MutedUntil() = %v, %v", muted, err)
+	}
+	until, err := Mute(ctx, s, "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if muted, got, _ := MutedUntil(ctx, s, "alice"); !muted || got != until {
+		t.Errorf("禁言后 MutedUntil() = %v, %d, want true, %d", muted, got, until)
+	}
+	if _, err = Mute(ctx, s, "bob", 0); err != nil {
+		t.Fatal(err)
+	}
+	if muted, got, _ := MutedUntil(ctx, s, "bob"); !muted || got != 0 {
+		t.Errorf("永久禁言 MutedUntil() = %v, %d, want true, 0", muted, got)
+	}
+
+	// 到期的禁言自动清理
+	past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	_ = s.HashSet(ctx, keyMutes, "carol", past)
+	if muted, _, _ := MutedUntil(ctx, s, "carol"); muted {
+		t.Error("到期的禁言仍然生效")
+	}
+	if _, ok, _ := s.HashGet(ctx, keyMutes, "carol"); ok {
+		t.Error("到期的禁言没有被清理")
+	}
+
+	if ok, err := Unmute(ctx, s, "alice"); err != nil || !ok {
+		t.Errorf("Unmute() = %v, %v, want true", ok, err)
+	}
+	if muted, _, _ := MutedUntil(ctx, s, "alice"); muted {
+		t.Error("解除禁言后仍然生效")
+	}
+	if ok, _ := Unmute(ctx, s, "alice"); ok {
+		t.Error("未禁言的用户 Unmute() = true")
+	}
+}
+
+func TestWorkspaceBan(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(object.Config{})
+	for _, target := range []string{"mallory", "10.0.0.7", "192.168.1.0/24"} {
+		if _, err := AddBan(ctx, s, target, "root", 0, "spam"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		nick   string
+		addr   string
+		target string
+	}{
+		{name: "按昵称", nick: "mallory", addr: "172.16.0.1:4000", target: "mallory"},
+		{name: "按 IP", nick: "alice", addr: "10.0.0.7:5000", target: "10.0.0.7"},
+		{name: "按网段", nick: "bob", addr: "192.168.1.20:5000", target: "192.168.1.0/24"},
+		{name: "网段之外", nick: "bob", addr: "192.168.2.20:5000"},
+		{name: "昵称只匹配昵称", nick: "carol", addr: "172.16.0.1:4000"},
+	}
+	for _, tt := range tests {
+		ban, err := FindBan(ctx, s, tt.nick, tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if ban != nil {
+			got = ban.Target
+		}
+		if got != tt.target {
+			t.Errorf("%s: FindBan() = %q, want %q", tt.name, got, tt.target)
+		}
+	}
+
+	ban, err := GetBan(ctx, s, "10.0.0.7")
+	if err != nil || ban == nil || ban.By != "root" || ban.Reason != "spam" {
+		t.Errorf("GetBan() = %+v, %v", ban, err)
+	}
+	if ban, _ = GetBan(ctx, s, "alice"); ban != nil {
+		t.Errorf("未封禁的目标 GetBan() = %+v", ban)
+	}
+
+	// 工作区封禁只对本工作区生效
+	other := NewMemoryStore(object.Config{})
+	if ban, _ = FindBan(ctx, other, "mallory", "10.0.0.7:5000"); ban != nil {
+		t.Errorf("其他工作区 FindBan() = %+v", ban)
+	}
+	// 工作区的地址封禁不是全网封禁
+	if ban, _ = FindNetworkBan(ctx, s, "10.0.0.7:5000"); ban != nil {
+		t.Errorf("工作区封禁被当作全网封禁: %+v", ban)
+	}
+
+	if ok, _ := RemoveBan(ctx, s, "mallory"); !ok {
+		t.Error("RemoveBan() = false")
+	}
+	if ban, _ = FindBan(ctx, s, "mallory", "172.16.0.1:4000"); ban != nil {
+		t.Errorf("解除封禁后 FindBan() = %+v", ban)
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(object.Config{})
+	if _, err := AddBan(ctx, s, "mallory", "root", time.Hour, ""); err != nil {
+		t.Fatal(err)
+	}
+	expired := `{"target":"eve","by":"root","created":1,"until":2}`
+	_ = s.HashSet(ctx, keyBans, "eve", expired)
+	bans, err := ListBans(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].Target != "mallory" {
+		t.Errorf("ListBans() = %+v, want 只有 mallory", bans)
+	}
+	if _, ok, _ := s.HashGet(ctx, keyBans, "eve"); ok {
+		t.Error("到期的封禁没有被清理")
+	}
+	if ban, _ := FindBan(ctx, s, "eve", ""); ban != nil {
+		t.Errorf("到期的封禁仍然生效: %+v", ban)
+	}
+}
+
+func TestNetworkBan(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(object.Config{})
+	if _, err := AddNetworkBan(ctx, s, "mallory", "console", 0, ""); err == nil {
+		t.Error("全网封禁接受了昵称目标")
+	}
+	if _, err := AddNetworkBan(ctx, s, "203.0.113.0/24", "console", 0, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddNetworkBan(ctx, s, "2001:db8::1", "console", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr   string
+		target string
+	}{
+		{addr: "203.0.113.9:5000", target: "203.0.113.0/24"},
+		{addr: "[2001:db8::1]:5000", target: "2001:db8::1"},
+		{addr: "203.0.114.9:5000"},
+		{addr: "[2001:db8::2]:5000"},
+	}
+	for _, tt := range tests {
+		ban, err := FindNetworkBan(ctx, s, tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if ban != nil {
+			got = ban.Target
+		}
+		if got != tt.target {
+			t.Errorf("FindNetworkBan(%s) = %q, want %q", tt.addr, got, tt.target)
+		}
+	}
+	// 全网封禁不出现在工作区封禁中
+	if bans, _ := ListBans(ctx, s); len(bans) != 0 {
+		t.Errorf("ListBans() = %+v, want 空", bans)
+	}
+	if ok, _ := RemoveNetworkBan(ctx, s, "203.0.113.0/24"); !ok {
+		t.Error("RemoveNetworkBan() = false")
+	}
+	if ban, _ := FindNetworkBan(ctx, s, "203.0.113.9:5000"); ban != nil {
+		t.Errorf("解除全网封禁后 FindNetworkBan() = %+v", ban)
+	}
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultNickMinLength = 2  // 默认最短昵称长度(字符)
+	defaultNickMaxLength = 20 // 默认最长昵称长度(字符)
+)
+
+// defaultNickCategories 默认允许的 Unicode 类别：字母、组合符号、数字、连接符(_)、破折号(-)
+var defaultNickCategories = []string{"L", "M", "N", "Pc", "Pd"}
+
+// defaultReservedNames 默认保留的昵称，console 为服务端终端在审计日志中的操作者名称
+var defaultReservedNames = []string{"admin", "system", "server", "console"}
+
+// NickPolicy 昵称策略：长度、允许的字符类别、保留名称与相似昵称检测
+type NickPolicy struct {
+	minLength  int
+	maxLength  int
+	categories []*unicode.RangeTable
+	reserved   map[string]string // 骨架->保留名称
+}
+
+// NewNickPolicy 根据配置创建昵称策略
+func NewNickPolicy(config object.Config) (*NickPolicy, error) {
+	c := config.Nick
+	p := &NickPolicy{
+		minLength: c.MinLength,
+		maxLength: c.MaxLength,
+		reserved:  make(map[string]string),
+	}
+	if p.minLength <= 0 {
+		p.minLength = defaultNickMinLength
+	}
+	if p.maxLength <= 0 {
+		p.maxLength = defaultNickMaxLength
+	}
+	if p.minLength > p.maxLength {
+		return nil, fmt.Errorf("昵称长度配置错误: minLength %d 大于 maxLength %d", p.minLength, p.maxLength)
+	}
+	categories := c.Categories
+	if len(categories) == 0 {
+		categories = defaultNickCategories
+	}
+	for _, name := range categories {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		table, ok := unicode.Categories[name]
+		if !ok {
+			return nil, errors.New("未知的 Unicode 类别: " + name)
+		}
+		p.categories = append(p.categories, table)
+	}
+	reserved := c.Reserved
+	if len(reserved) == 0 {
+		reserved = defaultReservedNames
+	}
+	for _, name := range reserved {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			p.reserved[skeleton(name)] = name
+		}
+	}
+	return p, nil
+}
+
+// Check 检查昵称是否符合策略，不符合时返回具体原因
+func (p *NickPolicy) Check(nickName string) string {
+	if nickName == "" {
+		return "昵称不能为空"
+	}
+	if !utf8.ValidString(nickName) {
+		return "昵称不是有效的 UTF-8 文本"
+	}
+	if n := utf8.RuneCountInString(nickName); n < p.minLength || n > p.maxLength {
+		return fmt.Sprintf("昵称长度需在 %d 到 %d 个字符之间", p.minLength, p.maxLength)
+	}
+	for _, r := range nickName {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return "昵称不能包含空白或控制字符"
+		}
+		if !unicode.In(r, p.categories...) {
+			return fmt.Sprintf("昵称不能包含字符 %q", r)
+		}
+	}
+	// IP 与网段形式的昵称会与按地址封禁混淆
+	if _, _, err := net.ParseCIDR(nickName); err == nil || net.ParseIP(nickName) != nil {
+		return "昵称不能是 IP 地址或网段"
+	}
+	if name, ok := p.reserved[skeleton(nickName)]; ok {
+		return "昵称 " + name + " 为保留名称"
+	}
+	return ""
+}
+
+// Confusable 在已有昵称中查找与 nickName 容易混淆的昵称(大小写或形近字符不同)，没有时返回空
+func (p *NickPolicy) Confusable(nickName string, names []string) string {
+	s := skeleton(nickName)
+	for _, name := range names {
+		if name != nickName && skeleton(name) == s {
+			return name
+		}
+	}
+	return ""
+}
+
+// CheckRename 检查用户能否把昵称从 old 改为 nickName，online 为工作区的在线用户，不能改名时返回原因
+// 注册用户的昵称与账号绑定不能修改；与在线用户完全相同的昵称在登记新昵称时检查
+func (p *NickPolicy) CheckRename(old string, nickName string, registered bool, online []string) string {
+	switch {
+	case nickName == old:
+		return "新昵称与当前昵称相同"
+	case registered:
+		return "注册用户的昵称与账号绑定，不能修改"
+	}
+	if reason := p.Check(nickName); reason != "" {
+		return reason
+	}
+	names := make([]string, 0, len(online))
+	for _, name := range online {
+		if name != old {
+			names = append(names, name)
+		}
+	}
+	if name := p.Confusable(nickName, names); name != "" {
+		return "昵称与在线用户 " + name + " 过于相似"
+	}
+	return ""
+}
+
+// confusables 形近字符到拉丁字母的映射，覆盖常见的西里尔字母、希腊字母与数字
+var confusables = map[rune]rune{
+	// 西里尔字母
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c',
+	'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ї': 'i', 'ј': 'j', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q',
+	'ԝ': 'w', 'ү': 'y', 'ӏ': 'l',
+	// 希腊字母
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// 其他形近字符
+	'ı': 'i', 'ɑ': 'a', 'ℓ': 'l', '0': 'o', '1': 'l', 'i': 'l',
+}
+
+// skeleton 昵称的骨架：统一全角字符与大小写，去掉组合符号，形近字符映射为同一字母
+// 骨架相同的昵称视为容易混淆
+func skeleton(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		// 全角 ASCII 转为半角
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		// 映射后的 i 与 l、1 同样形近
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return strings.NewReplacer("rn", "m", "vv", "w").Replace(b.String())
+}
@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"strings"
+	"testing"
+)
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{a: "alice", b: "ALICE", same: true},
+		{a: "alice", b: "\u0430lice", same: true},  // 西里尔字母 а
+		{a: "bob", b: "\u03b2\u03bfb", same: true}, // 希腊字母 β ο
+		{a: "admin", b: "ａｄｍｉｎ", same: true},
+		{a: "cafe", b: "cafe\u0301", same: true}, // 组合符号
+		{a: "modern", b: "rnodern", same: true},
+		{a: "will", b: "vvill", same: true},
+		{a: "lil", b: "l1I", same: true},
+		{a: "bob0", b: "bobo", same: true},
+		{a: "alice", b: "alicia", same: false},
+		{a: "bob", b: "rob", same: false},
+		{a: "张三", b: "张三", same: true},
+		{a: "张三", b: "李四", same: false},
+	}
+	for _, tt := range tests {
+		if got := skeleton(tt.a) == skeleton(tt.b); got != tt.same {
+			t.Errorf("skeleton(%q) = %q, skeleton(%q) = %q, same = %v, want %v",
+				tt.a, skeleton(tt.a), tt.b, skeleton(tt.b), got, tt.same)
+		}
+	}
+}
+
+func TestNickPolicyCheck(t *testing.T) {
+	var config object.Config
+	config.Nick.MaxLength = 8
+	config.Nick.Reserved = []string{"admin", "system"}
+	p, err := NewNickPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		nick string
+		want string // 原因包含的文本，为空时应通过
+	}{
+		{name: "普通昵称", nick: "alice", want: ""},
+		{name: "中文昵称", nick: "张三", want: ""},
+		{name: "连接符与破折号", nick: "a_b-c", want: ""},
+		{name: "空昵称", nick: "", want: "不能为空"},
+		{name: "过短", nick: "a", want: "昵称长度需在 2 到 8 个字符之间"},
+		{name: "过长", nick: "abcdefghi", want: "昵称长度需在 2 到 8 个字符之间"},
+		{name: "按字符计算长度", nick: "一二三四五六七八", want: ""},
+		{name: "空白", nick: "al ice", want: "空白或控制字符"},
+		{name: "控制字符", nick: "al\tice", want: "空白或控制字符"},
+		{name: "不允许的类别", nick: "alice!", want: "不能包含字符 '!'"},
+		{name: "无效的 UTF-8", nick: "al\xffce", want: "UTF-8"},
+		{name: "保留名称", nick: "admin", want: "保留名称"},
+		{name: "保留名称的大小写变体", nick: "ADMIN", want: "保留名称"},
+		{name: "保留名称的形近字符", nick: "\u0455ystem", want: "保留名称"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Check(tt.nick)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.nick, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNickPolicyAddress(t *testing.T) {
+	var config object.Config
+	config.Nick.MaxLength = 40
+	config.Nick.Categories = []string{"L", "N", "P"}
+	p, err := NewNickPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nick := range []string{"10.0.0.1", "10.0.0.0/8", "::1", "fe80::1"} {
+		if got := p.Check(nick); got != "昵称不能是 IP 地址或网段" {
+			t.Errorf("Check(%q) = %q, want 昵称不能是 IP 地址或网段", nick, got)
+		}
+	}
+}
+
+func TestNewNickPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		nick    func(c *object.Config)
+		wantErr bool
+	}{
+		{name: "默认配置", nick: func(c *object.Config) {}},
+		{name: "最短长度大于最长长度", nick: func(c *object.Config) { c.Nick.MinLength, c.Nick.MaxLength = 5, 3 }, wantErr: true},
+		{name: "未知的 Unicode 类别", nick: func(c *object.Config) { c.Nick.Categories = []string{"L", "Xx"} }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config object.Config
+			tt.nick(&config)
+			if _, err := NewNickPolicy(config); (err != nil) != tt.wantErr {
+				t.Errorf("NewNickPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNickPolicyConfusable(t *testing.T) {
+	p, err := NewNickPolicy(object.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"alice", "bob"}
+	tests := []struct {
+		nick string
+		want string
+	}{
+		{nick: "Alice", want: "alice"},
+		{nick: "\u0430lice", want: "alice"}, // 西里尔字母 а
+		{nick: "alice", want: ""},           // 与自己相同不算混淆
+		{nick: "carol", want: ""},
+	}
+	for _, tt := range tests {
+		if got := p.Confusable(tt.nick, names); got != tt.want {
+			t.Errorf("Confusable(%q) = %q, want %q", tt.nick, got, tt.want)
+		}
+	}
+}
+
+func TestNickPolicyCheckRename(t *testing.T) {
+	p, err := NewNickPolicy(object.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	online := []string{"guest1", "alice", "bob"}
+	tests := []struct {
+		name       string
+		old        string
+		nick       string
+		registered bool
+		want       string // 原因包含的文本，为空时应通过
+	}{
+		{name: "改名", old: "guest1", nick: "carol", want: ""},
+		{name: "与当前昵称相同", old: "guest1", nick: "guest1", want: "相同"},
+		{name: "注册用户", old: "alice", nick: "alice2", registered: true, want: "不能修改"},
+		{name: "不符合策略", old: "guest1", nick: "ca rol", want: "空白或控制字符"},
+		{name: "保留名称", old: "guest1", nick: "Console", want: "保留名称"},
+		{name: "与在线用户大小写不同", old: "guest1", nick: "Alice", want: "在线用户 alice"},
+		{name: "与在线用户形近", old: "guest1", nick: "b\u043eb", want: "在线用户 bob"}, // 西里尔字母 о
+		{name: "只改变自己昵称的大小写", old: "guest1", nick: "Guest1", want: ""},
+	}
+	for _, tt := range tests {
+		got := p.CheckRename(tt.old, tt.nick, tt.registered, online)
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: CheckRename(%q, %q) = %q, want %q", tt.name, tt.old, tt.nick, got, tt.want)
+		}
+	}
+}
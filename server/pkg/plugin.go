@@ -0,0 +1,440 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"easy-chat/server/object"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 插件事件
+const (
+	PluginMessage = "message" // 聊天消息投递后
+	PluginJoin    = "join"    // 用户进入聊天室
+	PluginLeave   = "leave"   // 用户退出聊天室
+	PluginCommand = "command" // 客户端发送插件注册的命令
+)
+
+// 插件动作
+const (
+	PluginSend  = "send"  // 在房间发送消息
+	PluginReply = "reply" // 只回复触发事件的用户
+	PluginKick  = "kick"  // 踢出用户
+	PluginTopic = "topic" // 设置房间话题
+)
+
+// 插件重启策略
+const (
+	RestartAlways    = "always"     // 退出后总是重启
+	RestartOnFailure = "on-failure" // 异常退出或响应超时后重启
+	RestartNever     = "never"      // 不重启
+)
+
+// 插件状态
+const (
+	pluginRunning    = "运行中"
+	pluginRestarting = "等待重启"
+	pluginStopped    = "已停止"
+	pluginFailed     = "重启次数过多，已放弃"
+)
+
+const (
+	defaultPluginTimeout = 3 * time.Second // 默认响应超时时间
+	pluginQueueSize      = 64              // 等待写入插件的事件数上限
+	pluginRetryMin       = time.Second     // 首次重启的等待时间
+	pluginRetryMax       = time.Minute     // 重启等待时间上限
+	pluginStable         = time.Minute     // 运行超过该时间后重新计算连续重启次数
+)
+
+var (
+	ErrPluginNotRunning = errors.New("插件未运行")
+	ErrPluginBusy       = errors.New("插件繁忙，事件已丢弃")
+)
+
+// PluginEvent 发送给插件的事件，每个事件一行 JSON
+type PluginEvent struct {
+	ID        uint64   `json:"id"` // 事件编号，插件响应时原样返回
+	Event     string   `json:"event"`
+	Workspace string   `json:"workspace"`
+	Room      string   `json:"room,omitempty"`
+	From      string   `json:"from,omitempty"` // 触发事件的用户
+	Text      string   `json:"text,omitempty"`
+	Command   string   `json:"command,omitempty"` // 命令名称，如 /roll
+	Args      []string `json:"args,omitempty"`    // 命令参数
+	Time      int64    `json:"time"`
+}
+
+// PluginAction 插件返回的动作
+type PluginAction struct {
+	Action string `json:"action"`
+	Room   string `json:"room,omitempty"` // send 与 topic 的房间，默认为事件所在房间
+	Text   string `json:"text,omitempty"`
+	Target string `json:"target,omitempty"` // kick 的目标用户
+	Reason string `json:"reason,omitempty"`
+}
+
+// pluginResponse 插件的响应，每个响应一行 JSON，id 为 0 时为插件主动执行的动作
+type pluginResponse struct {
+	ID      uint64         `json:"id"`
+	Actions []PluginAction `json:"actions"`
+}
+
+// PluginHandler 执行插件返回的动作，插件主动执行的动作 event 为 nil
+type PluginHandler func(p *Plugin, event *PluginEvent, action PluginAction)
+
+// pendingEvent 已发送、等待响应的事件
+type pendingEvent struct {
+	event PluginEvent
+	timer *time.Timer
+}
+
+// Plugin 外部插件进程，通过标准输入输出交换换行分隔的 JSON
+// 每个事件需要在超时时间内响应，超时的插件被视为卡死并结束进程，之后按重启策略重启
+type Plugin struct {
+	Name    string
+	conf    object.PluginConfig
+	timeout time.Duration
+	events  map[string]bool
+	handler PluginHandler
+	logger  *logrus.Logger
+	queue   chan PluginEvent
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	state    string
+	killed   string // 结束进程的原因
+	stopped  bool
+	pending  map[uint64]*pendingEvent
+	nextID   uint64
+	started  time.Time
+	restarts int // 累计重启次数
+	failures int // 连续重启次数
+	sent     int
+	timeouts int
+	dropped  int
+	lastErr  string
+}
+
+// NewPlugin 根据配置创建插件，调用 Start 后启动进程
+func NewPlugin(name string, conf object.PluginConfig, handler PluginHandler, logger *logrus.Logger) (*Plugin, error) {
+	if conf.Command == "" {
+		return nil, errors.New("插件 " + name + " 未配置 command")
+	}
+	switch conf.Restart {
+	case "":
+		conf.Restart = RestartOnFailure
+	case RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return nil, errors.New("插件 " + name + " 的重启策略有误: " + conf.Restart + "，可选 always、on-failure、never")
+	}
+	if conf.Role == "" {
+		conf.Role = RoleMember
+	}
+	if !IsRole(conf.Role) {
+		return nil, errors.New("插件 " + name + " 的角色不存在: " + conf.Role)
+	}
+	if conf.Nick == "" {
+		conf.Nick = name
+	}
+	events := make(map[string]bool)
+	for _, event := range conf.Events {
+		event = strings.TrimSpace(event)
+		switch event {
+		case PluginMessage, PluginJoin, PluginLeave:
+			events[event] = true
+		default:
+			return nil, errors.New("插件 " + name + " 订阅的事件有误: " + event + "，可选 message、join、leave")
+		}
+	}
+	for i, command := range conf.Commands {
+		conf.Commands[i] = strings.TrimSpace(command)
+		if !strings.HasPrefix(conf.Commands[i], "/") {
+			return nil, errors.New("插件 " + name + " 的命令需要以 / 开头: " + command)
+		}
+	}
+	timeout := time.Duration(conf.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	return &Plugin{
+		Name:    name,
+		conf:    conf,
+		timeout: timeout,
+		events:  events,
+		handler: handler,
+		logger:  logger,
+		queue:   make(chan PluginEvent, pluginQueueSize),
+		state:   pluginStopped,
+		pending: make(map[uint64]*pendingEvent),
+	}, nil
+}
+
+// Nick 插件发送消息时使用的昵称
+func (p *Plugin) Nick() string {
+	return p.conf.Nick
+}
+
+// Role 插件执行动作时的角色
+func (p *Plugin) Role() string {
+	return p.conf.Role
+}
+
+// Commands 插件处理的客户端命令
+func (p *Plugin) Commands() []string {
+	return p.conf.Commands
+}
+
+// Subscribed 插件是否订阅了事件，命令事件只发送给注册了该命令的插件
+func (p *Plugin) Subscribed(event string) bool {
+	return event == PluginCommand || len(p.events) == 0 || p.events[event]
+}
+
+// Start 启动插件进程，进程退出后按重启策略重启
+func (p *Plugin) Start() {
+	go p.run()
+}
+
+// Stop 结束插件进程，之后不再重启
+func (p *Plugin) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	if p.cmd != nil {
+		_ = p.cmd.Process.Kill()
+	}
+}
+
+// Send 将事件放入插件的写入队列，不等待插件响应，队列已满时丢弃事件
+func (p *Plugin) Send(event PluginEvent) error {
+	if !p.Subscribed(event.Event) {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != pluginRunning {
+		return ErrPluginNotRunning
+	}
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		p.dropped++
+		return ErrPluginBusy
+	}
+}
+
+// run 运行插件进程，退出后按重启策略等待并重启
+func (p *Plugin) run() {
+	for {
+		err := p.runOnce()
+		p.mu.Lock()
+		if p.stopped {
+			p.state = pluginStopped
+			p.mu.Unlock()
+			return
+		}
+		if err != nil {
+			p.lastErr = err.Error()
+		}
+		if time.Since(p.started) > pluginStable {
+			p.failures = 0
+		}
+		restart := p.conf.Restart == RestartAlways || (p.conf.Restart == RestartOnFailure && err != nil)
+		switch {
+		case !restart:
+			p.state = pluginStopped
+		case p.conf.MaxRestarts > 0 && p.failures >= p.conf.MaxRestarts:
+			p.state = pluginFailed
+		default:
+			p.state = pluginRestarting
+			p.failures++
+			p.restarts++
+		}
+		state, failures := p.state, p.failures
+		p.mu.Unlock()
+		entry := p.logger.WithField("plugin", p.Name)
+		if err != nil {
+			entry = entry.WithError(err)
+		}
+		if state != pluginRestarting {
+			entry.Warn("plugin exited, state: ", state)
+			return
+		}
+		delay := pluginRetryMin << (failures - 1)
+		if delay <= 0 || delay > pluginRetryMax {
+			delay = pluginRetryMax
+		}
+		entry.Warn("plugin exited, restart in ", delay)
+		time.Sleep(delay)
+	}
+}
+
+// runOnce 启动一次插件进程并等待其退出
+func (p *Plugin) runOnce() error {
+	cmd := exec.Command(p.conf.Command, p.conf.Args...)
+	cmd.Stderr = pluginStderr{p}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.started = time.Now()
+	p.mu.Unlock()
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.cmd, p.state = cmd, pluginRunning
+	p.mu.Unlock()
+	p.logger.WithField("plugin", p.Name).Info("plugin started, pid: ", cmd.Process.Pid)
+
+	done := make(chan struct{})
+	go p.write(stdin, done)
+	p.read(stdout)
+	err = cmd.Wait()
+	close(done)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pe := range p.pending {
+		pe.timer.Stop()
+		delete(p.pending, id)
+	}
+	p.cmd = nil
+	if p.killed != "" {
+		err, p.killed = errors.New(p.killed), ""
+	}
+	return err
+}
+
+// write 将队列中的事件写入插件的标准输入
+func (p *Plugin) write(stdin io.WriteCloser, done <-chan struct{}) {
+	defer stdin.Close()
+	encoder := json.NewEncoder(stdin)
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-p.queue:
+			p.mu.Lock()
+			p.nextID++
+			event.ID = p.nextID
+			id := event.ID
+			p.pending[id] = &pendingEvent{event: event, timer: time.AfterFunc(p.timeout, func() { p.expire(id) })}
+			p.mu.Unlock()
+			if err := encoder.Encode(event); err != nil {
+				p.logger.WithField("plugin", p.Name).Error("write event failed, err:", err)
+				p.mu.Lock()
+				if pe, ok := p.pending[id]; ok {
+					pe.timer.Stop()
+					delete(p.pending, id)
+				}
+				p.mu.Unlock()
+				continue
+			}
+			p.mu.Lock()
+			p.sent++
+			p.mu.Unlock()
+		}
+	}
+}
+
+// read 读取插件的响应并执行其中的动作
+func (p *Plugin) read(stdout io.Reader) {
+	entry := p.logger.WithField("plugin", p.Name)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var resp pluginResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			entry.Warn("invalid plugin output: ", string(line))
+			continue
+		}
+		var event *PluginEvent
+		if resp.ID != 0 {
+			p.mu.Lock()
+			pe, ok := p.pending[resp.ID]
+			if ok {
+				pe.timer.Stop()
+				delete(p.pending, resp.ID)
+			}
+			p.mu.Unlock()
+			if !ok {
+				entry.Warn("discard expired or unknown response, id: ", resp.ID)
+				continue
+			}
+			event = &pe.event
+		}
+		for _, action := range resp.Actions {
+			p.handler(p, event, action)
+		}
+	}
+}
+
+// expire 事件响应超时，结束插件进程
+func (p *Plugin) expire(id uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pe, ok := p.pending[id]
+	if !ok {
+		return
+	}
+	delete(p.pending, id)
+	p.timeouts++
+	if p.cmd != nil && p.killed == "" {
+		p.killed = fmt.Sprintf("%s 事件响应超时(%s)", pe.event.Event, p.timeout)
+		_ = p.cmd.Process.Kill()
+	}
+}
+
+// String 插件状态，用于服务端终端 /plugins
+func (p *Plugin) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	msg := p.Name + "  " + p.state
+	if p.cmd != nil {
+		msg += "  pid: " + strconv.Itoa(p.cmd.Process.Pid) + "  运行时长: " + time.Since(p.started).Truncate(time.Second).String()
+	}
+	msg += fmt.Sprintf("  重启: %d  事件: %d  超时: %d  丢弃: %d", p.restarts, p.sent, p.timeouts, p.dropped)
+	if len(p.conf.Commands) > 0 {
+		msg += "  命令: " + strings.Join(p.conf.Commands, ",")
+	}
+	if p.lastErr != "" {
+		msg += "\n    最近错误: " + p.lastErr
+	}
+	return msg
+}
+
+// pluginStderr 将插件的标准错误输出逐行写入日志
+type pluginStderr struct {
+	p *Plugin
+}
+
+func (w pluginStderr) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" {
+			w.p.logger.WithField("plugin", w.p.Name).Warn("plugin stderr: ", line)
+		}
+	}
+	return len(data), nil
+}
@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"bufio"
+	"easy-chat/server/object"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginHelperProcess 作为插件进程运行，由其他测试通过 testPlugin 启动
+func TestPluginHelperProcess(t *testing.T) {
+	mode := os.Getenv("EASY_CHAT_PLUGIN_HELPER")
+	if mode == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var event PluginEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			os.Exit(2)
+		}
+		switch mode {
+		case "echo":
+			// 先输出无法解析的内容与未知编号的响应，再正常响应
+			fmt.Println("not json")
+			fmt.Println(`{"id":9999,"actions":[{"action":"send","text":"stale"}]}`)
+			fmt.Printf(`{"id":%d,"actions":[{"action":"send","text":"echo: %s"}]}`+"\n", event.ID, event.Text)
+		case "hang":
+			// 读取事件但从不响应
+		}
+	}
+	os.Exit(0)
+}
+
+// pluginCall 插件动作的调用记录
+type pluginCall struct {
+	event  *PluginEvent
+	action PluginAction
+}
+
+// testPlugin 以测试程序自身作为插件进程启动插件，测试结束时停止
+func testPlugin(t *testing.T, mode string, conf object.PluginConfig) (*Plugin, chan pluginCall) {
+	t.Helper()
+	t.Setenv("EASY_CHAT_PLUGIN_HELPER", mode)
+	conf.Command = os.Args[0]
+	conf.Args = []string{"-test.run=^TestPluginHelperProcess$"}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	calls := make(chan pluginCall, 16)
+	p, err := NewPlugin("helper", conf, func(p *Plugin, event *PluginEvent, action PluginAction) {
+		calls <- pluginCall{event: event, action: action}
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	t.Cleanup(p.Stop)
+	waitPlugin(t, p, func() bool { return p.state == pluginRunning })
+	return p, calls
+}
+
+// waitPlugin 等待插件满足条件，检查时持有插件的锁
+func waitPlugin(t *testing.T, p *Plugin, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		ok := cond()
+		p.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("等待插件超时: %s", p)
+}
+
+func TestPluginProtocol(t *testing.T) {
+	p, calls := testPlugin(t, "echo", object.PluginConfig{Timeout: 2000})
+	for _, text := range []string{"hi", "again"} {
+		if err := p.Send(PluginEvent{Event: PluginMessage, Workspace: "default", Room: "dev", From: "bob", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case call := <-calls:
+			// 无法解析的输出与未知编号的响应被丢弃，只执行对应事件的动作
+			if call.action.Action != PluginSend || call.action.Text != "echo: "+text {
+				t.Errorf("动作 = %+v, want send echo: %s", call.action, text)
+			}
+			if call.event == nil || call.event.Room != "dev" || call.event.From != "bob" || call.event.Text != text {
+				t.Errorf("动作对应的事件 = %+v", call.event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("没有收到插件的响应")
+		}
+	}
+	select {
+	case call := <-calls:
+		t.Errorf("多余的动作: %+v", call.action)
+	default:
+	}
+	waitPlugin(t, p, func() bool { return len(p.pending) == 0 })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != pluginRunning || p.timeouts != 0 || p.sent != 2 {
+		t.Errorf("插件状态 = %s, 超时 %d 次, 发送 %d 个事件", p.state, p.timeouts, p.sent)
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	p, calls := testPlugin(t, "hang", object.PluginConfig{Timeout: 100, Restart: RestartNever})
+	if err := p.Send(PluginEvent{Event: PluginMessage, Workspace: "default", Room: "lobby", Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	// 超时的插件被结束，重启策略为 never 时不再重启
+	waitPlugin(t, p, func() bool { return p.state == pluginStopped })
+	p.mu.Lock()
+	timeouts, lastErr, cmd := p.timeouts, p.lastErr, p.cmd
+	p.mu.Unlock()
+	if timeouts != 1 || !strings.Contains(lastErr, "响应超时") || cmd != nil {
+		t.Errorf("超时 %d 次, 最近错误 %q, 进程 %v", timeouts, lastErr, cmd)
+	}
+	if err := p.Send(PluginEvent{Event: PluginMessage, Workspace: "default", Text: "hi"}); err != ErrPluginNotRunning {
+		t.Errorf("插件停止后 Send() = %v, want %v", err, ErrPluginNotRunning)
+	}
+	select {
+	case call := <-calls:
+		t.Errorf("卡死的插件执行了动作: %+v", call.action)
+	default:
+	}
+}
+
+func TestNewPlugin(t *testing.T) {
+	tests := []struct {
+		name string
+		conf object.PluginConfig
+		err  string
+	}{
+		{name: "缺少 command", conf: object.PluginConfig{}, err: "未配置 command"},
+		{name: "重启策略有误", conf: object.PluginConfig{Command: "x", Restart: "sometimes"}, err: "重启策略有误"},
+		{name: "角色不存在", conf: object.PluginConfig{Command: "x", Role: "owner"}, err: "角色不存在"},
+		{name: "订阅的事件有误", conf: object.PluginConfig{Command: "x", Events: []string{"message", "edit"}}, err: "订阅的事件有误"},
+		{name: "命令缺少 /", conf: object.PluginConfig{Command: "x", Commands: []string{"roll"}}, err: "需要以 / 开头"},
+		{name: "默认值", conf: object.PluginConfig{Command: "x"}},
+	}
+	for _, tt := range tests {
+		p, err := NewPlugin("dice", tt.conf, nil, logrus.New())
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: NewPlugin() error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Nick() != "dice" || p.Role() != RoleMember || p.timeout != defaultPluginTimeout || p.conf.Restart != RestartOnFailure {
+			t.Errorf("%s: NewPlugin() = %s, %s, %v, %s", tt.name, p.Nick(), p.Role(), p.timeout, p.conf.Restart)
+		}
+	}
+}
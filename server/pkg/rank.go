@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// 排行榜时间窗口
+const (
+	WindowDay   = "day"   // 日榜
+	WindowWeek  = "week"  // 周榜
+	WindowMonth = "month" // 月榜
+	WindowAll   = "all"   // 总榜
+)
+
+// rankWindows 所有时间窗口
+var rankWindows = []string{WindowDay, WindowWeek, WindowMonth, WindowAll}
+
+// windowNames 时间窗口的显示名称
+var windowNames = map[string]string{
+	WindowDay:   "今日",
+	WindowWeek:  "本周",
+	WindowMonth: "本月",
+	WindowAll:   "总榜",
+}
+
+// rankShowLimit 排行榜显示的人数
+const rankShowLimit = 20
+
+// rankKey 排行榜键，按日期区分时间窗口，room 不为空时为房间排行榜
+func rankKey(window string, room string, t time.Time) string {
+	key := "rank:"
+	if room != "" {
+		key += "room:" + room + ":"
+	}
+	switch window {
+	case WindowDay:
+		return key + "day:" + t.Format("20060102")
+	case WindowWeek:
+		year, week := t.ISOWeek()
+		return key + fmt.Sprintf("week:%dW%02d", year, week)
+	case WindowMonth:
+		return key + "month:" + t.Format("200601")
+	default:
+		return key + "all"
+	}
+}
+
+// windowTTL 时间窗口排行榜的保留时间，过期后自动删除
+func windowTTL(window string) time.Duration {
+	switch window {
+	case WindowDay:
+		return 2 * 24 * time.Hour
+	case WindowWeek:
+		return 15 * 24 * time.Hour
+	case WindowMonth:
+		return 62 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// AddActivity 为用户在各时间窗口的工作区排行榜与房间排行榜加分，分数按计分策略加权后写入当前一代的排行榜
+func AddActivity(ctx context.Context, s Store, policy *ScorePolicy, room string, nickName string, weight float64) error {
+	now := time.Now()
+	gen := policy.generation(now)
+	score := weight * policy.factor(gen, now)
+	for _, window := range rankWindows {
+		err := s.ZIncrBy(ctx, policy.genKey(rankKey(window, "", now), gen), nickName, score, rankTTL(policy, window))
+		if err != nil {
+			return err
+		}
+		if room == "" {
+			continue
+		}
+		err = s.ZIncrBy(ctx, policy.genKey(rankKey(window, room, now), gen), nickName, score, rankTTL(policy, window))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rankTTL 排行榜的保留时间，总榜按代过期
+func rankTTL(policy *ScorePolicy, window string) time.Duration {
+	if window == WindowAll {
+		return policy.genTTL()
+	}
+	return windowTTL(window)
+}
+
+// activityKeys 当前各时间窗口的工作区排行榜与有消息的房间的排行榜，包含本代与上一代，键->过期时间
+func activityKeys(ctx context.Context, s Store, policy *ScorePolicy) (map[string]time.Duration, error) {
+	rooms, err := s.HashGetAll(ctx, keyStatsRooms)
+	if err != nil {
+		return nil, errors.New("获取房间列表失败: " + err.Error())
+	}
+	if _, ok := rooms[DefaultRoom]; !ok {
+		rooms[DefaultRoom] = ""
+	}
+	now := time.Now()
+	gen := policy.generation(now)
+	keys := make(map[string]time.Duration)
+	for _, window := range rankWindows {
+		for _, g := range []int64{gen, gen - 1} {
+			keys[policy.genKey(rankKey(window, "", now), g)] = rankTTL(policy, window)
+			for room := range rooms {
+				keys[policy.genKey(rankKey(window, room, now), g)] = rankTTL(policy, window)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// RenameActivity 将用户在当前各时间窗口排行榜中的分数迁移到新昵称，房间排行榜按有消息的房间迁移
+func RenameActivity(ctx context.Context, s Store, policy *ScorePolicy, oldName string, newName string) error {
+	keys, err := activityKeys(ctx, s, policy)
+	if err != nil {
+		return err
+	}
+	for key, ttl := range keys {
+		score, ok, err := s.ZScore(ctx, key, oldName)
+		if err != nil {
+			return errors.New("迁移用户活跃度失败: " + err.Error())
+		}
+		if !ok {
+			continue
+		}
+		err = s.ZIncrBy(ctx, key, newName, score, ttl)
+		if err == nil {
+			err = s.ZRem(ctx, key, oldName)
+		}
+		if err != nil {
+			return errors.New("迁移用户活跃度失败: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// RemoveActivity 将用户从当前各时间窗口的排行榜中移除，如账号被标记为机器人
+func RemoveActivity(ctx context.Context, s Store, policy *ScorePolicy, nickName string) error {
+	keys, err := activityKeys(ctx, s, policy)
+	if err != nil {
+		return err
+	}
+	for key := range keys {
+		if err = s.ZRem(ctx, key, nickName); err != nil {
+			return errors.New("移除用户活跃度失败: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// ShowRank 查看排行榜，window 为空时查看总榜，room 不为空时查看房间排行榜
+// 分数按计分策略换算为当前时刻的有效分数
+func ShowRank(ctx context.Context, s Store, policy *ScorePolicy, window string, room string) (string, error) {
+	if window == "" {
+		window = WindowAll
+	}
+	name, ok := windowNames[window]
+	if !ok {
+		return "", errors.New("未知的时间窗口: " + window + "，可选 day、week、month、all")
+	}
+	items, err := rankItems(ctx, s, policy, rankKey(window, room, time.Now()), time.Now())
+	if err != nil {
+		return "", errors.New("获取用户活跃度失败: " + err.Error())
+	}
+
+	// 检查排行榜是否为空
+	if len(items) == 0 {
+		return "", errors.New("排行榜为空")
+	}
+
+	// 返回排名、用户和分数
+	msg := "用户活跃度排行榜(" + name + "):\n"
+	if room != "" {
+		msg = "房间 " + room + " 用户活跃度排行榜(" + name + "):\n"
+	}
+	for i, item := range items {
+		msg += fmt.Sprintf("%d. %s  积分: %.1f", i+1, item.Member, item.Score)
+		if i < len(items)-1 {
+			msg += "\n"
+		}
+	}
+	return msg, nil
+}
+
+// rankItems 排行榜前 rankShowLimit 名，合并本代与上一代的分数并换算为 now 时刻的有效分数
+func rankItems(ctx context.Context, s Store, policy *ScorePolicy, key string, now time.Time) ([]RankItem, error) {
+	gen := policy.generation(now)
+	if !policy.decays() {
+		return s.ZRevRange(ctx, policy.genKey(key, gen), rankShowLimit)
+	}
+	scores := make(map[string]float64)
+	for _, g := range []int64{gen - 1, gen} {
+		items, err := s.ZRevRange(ctx, policy.genKey(key, g), 0)
+		if err != nil {
+			return nil, err
+		}
+		factor := policy.factor(g, now)
+		for _, item := range items {
+			scores[item.Member] += item.Score / factor
+		}
+	}
+	items := make([]RankItem, 0, len(scores))
+	for member, score := range scores {
+		items = append(items, RankItem{Member: member, Score: score})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Member < items[j].Member
+	})
+	if len(items) > rankShowLimit {
+		items = items[:rankShowLimit]
+	}
+	return items, nil
+}
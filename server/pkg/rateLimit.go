@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 多次超出限流后的处罚方式
+const (
+	FloodMute       = "mute"       // 自动禁言
+	FloodDisconnect = "disconnect" // 断开连接
+)
+
+// defaultFloodMute 默认自动禁言时长
+const defaultFloodMute = 10 * time.Minute
+
+// FloodVerdict 限流检查结果
+type FloodVerdict int
+
+const (
+	FloodAllow    FloodVerdict = iota // 放行
+	FloodThrottle                     // 丢弃本条消息
+	FloodWarn                         // 丢弃本条消息并警告
+	FloodPunish                       // 丢弃本条消息并禁言或断开连接
+)
+
+// RateLimit 限流策略，每个会话按策略创建各自的 FloodGuard
+type RateLimit struct {
+	msgRate      float64 // 每秒消息数
+	msgBurst     float64 // 消息突发上限
+	byteRate     float64 // 每秒字节数
+	byteBurst    float64 // 字节突发上限
+	repeatLimit  int     // 允许连续发送相同消息的条数
+	warnAfter    int     // 违规多少次后警告
+	punishAfter  int     // 违规多少次后处罚
+	window       time.Duration
+	action       string
+	muteDuration time.Duration
+}
+
+// NewRateLimit 根据配置创建限流策略，速率为 0 时不限制对应的维度
+func NewRateLimit(c object.RateLimitConfig) (*RateLimit, error) {
+	l := &RateLimit{
+		msgRate:     c.MessagesPerSecond,
+		msgBurst:    c.MessageBurst,
+		byteRate:    c.BytesPerSecond,
+		byteBurst:   c.ByteBurst,
+		repeatLimit: c.RepeatLimit,
+		warnAfter:   c.WarnAfter,
+		punishAfter: c.PunishAfter,
+		window:      time.Duration(c.Window) * time.Second,
+		action:      c.Action,
+	}
+	if l.msgBurst < l.msgRate {
+		l.msgBurst = l.msgRate
+	}
+	if l.byteBurst < l.byteRate {
+		l.byteBurst = l.byteRate
+	}
+	if l.window <= 0 {
+		l.window = time.Minute
+	}
+	switch l.action {
+	case "":
+		l.action = FloodMute
+	case FloodMute, FloodDisconnect:
+	default:
+		return nil, errors.New("未知的限流处罚方式: " + l.action + "，可选 mute、disconnect")
+	}
+	l.muteDuration = defaultFloodMute
+	if l.action == FloodMute && c.MuteDuration != "" {
+		d, err := ParseDuration(c.MuteDuration)
+		if err != nil {
+			return nil, errors.New("限流禁言时长配置错误: " + err.Error())
+		}
+		l.muteDuration = d
+	}
+	return l, nil
+}
+
+// Action 处罚方式
+func (l *RateLimit) Action() string {
+	return l.action
+}
+
+// MuteDuration 自动禁言的时长，0 表示永久
+func (l *RateLimit) MuteDuration() time.Duration {
+	return l.muteDuration
+}
+
+// NewGuard 为会话创建限流器，令牌桶初始为满
+func (l *RateLimit) NewGuard() *FloodGuard {
+	now := time.Now()
+	return &FloodGuard{
+		limit: l,
+		msgs:  tokenBucket{rate: l.msgRate, burst: l.msgBurst, tokens: l.msgBurst, last: now},
+		bytes: tokenBucket{rate: l.byteRate, burst: l.byteBurst, tokens: l.byteBurst, last: now},
+	}
+}
+
+// tokenBucket 令牌桶，rate 为 0 时不限制
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// refill 按经过的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// enough 令牌是否足够
+func (b *tokenBucket) enough(n float64) bool {
+	return b.rate <= 0 || b.tokens >= n
+}
+
+// FloodGuard 会话的限流器，记录限流、重复消息与警告次数
+type FloodGuard struct {
+	mu            sync.Mutex
+	limit         *RateLimit
+	msgs          tokenBucket
+	bytes         tokenBucket
+	lastText      string
+	repeats       int // 连续相同消息的条数
+	violations    int // 时间窗口内的违规次数
+	lastViolation time.Time
+
+	throttled int // 因超出速率被丢弃的消息数
+	repeated  int // 因重复被丢弃的消息数
+	warned    int // 警告次数
+}
+
+// Check 检查会话能否发送一条消息，repeat 为 true 时检测重复消息，不放行时返回原因
+func (g *FloodGuard) Check(text string, repeat bool, now time.Time) (FloodVerdict, string) {
+	if g == nil {
+		return FloodAllow, ""
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.msgs.refill(now)
+	g.bytes.refill(now)
+	size := float64(len(text))
+	reason := ""
+	switch {
+	case !g.msgs.enough(1):
+		reason = "发送过快"
+		g.throttled++
+	case !g.bytes.enough(size):
+		reason = "发送的数据量过大"
+		g.throttled++
+	default:
+		g.msgs.tokens--
+		g.bytes.tokens -= size
+	}
+	if reason == "" && repeat && g.limit.repeatLimit > 0 {
+		if text == g.lastText {
+			g.repeats++
+		} else {
+			g.lastText, g.repeats = text, 1
+		}
+		if g.repeats > g.limit.repeatLimit {
+			reason = "请勿重复发送相同的消息"
+			g.repeated++
+		}
+	}
+	if reason == "" {
+		return FloodAllow, ""
+	}
+	if now.Sub(g.lastViolation) > g.limit.window {
+		g.violations = 0
+	}
+	g.violations++
+	g.lastViolation = now
+	switch {
+	case g.limit.punishAfter > 0 && g.violations >= g.limit.punishAfter:
+		g.violations = 0
+		return FloodPunish, reason
+	case g.violations == g.limit.warnAfter:
+		g.warned++
+		return FloodWarn, reason
+	default:
+		return FloodThrottle, reason
+	}
+}
+
+// Limit 创建限流器的限流策略，处罚时按该策略的处罚方式与禁言时长执行
+func (g *FloodGuard) Limit() *RateLimit {
+	return g.limit
+}
+
+// Counters 限流计数：限流/重复/警告
+func (g *FloodGuard) Counters() string {
+	if g == nil {
+		return "-"
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return fmt.Sprintf("%d/%d/%d", g.throttled, g.repeated, g.warned)
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name    string
+		bucket  tokenBucket
+		elapsed time.Duration
+		need    float64
+		tokens  float64 // 补充后的令牌数
+		enough  bool
+	}{
+		{name: "补充令牌", bucket: tokenBucket{rate: 2, burst: 10, tokens: 1}, elapsed: time.Second, need: 3, tokens: 3, enough: true},
+		{name: "令牌不足", bucket: tokenBucket{rate: 2, burst: 10, tokens: 0}, elapsed: 500 * time.Millisecond, need: 2, tokens: 1, enough: false},
+		{name: "不超过突发上限", bucket: tokenBucket{rate: 2, burst: 5, tokens: 4}, elapsed: time.Minute, need: 5, tokens: 5, enough: true},
+		{name: "速率为 0 时不限制", bucket: tokenBucket{}, elapsed: time.Second, need: 100, tokens: 0, enough: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.bucket
+			b.last = start
+			b.refill(start.Add(tt.elapsed))
+			if b.tokens != tt.tokens {
+				t.Errorf("refill() tokens = %v, want %v", b.tokens, tt.tokens)
+			}
+			if got := b.enough(tt.need); got != tt.enough {
+				t.Errorf("enough(%v) = %v, want %v", tt.need, got, tt.enough)
+			}
+		})
+	}
+}
+
+func TestFloodGuard(t *testing.T) {
+	type send struct {
+		text    string
+		after   time.Duration // 距第一条消息的时间
+		verdict FloodVerdict
+		reason  string
+	}
+	tests := []struct {
+		name   string
+		config object.RateLimitConfig
+		sends  []send
+	}{
+		{
+			name:   "消息速率",
+			config: object.RateLimitConfig{MessagesPerSecond: 1, MessageBurst: 2},
+			sends: []send{
+				{text: "a", verdict: FloodAllow},
+				{text: "b", verdict: FloodAllow},
+				{text: "c", verdict: FloodThrottle, reason: "发送过快"},
+				{text: "d", after: time.Second, verdict: FloodAllow},
+			},
+		},
+		{
+			name:   "字节速率",
+			config: object.RateLimitConfig{BytesPerSecond: 10},
+			sends: []send{
+				{text: "12345678", verdict: FloodAllow},
+				{text: "123", verdict: FloodThrottle, reason: "发送的数据量过大"},
+				{text: "12", verdict: FloodAllow},
+			},
+		},
+		{
+			name:   "重复消息",
+			config: object.RateLimitConfig{RepeatLimit: 2},
+			sends: []send{
+				{text: "spam", verdict: FloodAllow},
+				{text: "spam", verdict: FloodAllow},
+				{text: "spam", verdict: FloodThrottle, reason: "请勿重复发送相同的消息"},
+				{text: "other", verdict: FloodAllow},
+				{text: "spam", verdict: FloodAllow},
+			},
+		},
+		{
+			name:   "警告与处罚",
+			config: object.RateLimitConfig{MessagesPerSecond: 1, WarnAfter: 2, PunishAfter: 3, Window: 60},
+			sends: []send{
+				{text: "a", verdict: FloodAllow},
+				{text: "b", verdict: FloodThrottle, reason: "发送过快"},
+				{text: "c", verdict: FloodWarn, reason: "发送过快"},
+				{text: "d", verdict: FloodPunish, reason: "发送过快"},
+				{text: "e", verdict: FloodThrottle, reason: "发送过快"},
+			},
+		},
+		{
+			name:   "超过时间窗口后重新计数",
+			config: object.RateLimitConfig{MessagesPerSecond: 1, WarnAfter: 2, Window: 10},
+			sends: []send{
+				{text: "a", verdict: FloodAllow},
+				{text: "b", verdict: FloodThrottle, reason: "发送过快"},
+				{text: "c", after: 11 * time.Second, verdict: FloodAllow},
+				{text: "d", after: 11 * time.Second, verdict: FloodThrottle, reason: "发送过快"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := NewRateLimit(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := limit.NewGuard()
+			start := g.msgs.last
+			for i, s := range tt.sends {
+				verdict, reason := g.Check(s.text, true, start.Add(s.after))
+				if verdict != s.verdict || reason != s.reason {
+					t.Errorf("第 %d 条 Check(%q) = %v, %q, want %v, %q", i+1, s.text, verdict, reason, s.verdict, s.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  object.RateLimitConfig
+		action  string
+		mute    time.Duration
+		wantErr bool
+	}{
+		{name: "默认自动禁言", config: object.RateLimitConfig{}, action: FloodMute, mute: defaultFloodMute},
+		{name: "禁言时长", config: object.RateLimitConfig{MuteDuration: "1h"}, action: FloodMute, mute: time.Hour},
+		{name: "断开连接", config: object.RateLimitConfig{Action: FloodDisconnect}, action: FloodDisconnect, mute: defaultFloodMute},
+		{name: "未知的处罚方式", config: object.RateLimitConfig{Action: "ban"}, wantErr: true},
+		{name: "禁言时长有误", config: object.RateLimitConfig{MuteDuration: "abc"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := NewRateLimit(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRateLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if limit.Action() != tt.action || limit.MuteDuration() != tt.mute {
+				t.Errorf("NewRateLimit() = %s, %v, want %s, %v", limit.Action(), limit.MuteDuration(), tt.action, tt.mute)
+			}
+		})
+	}
+}
+
+func TestFloodGuardNil(t *testing.T) {
+	var g *FloodGuard
+	if verdict, _ := g.Check("hello", true, time.Now()); verdict != FloodAllow {
+		t.Errorf("nil FloodGuard Check() = %v, want FloodAllow", verdict)
+	}
+	if got := g.Counters(); got != "-" {
+		t.Errorf("nil FloodGuard Counters() = %q, want -", got)
+	}
+}
+
+func TestFloodGuardLimit(t *testing.T) {
+	// 机器人按各自的限流策略处罚
+	human, _ := NewRateLimit(object.RateLimitConfig{MessagesPerSecond: 1, PunishAfter: 1, MuteDuration: "10m"})
+	bot, _ := NewRateLimit(object.RateLimitConfig{MessagesPerSecond: 5, PunishAfter: 1, MuteDuration: "2h"})
+	g := bot.NewGuard()
+	start := g.msgs.last
+	var verdict FloodVerdict
+	for i := 0; i < 6 && verdict != FloodPunish; i++ {
+		verdict, _ = g.Check(strconv.Itoa(i), false, start)
+	}
+	if verdict != FloodPunish {
+		t.Fatalf("Check() = %v, want FloodPunish", verdict)
+	}
+	if g.Limit() != bot || g.Limit().MuteDuration() != 2*time.Hour {
+		t.Errorf("Limit() = %v, want 机器人的限流策略", g.Limit().MuteDuration())
+	}
+	if human.NewGuard().Limit() != human {
+		t.Error("Limit() 不是创建限流器的策略")
+	}
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"easy-chat/server/object"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"os"
+)
+
+// Redis 部署方式
+const (
+	RedisStandalone = "standalone" // 单机
+	RedisSentinel   = "sentinel"   // 哨兵
+	RedisCluster    = "cluster"    // 集群
+)
+
+// newRedisClient 根据部署方式创建 Redis 客户端
+func newRedisClient(config object.Config) (redis.UniversalClient, error) {
+	tlsConfig, err := redisTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Redis.Mode {
+	case "", RedisStandalone:
+		return redis.NewClient(&redis.Options{
+			Addr:      config.Redis.Host + ":" + config.Redis.Port,
+			Password:  config.Redis.Pwd,
+			DB:        config.Redis.Db,
+			TLSConfig: tlsConfig,
+		}), nil
+	case RedisSentinel:
+		if config.Redis.MasterName == "" || len(config.Redis.SentinelAddrs) == 0 {
+			return nil, errors.New("哨兵模式需要配置 masterName 和 sentinelAddrs")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.Redis.MasterName,
+			SentinelAddrs:    config.Redis.SentinelAddrs,
+			SentinelPassword: config.Redis.SentinelPwd,
+			Password:         config.Redis.Pwd,
+			DB:               config.Redis.Db,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case RedisCluster:
+		if len(config.Redis.ClusterAddrs) == 0 {
+			return nil, errors.New("集群模式需要配置 clusterAddrs")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     config.Redis.ClusterAddrs,
+			Password:  config.Redis.Pwd,
+			TLSConfig: tlsConfig,
+		}), nil
+	default:
+		return nil, errors.New("未知的 Redis 部署方式: " + config.Redis.Mode)
+	}
+}
+
+// redisTLSConfig 连接 Redis 的 TLS 配置，未开启 TLS 时返回 nil
+func redisTLSConfig(config object.Config) (*tls.Config, error) {
+	if !config.Redis.TLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.Redis.TLSSkipVerify,
+	}
+	if config.Redis.TLSCA != "" {
+		pem, err := os.ReadFile(config.Redis.TLSCA)
+		if err != nil {
+			return nil, errors.New("读取 Redis CA 证书失败: " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("解析 Redis CA 证书失败")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// redisKeyPrefix 键前缀，集群模式下使用哈希标签，保证所有键落在同一个槽，多键操作可以正常执行
+func redisKeyPrefix(mode string, prefix string) string {
+	if mode == RedisCluster {
+		return "{" + prefix + "}"
+	}
+	return prefix
+}
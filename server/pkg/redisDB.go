@@ -9,79 +9,524 @@ import (
 	"time"
 )
 
+// 键名，实际使用时加上键前缀
+const (
+	keyMsgQueue      = "message_queue" // 消息队列，每个节点一个
+	keyPresence      = "presence"      // 集群在线用户 昵称->节点
+	keyNodeAlive     = "node_alive"    // 节点存活标记，每个节点一个，由心跳续期
+	channelBroadcast = "broadcast"     // 集群广播频道
+	keyHistory       = "history"       // 房间历史消息，每个房间一个
+)
+
+// queuePopTimeout 消息出队的最长阻塞时间，超时后重新检查 Redis 健康状态
+const queuePopTimeout = time.Second
+
+// 节点心跳：存活标记过期的节点视为已宕机，其登记的在线用户不再占用昵称
+const (
+	nodeAliveTTL      = 30 * time.Second
+	nodeAliveInterval = 10 * time.Second
+)
+
+// RedisHandler 基于 Redis 的存储，支持多节点部署
+// Redis 不可用时熔断，消息队列、广播与在线用户降级为本节点内部处理
 type RedisHandler struct {
-	rdb *redis.Client
+	rdb          redis.UniversalClient
+	prefix       string       // 键前缀，集群模式下带哈希标签
+	node         string       // 当前节点标识
+	historyLimit int          // 每个房间保留的历史消息条数
+	health       *redisHealth // 健康状态
+	fallback     *MemoryStore // 降级时使用的本地存储
+}
+
+// NewRedisHandler 创建 RedisHandler，键名为 前缀:命名空间:键
+func NewRedisHandler(config object.Config, namespace string) (*RedisHandler, error) {
+	rdb, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
+	prefix := config.Redis.Prefix
+	if prefix == "" {
+		prefix = "easy-chat"
+	}
+	if namespace != "" {
+		prefix += ":" + namespace
+	}
+	r := &RedisHandler{
+		rdb:          rdb,
+		prefix:       redisKeyPrefix(config.Redis.Mode, prefix),
+		node:         config.App.Node,
+		historyLimit: config.App.HistoryLimit,
+		health: newRedisHealth(config.Redis.BreakerThreshold,
+			time.Duration(config.Redis.RetryMin)*time.Millisecond,
+			time.Duration(config.Redis.RetryMax)*time.Millisecond),
+		fallback: NewMemoryStore(config),
+	}
+	go r.heartbeat()
+	return r, nil
 }
 
-// NewRedisHandler 创建 RedisHandler
-func NewRedisHandler(config object.Config) *RedisHandler {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     config.Redis.Host + ":" + config.Redis.Port,
-		Password: config.Redis.Pwd,
-		DB:       config.Redis.Db,
-	})
-	return &RedisHandler{
-		rdb: rdb,
+// key 拼接键名
+func (r *RedisHandler) key(parts ...string) string {
+	key := r.prefix
+	for _, p := range parts {
+		key += ":" + p
 	}
+	return key
+}
+
+// Node 当前节点标识
+func (r *RedisHandler) Node() string {
+	return r.node
 }
 
-// Clean 清理 redis 数据
+// Clean 清理当前节点的 redis 数据
 func (r *RedisHandler) Clean(ctx context.Context) error {
-	// 删除 message_queue 队列
-	err := r.rdb.Del(ctx, "easy-chat:message_queue").Err()
+	// 删除本节点的 message_queue 队列
+	err := r.track(r.rdb.Del(ctx, r.queueKey()).Err())
 	if err != nil {
 		return errors.New("清理消息队列失败: " + err.Error())
 	}
-	// 删除 user_activity 有序集合
-	err = r.rdb.Del(ctx, "easy-chat:user_activity").Err()
+	// 删除本节点残留的在线用户
+	presence, err := r.GetPresence(ctx)
 	if err != nil {
-		return errors.New("清理用户活跃度失败: " + err.Error())
+		return errors.New("清理在线用户失败: " + err.Error())
+	}
+	for nickName, node := range presence {
+		if node != r.node {
+			continue
+		}
+		err = r.DelPresence(ctx, nickName)
+		if err != nil {
+			return errors.New("清理在线用户失败: " + err.Error())
+		}
 	}
 	return nil
 }
 
-// MsgQueuePop 消息出队
-func (r *RedisHandler) MsgQueuePop(ctx context.Context) ([]string, error) {
-	result, err := r.rdb.BLPop(ctx, 0*time.Second, "easy-chat:message_queue").Result()
-	return result, err
+// queueKey 当前节点的消息队列
+func (r *RedisHandler) queueKey() string {
+	return r.key(keyMsgQueue, r.node)
+}
+
+// MsgQueuePop 消息出队，最多阻塞 queuePopTimeout，超时返回 ErrQueueEmpty
+func (r *RedisHandler) MsgQueuePop(ctx context.Context) (string, error) {
+	// 优先处理降级期间积压在本地的消息
+	select {
+	case msg := <-r.fallback.queue:
+		return msg, nil
+	default:
+	}
+	if !r.Healthy() {
+		popCtx, cancel := context.WithTimeout(ctx, queuePopTimeout)
+		defer cancel()
+		msg, err := r.fallback.MsgQueuePop(popCtx)
+		if err != nil && ctx.Err() == nil {
+			return "", ErrQueueEmpty
+		}
+		return msg, err
+	}
+	result, err := r.rdb.BLPop(ctx, queuePopTimeout, r.queueKey()).Result()
+	if errors.Is(r.track(err), redis.Nil) {
+		return "", ErrQueueEmpty
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(result) < 2 {
+		return "", errors.New("消息出队结果异常")
+	}
+	return result[1], nil
 }
 
-// MsgQueuePush 消息入队
+// MsgQueuePush 消息入队，Redis 不可用时进入本地队列
 func (r *RedisHandler) MsgQueuePush(ctx context.Context, msg string) error {
-	err := r.rdb.RPush(ctx, "easy-chat:message_queue", msg).Err()
-	return err
+	if r.Healthy() {
+		err := r.track(r.rdb.RPush(ctx, r.queueKey(), msg).Err())
+		if err == nil {
+			return nil
+		}
+	}
+	return r.fallback.MsgQueuePush(ctx, msg)
 }
 
-// AddScore 为指定用户添加分数
-func (r *RedisHandler) AddScore(ctx context.Context, nickName string) error {
-	err := r.rdb.ZIncrBy(ctx, "easy-chat:user_activity", 1, nickName).Err()
-	return err
+// Publish 向集群所有节点广播消息，Redis 不可用时只广播给本节点
+func (r *RedisHandler) Publish(ctx context.Context, msg string) error {
+	if r.Healthy() {
+		err := r.track(r.rdb.Publish(ctx, r.key(channelBroadcast), msg).Err())
+		if err == nil {
+			return nil
+		}
+	}
+	return r.fallback.Publish(ctx, msg)
+}
+
+// Subscribe 订阅集群广播消息
+// Redis 不可用时先只转发本节点的广播，并按指数退避在后台重新订阅
+func (r *RedisHandler) Subscribe(ctx context.Context) (<-chan string, error) {
+	// 降级期间的本地广播
+	local, _ := r.fallback.Subscribe(ctx)
+	// 等待订阅确认，保证之后发布的消息都能收到
+	sub, err := r.subscribeRemote(ctx)
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		var remote <-chan *redis.Message
+		var retry <-chan time.Time
+		backoff := r.health.minBackoff
+		if err == nil {
+			defer sub.Close()
+			remote = sub.Channel()
+		} else {
+			retry = time.After(backoff)
+		}
+		for {
+			select {
+			case <-retry:
+				sub, err = r.subscribeRemote(ctx)
+				if err != nil {
+					backoff = min(backoff*2, r.health.maxBackoff)
+					retry = time.After(backoff)
+					continue
+				}
+				defer sub.Close()
+				remote = sub.Channel()
+				retry = nil
+			case m, ok := <-remote:
+				if !ok {
+					return
+				}
+				ch <- m.Payload
+			case msg := <-local:
+				ch <- msg
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// subscribeRemote 订阅 Redis 广播频道并等待订阅确认
+func (r *RedisHandler) subscribeRemote(ctx context.Context) (*redis.PubSub, error) {
+	sub := r.rdb.Subscribe(ctx, r.key(channelBroadcast))
+	_, err := sub.Receive(ctx)
+	if r.track(err) != nil {
+		_ = sub.Close()
+		return nil, errors.New("订阅广播频道失败: " + err.Error())
+	}
+	return sub, nil
+}
+
+// AddPresence 登记在线用户，昵称已被集群中其他用户占用时返回 false
+// 占用昵称的节点已宕机(存活标记过期)时接管该昵称
+func (r *RedisHandler) AddPresence(ctx context.Context, nickName string) (bool, error) {
+	if !r.Healthy() {
+		// 降级期间只能保证本节点内昵称唯一，恢复后重新登记
+		return r.fallback.AddPresence(ctx, nickName)
+	}
+	key := r.key(keyPresence)
+	ok := false
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			owner, err := tx.HGet(ctx, key, nickName).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if err == nil {
+				alive, err := tx.Exists(ctx, r.key(keyNodeAlive, owner)).Result()
+				if err != nil {
+					return err
+				}
+				if owner == r.node || alive > 0 {
+					ok = false
+					return nil
+				}
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, nickName, r.node)
+				return nil
+			})
+			ok = err == nil
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if r.track(err) != nil {
+		return false, errors.New("登记在线用户失败: " + err.Error())
+	}
+	if ok {
+		// 本地保留一份，用于心跳续期与 Redis 恢复后重新登记
+		_, _ = r.fallback.AddPresence(ctx, nickName)
+	}
+	return ok, nil
 }
 
-// ShowRank 查看分数排行榜
-func (r *RedisHandler) ShowRank(ctx context.Context) (string, error) {
-	zs, err := r.rdb.ZRevRangeWithScores(ctx, "easy-chat:user_activity", 0, -1).Result()
+// DelPresence 注销在线用户
+func (r *RedisHandler) DelPresence(ctx context.Context, nickName string) error {
+	_ = r.fallback.DelPresence(ctx, nickName)
+	if !r.Healthy() {
+		return nil
+	}
+	return r.track(r.rdb.HDel(ctx, r.key(keyPresence), nickName).Err())
+}
+
+// GetPresence 获取集群在线用户，昵称->节点，已宕机节点登记的用户不计入
+func (r *RedisHandler) GetPresence(ctx context.Context) (map[string]string, error) {
+	if !r.Healthy() {
+		return r.fallback.GetPresence(ctx)
+	}
+	presence, err := r.rdb.HGetAll(ctx, r.key(keyPresence)).Result()
+	if r.track(err) != nil {
+		return nil, err
+	}
+	alive := map[string]bool{r.node: true}
+	for _, node := range presence {
+		if _, ok := alive[node]; ok {
+			continue
+		}
+		n, err := r.rdb.Exists(ctx, r.key(keyNodeAlive, node)).Result()
+		if r.track(err) != nil {
+			return nil, err
+		}
+		alive[node] = n > 0
+	}
+	// 清理已宕机节点的登记，节点实际存活时由其心跳重新登记
+	var dead []string
+	for nickName, node := range presence {
+		if !alive[node] {
+			dead = append(dead, nickName)
+			delete(presence, nickName)
+		}
+	}
+	if len(dead) > 0 {
+		_ = r.track(r.rdb.HDel(ctx, r.key(keyPresence), dead...).Err())
+	}
+	return presence, nil
+}
+
+// heartbeat 定期续期本节点的存活标记，并重新登记本节点的在线用户
+// 网络短暂中断期间昵称被其他节点接管时，重新登记失败的用户保持在线，由登录时的唯一性检查兜底
+func (r *RedisHandler) heartbeat() {
+	ticker := time.NewTicker(nodeAliveInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		if !r.Healthy() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), nodeAliveInterval)
+		presence, _ := r.fallback.GetPresence(ctx)
+		pipe := r.rdb.Pipeline()
+		pipe.Set(ctx, r.key(keyNodeAlive, r.node), time.Now().Unix(), nodeAliveTTL)
+		for nickName := range presence {
+			pipe.HSetNX(ctx, r.key(keyPresence), nickName, r.node)
+		}
+		_, err := pipe.Exec(ctx)
+		cancel()
+		_ = r.track(err)
+	}
+}
+
+// ZIncrBy 为有序集合成员加分
+func (r *RedisHandler) ZIncrBy(ctx context.Context, key string, member string, delta float64, ttl time.Duration) error {
+	if !r.Healthy() {
+		return ErrRedisDown
+	}
+	pipe := r.rdb.TxPipeline()
+	pipe.ZIncrBy(ctx, r.key(key), delta, member)
+	if ttl > 0 {
+		pipe.Expire(ctx, r.key(key), ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return r.track(err)
+}
+
+// ZRevRange 按分数从高到低获取有序集合前 n 个成员
+func (r *RedisHandler) ZRevRange(ctx context.Context, key string, n int) ([]RankItem, error) {
+	if !r.Healthy() {
+		return nil, ErrRedisDown
+	}
+	zs, err := r.rdb.ZRevRangeWithScores(ctx, r.key(key), 0, int64(n-1)).Result()
+	if r.track(err) != nil {
+		return nil, err
+	}
+	items := make([]RankItem, 0, len(zs))
+	for _, z := range zs {
+		items = append(items, RankItem{Member: fmt.Sprint(z.Member), Score: z.Score})
+	}
+	return items, nil
+}
+
+// ZScore 获取有序集合成员的分数
+func (r *RedisHandler) ZScore(ctx context.Context, key string, member string) (float64, bool, error) {
+	if !r.Healthy() {
+		return 0, false, ErrRedisDown
+	}
+	score, err := r.rdb.ZScore(ctx, r.key(key), member).Result()
+	if errors.Is(r.track(err), redis.Nil) {
+		return 0, false, nil
+	}
 	if err != nil {
-		return "", errors.New("获取用户活跃度失败: " + err.Error())
+		return 0, false, err
+	}
+	return score, true, nil
+}
+
+// ZRem 删除有序集合成员
+func (r *RedisHandler) ZRem(ctx context.Context, key string, member string) error {
+	if !r.Healthy() {
+		return ErrRedisDown
+	}
+	return r.track(r.rdb.ZRem(ctx, r.key(key), member).Err())
+}
+
+// AddHistory 记录房间历史消息
+func (r *RedisHandler) AddHistory(ctx context.Context, room string, msg string) error {
+	if !r.Healthy() {
+		return ErrRedisDown
 	}
+	key := r.key(keyHistory, room)
+	pipe := r.rdb.TxPipeline()
+	pipe.RPush(ctx, key, msg)
+	if r.historyLimit > 0 {
+		pipe.LTrim(ctx, key, int64(-r.historyLimit), -1)
+	}
+	_, err := pipe.Exec(ctx)
+	return r.track(err)
+}
 
-	// 检查有序集合是否为空
-	if len(zs) == 0 {
-		return "", errors.New("排行榜为空")
+// History 获取房间最近 n 条历史消息
+func (r *RedisHandler) History(ctx context.Context, room string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
 	}
+	if !r.Healthy() {
+		return nil, ErrRedisDown
+	}
+	history, err := r.rdb.LRange(ctx, r.key(keyHistory, room), int64(-n), -1).Result()
+	return history, r.track(err)
+}
 
-	// 返回排名、用户和分数
-	msg := "用户活跃度排行榜:\n"
-	for i, z := range zs {
-		msg += fmt.Sprintf("%d. %s  积分: %.0f", i+1, z.Member, z.Score)
-		if i < len(zs)-1 {
-			msg += "\n"
+// GetHistory 按消息编号获取房间的历史消息
+func (r *RedisHandler) GetHistory(ctx context.Context, room string, id string) (string, bool, error) {
+	if !r.Healthy() {
+		return "", false, ErrRedisDown
+	}
+	history, err := r.rdb.LRange(ctx, r.key(keyHistory, room), 0, -1).Result()
+	if err = r.track(err); err != nil {
+		return "", false, err
+	}
+	for _, msg := range history {
+		if historyID(msg) == id {
+			return msg, true, nil
 		}
 	}
-	return msg, nil
+	return "", false, nil
+}
+
+// SetHistory 按消息编号替换房间的历史消息，在 WATCH 事务中定位并写入，
+// 期间有新消息写入导致下标变化时重试
+func (r *RedisHandler) SetHistory(ctx context.Context, room string, id string, msg string) (bool, error) {
+	if !r.Healthy() {
+		return false, ErrRedisDown
+	}
+	key := r.key(keyHistory, room)
+	found := false
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			history, err := tx.LRange(ctx, key, 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			index := -1
+			for i, old := range history {
+				if historyID(old) == id {
+					index = i
+					break
+				}
+			}
+			found = index >= 0
+			if !found {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.LSet(ctx, key, int64(index), msg)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	return found, r.track(err)
+}
+
+// HashSet 设置哈希字段
+func (r *RedisHandler) HashSet(ctx context.Context, key string, field string, value string) error {
+	if !r.Healthy() {
+		return ErrRedisDown
+	}
+	return r.track(r.rdb.HSet(ctx, r.key(key), field, value).Err())
 }
 
-// DelUserFromRank 将用户从排行榜删除
-func (r *RedisHandler) DelUserFromRank(ctx context.Context, nickname string) {
-	r.rdb.ZRem(ctx, "easy-chat:user_activity", nickname)
+// HashSetNX 哈希字段不存在时设置，已存在时返回 false
+func (r *RedisHandler) HashSetNX(ctx context.Context, key string, field string, value string) (bool, error) {
+	if !r.Healthy() {
+		return false, ErrRedisDown
+	}
+	ok, err := r.rdb.HSetNX(ctx, r.key(key), field, value).Result()
+	return ok, r.track(err)
+}
+
+// HashDel 删除哈希字段
+func (r *RedisHandler) HashDel(ctx context.Context, key string, field string) error {
+	if !r.Healthy() {
+		return ErrRedisDown
+	}
+	return r.track(r.rdb.HDel(ctx, r.key(key), field).Err())
+}
+
+// HashGet 获取哈希字段
+func (r *RedisHandler) HashGet(ctx context.Context, key string, field string) (string, bool, error) {
+	if !r.Healthy() {
+		return "", false, ErrRedisDown
+	}
+	value, err := r.rdb.HGet(ctx, r.key(key), field).Result()
+	if errors.Is(r.track(err), redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// HashGetAll 获取哈希所有字段
+func (r *RedisHandler) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	if !r.Healthy() {
+		return nil, ErrRedisDown
+	}
+	values, err := r.rdb.HGetAll(ctx, r.key(key)).Result()
+	return values, r.track(err)
+}
+
+// HashIncr 为哈希字段加上整数增量并返回新值
+func (r *RedisHandler) HashIncr(ctx context.Context, key string, field string, delta int64, ttl time.Duration) (int64, error) {
+	if !r.Healthy() {
+		return 0, ErrRedisDown
+	}
+	pipe := r.rdb.TxPipeline()
+	incr := pipe.HIncrBy(ctx, r.key(key), field, delta)
+	if ttl > 0 {
+		pipe.Expire(ctx, r.key(key), ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	if r.track(err) != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
 }
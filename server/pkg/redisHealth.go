@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+// ErrRedisDown Redis 熔断期间直接返回的错误
+var ErrRedisDown = errors.New("redis 暂不可用")
+
+// redisHealth Redis 健康状态
+// 连续失败达到阈值后熔断，熔断期间按指数退避探测，探测成功后恢复
+type redisHealth struct {
+	mu         sync.Mutex
+	failures   int           // 连续失败次数
+	threshold  int           // 熔断阈值
+	open       bool          // 是否处于熔断状态
+	minBackoff time.Duration // 首次探测间隔
+	maxBackoff time.Duration // 最大探测间隔
+	onChange   func(healthy bool, err error)
+}
+
+// newRedisHealth 创建健康状态
+func newRedisHealth(threshold int, minBackoff time.Duration, maxBackoff time.Duration) *redisHealth {
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if minBackoff <= 0 {
+		minBackoff = 500 * time.Millisecond
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = 30 * time.Second
+	}
+	return &redisHealth{
+		threshold:  threshold,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// healthy 是否可以访问 Redis
+func (h *redisHealth) healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.open
+}
+
+// success 记录一次成功
+func (h *redisHealth) success() {
+	h.mu.Lock()
+	h.failures = 0
+	h.mu.Unlock()
+}
+
+// failure 记录一次失败，达到阈值时熔断并返回 true
+func (h *redisHealth) failure() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.open {
+		return false
+	}
+	h.failures++
+	if h.failures < h.threshold {
+		return false
+	}
+	h.open = true
+	return true
+}
+
+// recover 解除熔断
+func (h *redisHealth) recover() {
+	h.mu.Lock()
+	h.open = false
+	h.failures = 0
+	h.mu.Unlock()
+}
+
+// notify 通知健康状态变化
+func (h *redisHealth) notify(healthy bool, err error) {
+	h.mu.Lock()
+	fn := h.onChange
+	h.mu.Unlock()
+	if fn != nil {
+		fn(healthy, err)
+	}
+}
+
+// OnHealthChange 注册 Redis 健康状态变化回调
+func (r *RedisHandler) OnHealthChange(fn func(healthy bool, err error)) {
+	r.health.mu.Lock()
+	r.health.onChange = fn
+	r.health.mu.Unlock()
+}
+
+// Healthy Redis 是否可用
+func (r *RedisHandler) Healthy() bool {
+	return r.health.healthy()
+}
+
+// track 根据命令结果更新健康状态，返回原错误
+func (r *RedisHandler) track(err error) error {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		r.health.success()
+		return err
+	}
+	if r.health.failure() {
+		r.health.notify(false, err)
+		go r.probe()
+	}
+	return err
+}
+
+// probe 熔断期间按指数退避探测 Redis，恢复后把降级期间的在线用户重新登记到 Redis
+func (r *RedisHandler) probe() {
+	backoff := r.health.minBackoff
+	for {
+		time.Sleep(backoff)
+		ctx, cancel := context.WithTimeout(context.Background(), backoff)
+		err := r.rdb.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			break
+		}
+		backoff *= 2
+		if backoff > r.health.maxBackoff {
+			backoff = r.health.maxBackoff
+		}
+	}
+	ctx := context.Background()
+	presence, _ := r.fallback.GetPresence(ctx)
+	for nickName := range presence {
+		_ = r.rdb.HSetNX(ctx, r.key(keyPresence), nickName, r.node).Err()
+	}
+	r.health.recover()
+	r.health.notify(true, nil)
+}
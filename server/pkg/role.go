@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 角色
+const (
+	RoleAdmin     = "admin"     // 管理员
+	RoleModerator = "moderator" // 版主
+	RoleMember    = "member"    // 注册用户
+	RoleGuest     = "guest"     // 游客
+)
+
+// 权限
+const (
+	PermKick       = "kick"       // 踢出用户
+	PermMute       = "mute"       // 禁言用户
+	PermBan        = "ban"        // 封禁用户、IP 或网段
+	PermTopic      = "topic"      // 设置房间话题
+	PermCreateRoom = "createRoom" // 创建房间
+	PermAnnounce   = "announce"   // 发送公告
+	PermAudit      = "audit"      // 查看审计日志
+	PermDelete     = "delete"     // 删除他人的消息
+	PermStats      = "stats"      // 通过管理接口查看统计数据
+)
+
+// keyRoles 角色哈希 昵称->角色，只保存注册用户被指定的角色
+const keyRoles = "roles"
+
+// permissionMatrix 各角色拥有的权限
+var permissionMatrix = map[string]map[string]bool{
+	RoleAdmin: {
+		PermKick: true, PermMute: true, PermBan: true, PermTopic: true, PermCreateRoom: true, PermAnnounce: true, PermAudit: true, PermDelete: true, PermStats: true,
+	},
+	RoleModerator: {
+		PermKick: true, PermMute: true, PermBan: true, PermTopic: true, PermCreateRoom: true, PermAudit: true, PermDelete: true, PermStats: true,
+	},
+	RoleMember: {
+		PermCreateRoom: true,
+	},
+	RoleGuest: {},
+}
+
+// commandPermissions 需要权限的客户端命令及所需的权限
+var commandPermissions = map[string]string{
+	"/topic":    PermTopic,
+	"/announce": PermAnnounce,
+	"/kick":     PermKick,
+	"/mute":     PermMute,
+	"/unmute":   PermMute,
+	"/ban":      PermBan,
+	"/unban":    PermBan,
+	"/bans":     PermBan,
+}
+
+// roleNames 角色的显示名称
+var roleNames = map[string]string{
+	RoleAdmin:     "管理员",
+	RoleModerator: "版主",
+	RoleMember:    "注册用户",
+	RoleGuest:     "游客",
+}
+
+// roleLevels 角色级别，管理操作只能作用于级别更低的用户
+var roleLevels = map[string]int{
+	RoleAdmin:     3,
+	RoleModerator: 2,
+	RoleMember:    1,
+	RoleGuest:     0,
+}
+
+// RoleLevel 角色级别
+func RoleLevel(role string) int {
+	return roleLevels[role]
+}
+
+// CanModerate 检查操作者能否处理角色为 targetRole 的用户，不能处理自己，只能处理角色级别更低的用户，不能处理时返回原因
+func CanModerate(actor string, actorRole string, target string, targetRole string) string {
+	if target == actor {
+		return "不能对自己执行该操作"
+	}
+	if RoleLevel(targetRole) >= RoleLevel(actorRole) {
+		return "权限不足，不能处理" + RoleName(targetRole)
+	}
+	return ""
+}
+
+// CanRevoke 检查操作者能否撤销 by 执行的处罚，只能撤销自己或角色级别更低的用户执行的处罚，不能撤销时返回原因
+func CanRevoke(actor string, actorRole string, by string, byRole string) string {
+	if by == actor {
+		return ""
+	}
+	if RoleLevel(byRole) >= RoleLevel(actorRole) {
+		return "权限不足，不能撤销" + RoleName(byRole) + " " + by + " 执行的处罚"
+	}
+	return ""
+}
+
+// IsRole 是否为有效的角色
+func IsRole(role string) bool {
+	_, ok := permissionMatrix[role]
+	return ok
+}
+
+// RoleName 角色的显示名称
+func RoleName(role string) string {
+	if name, ok := roleNames[role]; ok {
+		return name
+	}
+	return role
+}
+
+// HasPermission 角色是否拥有权限
+func HasPermission(role string, perm string) bool {
+	return permissionMatrix[role][perm]
+}
+
+// CommandPermission 客户端命令所需的权限，不需要权限时 ok 为 false
+func CommandPermission(cmd string) (perm string, ok bool) {
+	perm, ok = commandPermissions[cmd]
+	return perm, ok
+}
+
+// CheckPermission 检查角色是否拥有权限，没有权限时返回原因
+func CheckPermission(role string, perm string) string {
+	if !HasPermission(role, perm) {
+		return "权限不足，" + RoleName(role) + "没有 " + perm + " 权限"
+	}
+	return ""
+}
+
+// GetRole 获取用户角色，游客固定为 guest，未指定角色的注册用户为 member
+func GetRole(ctx context.Context, s Store, nickName string, registered bool) (string, error) {
+	if !registered {
+		return RoleGuest, nil
+	}
+	role, ok, err := s.HashGet(ctx, keyRoles, nickName)
+	if err != nil {
+		return "", errors.New("获取用户角色失败: " + err.Error())
+	}
+	if ok && IsRole(role) {
+		return role, nil
+	}
+	return RoleMember, nil
+}
+
+// SetRole 为注册用户指定角色，指定为 member 时删除记录
+func SetRole(ctx context.Context, s Store, nickName string, role string) error {
+	if !IsRole(role) || role == RoleGuest {
+		return errors.New("无效的角色: " + role + "，可选 admin、moderator、member")
+	}
+	account, err := GetAccount(ctx, s, nickName)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return errors.New("用户 " + nickName + " 未注册，只能为注册用户指定角色")
+	}
+	if role == RoleMember {
+		err = s.HashDel(ctx, keyRoles, nickName)
+	} else {
+		err = s.HashSet(ctx, keyRoles, nickName, role)
+	}
+	if err != nil {
+		return errors.New("保存用户角色失败: " + err.Error())
+	}
+	return nil
+}
+
+// ShowPermissions 查看权限矩阵
+func ShowPermissions() string {
+	perms := []string{PermKick, PermMute, PermBan, PermTopic, PermCreateRoom, PermAnnounce, PermAudit, PermDelete, PermStats}
+	roles := []string{RoleAdmin, RoleModerator, RoleMember, RoleGuest}
+	msg := "权限矩阵:"
+	for _, role := range roles {
+		granted := make([]string, 0, len(perms))
+		for _, perm := range perms {
+			if HasPermission(role, perm) {
+				granted = append(granted, perm)
+			}
+		}
+		sort.Strings(granted)
+		if len(granted) == 0 {
+			granted = append(granted, "-")
+		}
+		msg += fmt.Sprintf("\n%-10s %s", role, strings.Join(granted, ", "))
+	}
+	return msg
+}
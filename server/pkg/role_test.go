@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/server/object"
+	"testing"
+)
+
+func TestCommandPermission(t *testing.T) {
+	// 各角色能否执行命令，未列出的角色不能执行
+	tests := []struct {
+		cmd     string
+		allowed []string
+	}{
+		{cmd: "/topic", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/announce", allowed: []string{RoleAdmin}},
+		{cmd: "/kick", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/mute", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/unmute", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/ban", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/unban", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/bans", allowed: []string{RoleAdmin, RoleModerator}},
+		{cmd: "/help", allowed: []string{RoleAdmin, RoleModerator, RoleMember, RoleGuest}},
+		{cmd: "/nick", allowed: []string{RoleAdmin, RoleModerator, RoleMember, RoleGuest}},
+		{cmd: "/register", allowed: []string{RoleAdmin, RoleModerator, RoleMember, RoleGuest}},
+	}
+	for _, tt := range tests {
+		allowed := make(map[string]bool)
+		for _, role := range tt.allowed {
+			allowed[role] = true
+		}
+		for _, role := range []string{RoleAdmin, RoleModerator, RoleMember, RoleGuest} {
+			reason := ""
+			if perm, ok := CommandPermission(tt.cmd); ok {
+				reason = CheckPermission(role, perm)
+			}
+			if got := reason == ""; got != allowed[role] {
+				t.Errorf("%s 执行 %s = %v (%q), want %v", role, tt.cmd, got, reason, allowed[role])
+			}
+		}
+	}
+}
+
+func TestCanModerate(t *testing.T) {
+	tests := []struct {
+		name       string
+		actor      string
+		actorRole  string
+		target     string
+		targetRole string
+		allowed    bool
+	}{
+		{name: "版主解除自己的禁言", actor: "mod", actorRole: RoleModerator, target: "mod", targetRole: RoleModerator},
+		{name: "管理员处理自己", actor: "root", actorRole: RoleAdmin, target: "root", targetRole: RoleAdmin},
+		{name: "版主处理管理员", actor: "mod", actorRole: RoleModerator, target: "root", targetRole: RoleAdmin},
+		{name: "版主处理其他版主", actor: "mod", actorRole: RoleModerator, target: "mod2", targetRole: RoleModerator},
+		{name: "版主处理注册用户", actor: "mod", actorRole: RoleModerator, target: "alice", targetRole: RoleMember, allowed: true},
+		{name: "版主处理游客", actor: "mod", actorRole: RoleModerator, target: "guest", targetRole: RoleGuest, allowed: true},
+		{name: "管理员处理版主", actor: "root", actorRole: RoleAdmin, target: "mod", targetRole: RoleModerator, allowed: true},
+	}
+	for _, tt := range tests {
+		reason := CanModerate(tt.actor, tt.actorRole, tt.target, tt.targetRole)
+		if got := reason == ""; got != tt.allowed {
+			t.Errorf("%s: CanModerate() = %q, want allowed %v", tt.name, reason, tt.allowed)
+		}
+	}
+}
+
+func TestCanRevoke(t *testing.T) {
+	tests := []struct {
+		name      string
+		actor     string
+		actorRole string
+		by        string
+		byRole    string
+		allowed   bool
+	}{
+		{name: "版主解除管理员的封禁", actor: "mod", actorRole: RoleModerator, by: "root", byRole: RoleAdmin},
+		{name: "版主解除其他版主的封禁", actor: "mod", actorRole: RoleModerator, by: "mod2", byRole: RoleModerator},
+		{name: "版主解除自己的封禁", actor: "mod", actorRole: RoleModerator, by: "mod", byRole: RoleModerator, allowed: true},
+		{name: "版主解除已降级用户的封禁", actor: "mod", actorRole: RoleModerator, by: "alice", byRole: RoleMember, allowed: true},
+		{name: "管理员解除版主的封禁", actor: "root", actorRole: RoleAdmin, by: "mod", byRole: RoleModerator, allowed: true},
+		{name: "管理员解除其他管理员的封禁", actor: "root", actorRole: RoleAdmin, by: "root2", byRole: RoleAdmin},
+	}
+	for _, tt := range tests {
+		reason := CanRevoke(tt.actor, tt.actorRole, tt.by, tt.byRole)
+		if got := reason == ""; got != tt.allowed {
+			t.Errorf("%s: CanRevoke() = %q, want allowed %v", tt.name, reason, tt.allowed)
+		}
+	}
+}
+
+func TestGetRole(t *testing.T) {
+	ctx := context.Background()
+	var config object.Config
+	config.Account.Auth = AuthMixed
+	config.Account.HashIterations = 1
+	s := NewMemoryStore(config)
+	p, err := NewAccountPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nick := range []string{"root", "alice"} {
+		if reason, err := p.Register(ctx, s, nick, "secret1"); err != nil || reason != "" {
+			t.Fatalf("Register(%s) = %q, %v", nick, reason, err)
+		}
+	}
+	if err := SetRole(ctx, s, "root", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRole(ctx, s, "bob", RoleModerator); err == nil {
+		t.Error("SetRole() 为未注册用户指定了角色")
+	}
+	tests := []struct {
+		nick       string
+		registered bool
+		want       string
+	}{
+		{nick: "root", registered: true, want: RoleAdmin},
+		{nick: "alice", registered: true, want: RoleMember},
+		{nick: "root", registered: false, want: RoleGuest},
+	}
+	for _, tt := range tests {
+		if got, err := GetRole(ctx, s, tt.nick, tt.registered); err != nil || got != tt.want {
+			t.Errorf("GetRole(%s, %v) = %s, %v, want %s", tt.nick, tt.registered, got, err, tt.want)
+		}
+	}
+	// 指定为 member 时删除记录
+	if err := SetRole(ctx, s, "root", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := GetRole(ctx, s, "root", true); got != RoleMember {
+		t.Errorf("SetRole(member) 后 GetRole() = %s, want member", got)
+	}
+}
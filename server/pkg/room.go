@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/proto"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultRoom 默认房间
+const DefaultRoom = "lobby"
+
+// roomKey 房间元数据的哈希键
+func roomKey(room string) string {
+	return "room:" + room
+}
+
+// SetRoomMeta 设置房间元数据
+func SetRoomMeta(ctx context.Context, s Store, room string, field string, value string) error {
+	err := s.HashSet(ctx, roomKey(room), field, value)
+	if err != nil {
+		return errors.New("保存房间信息失败: " + err.Error())
+	}
+	return nil
+}
+
+// GetRoomMeta 获取房间元数据
+func GetRoomMeta(ctx context.Context, s Store, room string) (map[string]string, error) {
+	meta, err := s.HashGetAll(ctx, roomKey(room))
+	if err != nil {
+		return nil, errors.New("获取房间信息失败: " + err.Error())
+	}
+	return meta, nil
+}
+
+// ShowRoom 查看房间信息
+func ShowRoom(ctx context.Context, s Store, room string) (string, error) {
+	meta, err := GetRoomMeta(ctx, s, room)
+	if err != nil {
+		return "", err
+	}
+	fields := make([]string, 0, len(meta))
+	for k := range meta {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	msg := fmt.Sprintf("房间 %s 信息:", room)
+	for _, k := range fields {
+		msg += fmt.Sprintf("\n%s: %s", k, meta[k])
+	}
+	return msg, nil
+}
+
+// ShowHistory 查看房间最近 n 条历史消息
+func ShowHistory(ctx context.Context, s Store, room string, n int) (string, error) {
+	history, err := s.History(ctx, room, n)
+	if err != nil {
+		return "", errors.New("获取历史消息失败: " + err.Error())
+	}
+	if len(history) == 0 {
+		return "", errors.New("暂无历史消息")
+	}
+	frames := make([]proto.Frame, len(history))
+	parsed := make([]bool, len(history))
+	for i, h := range history {
+		frame, err := proto.ParseFrame(h)
+		if err != nil {
+			continue
+		}
+		frames[i], parsed[i] = frame, true
+	}
+	FillQuotes(ctx, s, room, frames)
+	lines := make([]string, 0, len(history))
+	for i, h := range history {
+		if !parsed[i] {
+			lines = append(lines, h)
+			continue
+		}
+		lines = append(lines, frames[i].String())
+	}
+	return fmt.Sprintf("房间 %s 最近 %d 条消息:\n", room, len(history)) + strings.Join(lines, "\n"), nil
+}
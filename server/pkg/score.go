@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// decayEpoch 时间衰减的基准时间
+// 采用前向衰减：加分时乘以 2^((t-基准)/半衰期)，越新的活跃度权重越高，展示时再除以当前时刻的系数
+// 为避免系数随时间溢出，每经过 decayGeneration 个半衰期开始新的一代，分数按所在代的起点加权并写入该代的排行榜
+var decayEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// decayGeneration 每一代包含的半衰期个数，代内的加权系数不超过 2^32
+// 上一代的分数在本代中只剩不到 2^-32，查看排行榜时只合并本代与上一代
+const decayGeneration = 32
+
+// scoreBucket 消息长度分档
+type scoreBucket struct {
+	maxLen int     // 长度上限(字符数)
+	score  float64 // 该档分数
+}
+
+// ScorePolicy 活跃度计分策略
+type ScorePolicy struct {
+	joinWeight         float64
+	buckets            []scoreBucket
+	activeMinuteWeight float64
+	replyWeight        float64
+	moderatedPenalty   float64
+	halfLife           time.Duration
+
+	mu         sync.Mutex
+	lastMinute map[string]int64 // 用户 -> 最近一次活跃的分钟
+}
+
+// NewScorePolicy 按配置创建计分策略
+func NewScorePolicy(config object.Config) (*ScorePolicy, error) {
+	c := config.Score
+	p := &ScorePolicy{
+		joinWeight:         c.JoinWeight,
+		activeMinuteWeight: c.ActiveMinuteWeight,
+		replyWeight:        c.ReplyWeight,
+		moderatedPenalty:   c.ModeratedPenalty,
+		halfLife:           time.Duration(c.HalfLife * float64(time.Hour)),
+		lastMinute:         make(map[string]int64),
+	}
+	for _, b := range c.LengthBuckets {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		parts := strings.SplitN(b, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("消息长度分档格式错误: " + b)
+		}
+		maxLen, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, errors.New("消息长度分档格式错误: " + b)
+		}
+		score, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, errors.New("消息长度分档格式错误: " + b)
+		}
+		p.buckets = append(p.buckets, scoreBucket{maxLen: maxLen, score: score})
+	}
+	sort.Slice(p.buckets, func(i, j int) bool {
+		return p.buckets[i].maxLen < p.buckets[j].maxLen
+	})
+	return p, nil
+}
+
+// userKey 用户在计分策略中的标识
+func userKey(workspace string, nickName string) string {
+	return workspace + "/" + nickName
+}
+
+// Join 用户加入的得分
+func (p *ScorePolicy) Join() float64 {
+	return p.joinWeight
+}
+
+// Message 发送消息的得分：按消息长度分档计分，每个新的活跃分钟额外加分
+func (p *ScorePolicy) Message(workspace string, nickName string, text string, now time.Time) float64 {
+	score := 1.0
+	if len(p.buckets) > 0 {
+		length := utf8.RuneCountInString(text)
+		score = p.buckets[len(p.buckets)-1].score
+		for _, b := range p.buckets {
+			if length <= b.maxLen {
+				score = b.score
+				break
+			}
+		}
+	}
+	minute := now.Unix() / 60
+	key := userKey(workspace, nickName)
+	p.mu.Lock()
+	if p.lastMinute[key] != minute {
+		p.lastMinute[key] = minute
+		score += p.activeMinuteWeight
+	}
+	p.mu.Unlock()
+	return score
+}
+
+// Reply 消息被回复时作者的得分
+func (p *ScorePolicy) Reply() float64 {
+	return p.replyWeight
+}
+
+// Moderated 消息被审核处理时的扣分
+func (p *ScorePolicy) Moderated() float64 {
+	return -p.moderatedPenalty
+}
+
+// Forget 用户离开后清理计分状态
+func (p *ScorePolicy) Forget(workspace string, nickName string) {
+	p.mu.Lock()
+	delete(p.lastMinute, userKey(workspace, nickName))
+	p.mu.Unlock()
+}
+
+// decays 是否开启时间衰减
+func (p *ScorePolicy) decays() bool {
+	return p != nil && p.halfLife > 0
+}
+
+// generation 时刻 t 所在的代，不衰减时总是 0
+func (p *ScorePolicy) generation(t time.Time) int64 {
+	if !p.decays() {
+		return 0
+	}
+	return int64(t.Sub(decayEpoch) / (p.halfLife * decayGeneration))
+}
+
+// factor 时刻 t 相对于第 gen 代起点的加权系数
+func (p *ScorePolicy) factor(gen int64, t time.Time) float64 {
+	if !p.decays() {
+		return 1
+	}
+	start := decayEpoch.Add(time.Duration(gen) * p.halfLife * decayGeneration)
+	return math.Exp2(float64(t.Sub(start)) / float64(p.halfLife))
+}
+
+// genKey 第 gen 代的排行榜键，不衰减时不分代
+func (p *ScorePolicy) genKey(key string, gen int64) string {
+	if !p.decays() {
+		return key
+	}
+	return key + ":g" + strconv.FormatInt(gen, 10)
+}
+
+// genTTL 总榜每一代的保留时间，保证上一代在本代结束前仍可读取，不衰减时不过期
+func (p *ScorePolicy) genTTL() time.Duration {
+	if !p.decays() {
+		return 0
+	}
+	return 2 * p.halfLife * decayGeneration
+}
@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScoreGeneration(t *testing.T) {
+	var config object.Config
+	config.Score.HalfLife = 1
+	p, err := NewScorePolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		after  time.Duration // 距基准时间
+		gen    int64
+		factor float64
+	}{
+		{name: "基准时间", after: 0, gen: 0, factor: 1},
+		{name: "一个半衰期", after: time.Hour, gen: 0, factor: 2},
+		{name: "代的最后一个半衰期", after: 31 * time.Hour, gen: 0, factor: math.Exp2(31)},
+		{name: "新的一代", after: 32 * time.Hour, gen: 1, factor: 1},
+		{name: "多年以后", after: 10 * 365 * 24 * time.Hour, gen: 2737, factor: math.Exp2(16)},
+	}
+	for _, tt := range tests {
+		now := decayEpoch.Add(tt.after)
+		gen := p.generation(now)
+		factor := p.factor(gen, now)
+		if gen != tt.gen || factor != tt.factor {
+			t.Errorf("%s: generation() = %d, factor() = %v, want %d, %v", tt.name, gen, factor, tt.gen, tt.factor)
+		}
+		if math.IsInf(factor, 0) || factor > math.Exp2(decayGeneration) {
+			t.Errorf("%s: factor() = %v 超出范围", tt.name, factor)
+		}
+	}
+
+	// 不衰减时不分代
+	var flat *ScorePolicy
+	if flat.generation(time.Now()) != 0 || flat.factor(0, time.Now()) != 1 || flat.genKey("rank", 3) != "rank" {
+		t.Error("不衰减时不应分代")
+	}
+}
@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 统计数据的键
+const (
+	keyStatsSummary = "stats:summary" // 汇总计数：加入、离开、会话数、会话总时长、峰值在线
+	keyStatsHours   = "stats:hours"   // 每小时(0-23)消息数，用于统计最繁忙的时段
+	keyStatsRooms   = "stats:rooms"   // 有消息的房间 -> 最近一次发言时间
+)
+
+// 汇总计数字段
+const (
+	statJoins          = "joins"
+	statLeaves         = "leaves"
+	statSessions       = "sessions"
+	statSessionSeconds = "sessionSeconds"
+	statPeakUsers      = "peakUsers"
+	statPeakTime       = "peakTime"
+)
+
+// statsMinuteTTL 按分钟计数的保留时间
+const statsMinuteTTL = 2 * 24 * time.Hour
+
+// statsWindow 统计消息速率的时间窗口(分钟)
+const statsWindow = 60
+
+// statsMinuteKey 房间按分钟的消息计数，每天一个哈希，字段为 时分
+func statsMinuteKey(room string, t time.Time) string {
+	return "stats:msg:" + room + ":" + t.Format("20060102")
+}
+
+// RoomStats 房间消息统计
+type RoomStats struct {
+	Room       string  `json:"room"`
+	LastMinute int64   `json:"lastMinute"` // 最近一分钟消息数
+	LastHour   int64   `json:"lastHour"`   // 最近一小时消息数
+	PerMinute  float64 `json:"perMinute"`  // 最近一小时平均每分钟消息数
+}
+
+// HourStats 每小时消息数
+type HourStats struct {
+	Hour     int   `json:"hour"`
+	Messages int64 `json:"messages"`
+}
+
+// StatsReport 工作区统计报告
+type StatsReport struct {
+	Online       int         `json:"online"`            // 当前在线人数
+	PeakUsers    int64       `json:"peakUsers"`         // 峰值在线人数
+	PeakTime     int64       `json:"peakTime"`          // 峰值出现时间(Unix 秒)
+	Joins        int64       `json:"joins"`             // 累计加入次数
+	Leaves       int64       `json:"leaves"`            // 累计离开次数
+	AvgSession   float64     `json:"avgSessionSeconds"` // 平均会话时长(秒)
+	Rooms        []RoomStats `json:"rooms"`             // 各房间消息速率
+	BusiestHours []HourStats `json:"busiestHours"`      // 按消息数从多到少排列的时段
+}
+
+// RecordMessage 记录一条房间消息
+func RecordMessage(ctx context.Context, s Store, room string, now time.Time) error {
+	_, err := s.HashIncr(ctx, statsMinuteKey(room, now), now.Format("1504"), 1, statsMinuteTTL)
+	if err != nil {
+		return err
+	}
+	_, err = s.HashIncr(ctx, keyStatsHours, strconv.Itoa(now.Hour()), 1, 0)
+	if err != nil {
+		return err
+	}
+	return s.HashSet(ctx, keyStatsRooms, room, strconv.FormatInt(now.Unix(), 10))
+}
+
+// RecordJoin 记录用户加入，并根据集群在线人数更新峰值
+func RecordJoin(ctx context.Context, s Store, now time.Time) error {
+	_, err := s.HashIncr(ctx, keyStatsSummary, statJoins, 1, 0)
+	if err != nil {
+		return err
+	}
+	presence, err := s.GetPresence(ctx)
+	if err != nil {
+		return err
+	}
+	summary, err := s.HashGetAll(ctx, keyStatsSummary)
+	if err != nil {
+		return err
+	}
+	// 多个节点同时更新时峰值可能略有偏差，统计用途可以接受
+	peak, _ := strconv.ParseInt(summary[statPeakUsers], 10, 64)
+	if int64(len(presence)) <= peak {
+		return nil
+	}
+	err = s.HashSet(ctx, keyStatsSummary, statPeakUsers, strconv.Itoa(len(presence)))
+	if err != nil {
+		return err
+	}
+	return s.HashSet(ctx, keyStatsSummary, statPeakTime, strconv.FormatInt(now.Unix(), 10))
+}
+
+// RecordLeave 记录用户离开及本次会话时长
+func RecordLeave(ctx context.Context, s Store, session time.Duration) error {
+	_, err := s.HashIncr(ctx, keyStatsSummary, statLeaves, 1, 0)
+	if err != nil {
+		return err
+	}
+	_, err = s.HashIncr(ctx, keyStatsSummary, statSessions, 1, 0)
+	if err != nil {
+		return err
+	}
+	_, err = s.HashIncr(ctx, keyStatsSummary, statSessionSeconds, int64(session/time.Second), 0)
+	return err
+}
+
+// CollectStats 汇总工作区的统计数据
+func CollectStats(ctx context.Context, s Store, now time.Time) (*StatsReport, error) {
+	report := &StatsReport{}
+	presence, err := s.GetPresence(ctx)
+	if err != nil {
+		return nil, errors.New("获取在线用户失败: " + err.Error())
+	}
+	report.Online = len(presence)
+
+	summary, err := s.HashGetAll(ctx, keyStatsSummary)
+	if err != nil {
+		return nil, errors.New("获取统计数据失败: " + err.Error())
+	}
+	counter := func(field string) int64 {
+		v, _ := strconv.ParseInt(summary[field], 10, 64)
+		return v
+	}
+	report.PeakUsers = counter(statPeakUsers)
+	report.PeakTime = counter(statPeakTime)
+	report.Joins = counter(statJoins)
+	report.Leaves = counter(statLeaves)
+	if sessions := counter(statSessions); sessions > 0 {
+		report.AvgSession = float64(counter(statSessionSeconds)) / float64(sessions)
+	}
+
+	rooms, err := s.HashGetAll(ctx, keyStatsRooms)
+	if err != nil {
+		return nil, errors.New("获取房间统计失败: " + err.Error())
+	}
+	report.Rooms = make([]RoomStats, 0, len(rooms))
+	for room := range rooms {
+		stats, err := roomStats(ctx, s, room, now)
+		if err != nil {
+			return nil, err
+		}
+		report.Rooms = append(report.Rooms, stats)
+	}
+	sort.Slice(report.Rooms, func(i, j int) bool {
+		if report.Rooms[i].LastHour != report.Rooms[j].LastHour {
+			return report.Rooms[i].LastHour > report.Rooms[j].LastHour
+		}
+		return report.Rooms[i].Room < report.Rooms[j].Room
+	})
+
+	hours, err := s.HashGetAll(ctx, keyStatsHours)
+	if err != nil {
+		return nil, errors.New("获取时段统计失败: " + err.Error())
+	}
+	report.BusiestHours = make([]HourStats, 0, len(hours))
+	for field, value := range hours {
+		hour, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		messages, _ := strconv.ParseInt(value, 10, 64)
+		report.BusiestHours = append(report.BusiestHours, HourStats{Hour: hour, Messages: messages})
+	}
+	sort.Slice(report.BusiestHours, func(i, j int) bool {
+		if report.BusiestHours[i].Messages != report.BusiestHours[j].Messages {
+			return report.BusiestHours[i].Messages > report.BusiestHours[j].Messages
+		}
+		return report.BusiestHours[i].Hour < report.BusiestHours[j].Hour
+	})
+	return report, nil
+}
+
+// roomStats 统计房间最近一小时的消息速率
+func roomStats(ctx context.Context, s Store, room string, now time.Time) (RoomStats, error) {
+	stats := RoomStats{Room: room}
+	// 最近一小时可能跨天，分别读取今天与昨天的计数
+	days := map[string]map[string]string{}
+	for i := 0; i < statsWindow; i++ {
+		t := now.Add(-time.Duration(i) * time.Minute)
+		key := statsMinuteKey(room, t)
+		counts, ok := days[key]
+		if !ok {
+			var err error
+			counts, err = s.HashGetAll(ctx, key)
+			if err != nil {
+				return stats, errors.New("获取房间统计失败: " + err.Error())
+			}
+			days[key] = counts
+		}
+		n, _ := strconv.ParseInt(counts[t.Format("1504")], 10, 64)
+		if i == 0 {
+			stats.LastMinute = n
+		}
+		stats.LastHour += n
+	}
+	stats.PerMinute = float64(stats.LastHour) / statsWindow
+	return stats, nil
+}
+
+// String 统计报告的文本形式
+func (r *StatsReport) String() string {
+	msg := "聊天统计:\n"
+	msg += fmt.Sprintf("当前在线: %d  峰值在线: %d", r.Online, r.PeakUsers)
+	if r.PeakTime > 0 {
+		msg += " (" + time.Unix(r.PeakTime, 0).Format("2006-01-02 15:04:05") + ")"
+	}
+	msg += fmt.Sprintf("\n累计加入: %d  累计离开: %d  平均会话时长: %s\n",
+		r.Joins, r.Leaves, time.Duration(r.AvgSession*float64(time.Second)).Round(time.Second).String())
+	msg += "房间消息速率(最近一小时):"
+	if len(r.Rooms) == 0 {
+		msg += " 暂无消息"
+	}
+	for _, room := range r.Rooms {
+		msg += fmt.Sprintf("\n  %s  最近一分钟: %d  最近一小时: %d  平均每分钟: %.2f",
+			room.Room, room.LastMinute, room.LastHour, room.PerMinute)
+	}
+	msg += "\n最繁忙时段:"
+	if len(r.BusiestHours) == 0 {
+		msg += " 暂无消息"
+	}
+	for i, hour := range r.BusiestHours {
+		if i >= 5 {
+			break
+		}
+		msg += fmt.Sprintf("\n  %02d:00-%02d:59  %d 条", hour.Hour, hour.Hour, hour.Messages)
+	}
+	return msg
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/server/object"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Store 存储接口，消息队列、广播、在线用户与排行榜都通过它访问
+type Store interface {
+	// Node 当前节点标识
+	Node() string
+	// Clean 清理当前节点的数据
+	Clean(ctx context.Context) error
+
+	// MsgQueuePush 消息入队
+	MsgQueuePush(ctx context.Context, msg string) error
+	// MsgQueuePop 消息出队，队列为空时阻塞，可能因等待超时返回 ErrQueueEmpty
+	MsgQueuePop(ctx context.Context) (string, error)
+
+	// Publish 向所有节点广播消息
+	Publish(ctx context.Context, msg string) error
+	// Subscribe 订阅广播消息
+	Subscribe(ctx context.Context) (<-chan string, error)
+
+	// AddPresence 登记在线用户，昵称已被占用时返回 false
+	AddPresence(ctx context.Context, nickName string) (bool, error)
+	// DelPresence 注销在线用户
+	DelPresence(ctx context.Context, nickName string) error
+	// GetPresence 获取在线用户，昵称->节点
+	GetPresence(ctx context.Context) (map[string]string, error)
+
+	// ZIncrBy 为有序集合成员加分，ttl 大于 0 时设置键的过期时间
+	ZIncrBy(ctx context.Context, key string, member string, delta float64, ttl time.Duration) error
+	// ZRevRange 按分数从高到低获取有序集合前 n 个成员，n 不大于 0 时获取全部
+	ZRevRange(ctx context.Context, key string, n int) ([]RankItem, error)
+	// ZScore 获取有序集合成员的分数，成员不存在时返回 false
+	ZScore(ctx context.Context, key string, member string) (float64, bool, error)
+	// ZRem 删除有序集合成员
+	ZRem(ctx context.Context, key string, member string) error
+
+	// AddHistory 记录房间历史消息
+	AddHistory(ctx context.Context, room string, msg string) error
+	// History 获取房间最近 n 条历史消息，按时间先后排列
+	History(ctx context.Context, room string, n int) ([]string, error)
+	// GetHistory 按消息编号获取房间的历史消息，不存在或已被淘汰时返回 false
+	GetHistory(ctx context.Context, room string, id string) (string, bool, error)
+	// SetHistory 按消息编号替换房间的历史消息，不存在或已被淘汰时返回 false
+	SetHistory(ctx context.Context, room string, id string, msg string) (bool, error)
+
+	// HashSet 设置哈希字段
+	HashSet(ctx context.Context, key string, field string, value string) error
+	// HashSetNX 哈希字段不存在时设置，已存在时返回 false
+	HashSetNX(ctx context.Context, key string, field string, value string) (bool, error)
+	// HashDel 删除哈希字段
+	HashDel(ctx context.Context, key string, field string) error
+	// HashGet 获取哈希字段，字段不存在时 ok 为 false
+	HashGet(ctx context.Context, key string, field string) (value string, ok bool, err error)
+	// HashGetAll 获取哈希所有字段
+	HashGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HashIncr 为哈希字段加上整数增量并返回新值，ttl 大于 0 时设置键的过期时间
+	HashIncr(ctx context.Context, key string, field string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// ErrQueueEmpty 消息出队等待超时，队列中暂无消息
+var ErrQueueEmpty = errors.New("消息队列为空")
+
+// HealthNotifier 可报告健康状态变化的存储
+type HealthNotifier interface {
+	OnHealthChange(fn func(healthy bool, err error))
+}
+
+// RankItem 排行榜条目
+type RankItem struct {
+	Member string
+	Score  float64
+}
+
+// NewStore 根据配置创建存储，不同 namespace 的数据相互隔离
+func NewStore(config object.Config, namespace string) (Store, error) {
+	switch config.App.Storage {
+	case "", "redis":
+		return NewRedisHandler(config, namespace)
+	case "memory":
+		return NewMemoryStore(config), nil
+	case "file":
+		return NewFileStore(config, namespace)
+	default:
+		return nil, errors.New("未知的存储类型: " + config.App.Storage)
+	}
+}
+
+// historyID 历史消息的消息编号，历史消息为 JSON 编码的消息帧
+func historyID(msg string) string {
+	var frame struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal([]byte(msg), &frame) != nil {
+		return ""
+	}
+	return frame.ID
+}
@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"easy-chat/server/object"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 令牌吊销记录
+const (
+	keyRevokedTokens = "tokens:revoked"        // 被吊销的令牌 编号->过期时间
+	keyRevokedUsers  = "tokens:revoked_before" // 用户 昵称->时间，早于该时间签发的令牌全部失效
+)
+
+// defaultTokenTTL 默认令牌有效期
+const defaultTokenTTL = 7 * 24 * time.Hour
+
+// 令牌校验失败原因
+var (
+	ErrTokenDisabled = errors.New("服务端未开启令牌登录")
+	ErrTokenInvalid  = errors.New("令牌无效")
+	ErrTokenExpired  = errors.New("令牌已过期")
+	ErrTokenRevoked  = errors.New("令牌已被吊销")
+)
+
+// TokenClaims 令牌内容
+type TokenClaims struct {
+	ID        string `json:"id"`  // 令牌编号，用于吊销
+	Workspace string `json:"ws"`  // 工作区
+	Subject   string `json:"sub"` // 用户昵称
+	IssuedAt  int64  `json:"iat"` // 签发时间(Unix 秒)
+	ExpiresAt int64  `json:"exp"` // 过期时间(Unix 秒)
+}
+
+// TokenManager 签发与校验 HMAC-SHA256 签名的会话令牌
+// 令牌格式为 base64url(内容).base64url(签名)，多个节点使用相同的密钥即可互相校验
+type TokenManager struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewTokenManager 根据配置创建令牌管理器，未配置密钥时不签发令牌
+func NewTokenManager(config object.Config) *TokenManager {
+	ttl := time.Duration(config.Token.TTL) * time.Hour
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	return &TokenManager{
+		secret: []byte(config.Token.Secret),
+		ttl:    ttl,
+	}
+}
+
+// Enabled 是否开启令牌
+func (m *TokenManager) Enabled() bool {
+	return len(m.secret) > 0
+}
+
+// Issue 为工作区中的注册用户签发令牌
+func (m *TokenManager) Issue(workspace string, nickName string) (string, *TokenClaims, error) {
+	if !m.Enabled() {
+		return "", nil, ErrTokenDisabled
+	}
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", nil, errors.New("生成令牌编号失败: " + err.Error())
+	}
+	now := time.Now()
+	claims := &TokenClaims{
+		ID:        hex.EncodeToString(id),
+		Workspace: workspace,
+		Subject:   nickName,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(m.ttl).Unix(),
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + m.sign(payload), claims, nil
+}
+
+// Parse 校验令牌签名与有效期，吊销状态需要再通过 CheckRevoked 检查
+func (m *TokenManager) Parse(token string) (*TokenClaims, error) {
+	if !m.Enabled() {
+		return nil, ErrTokenDisabled
+	}
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(m.sign(payload))) {
+		return nil, ErrTokenInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var claims TokenClaims
+	err = json.Unmarshal(data, &claims)
+	if err != nil || claims.ID == "" || claims.Subject == "" {
+		return nil, ErrTokenInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// sign 计算签名
+func (m *TokenManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckRevoked 检查令牌是否已被吊销
+func CheckRevoked(ctx context.Context, s Store, claims *TokenClaims) error {
+	_, revoked, err := s.HashGet(ctx, keyRevokedTokens, claims.ID)
+	if err != nil {
+		return errors.New("获取令牌吊销记录失败: " + err.Error())
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	value, _, err := s.HashGet(ctx, keyRevokedUsers, claims.Subject)
+	if err != nil {
+		return errors.New("获取令牌吊销记录失败: " + err.Error())
+	}
+	before, _ := strconv.ParseInt(value, 10, 64)
+	if claims.IssuedAt <= before {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// RevokeToken 吊销单个令牌，同时清理已过期的吊销记录
+func RevokeToken(ctx context.Context, s Store, claims *TokenClaims) error {
+	err := s.HashSet(ctx, keyRevokedTokens, claims.ID, strconv.FormatInt(claims.ExpiresAt, 10))
+	if err != nil {
+		return errors.New("吊销令牌失败: " + err.Error())
+	}
+	revoked, err := s.HashGetAll(ctx, keyRevokedTokens)
+	if err != nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	for id, value := range revoked {
+		exp, _ := strconv.ParseInt(value, 10, 64)
+		if exp < now {
+			_ = s.HashDel(ctx, keyRevokedTokens, id)
+		}
+	}
+	return nil
+}
+
+// RevokeUser 吊销用户此前签发的全部令牌
+func RevokeUser(ctx context.Context, s Store, nickName string) error {
+	err := s.HashSet(ctx, keyRevokedUsers, nickName, strconv.FormatInt(time.Now().Unix(), 10))
+	if err != nil {
+		return errors.New("吊销令牌失败: " + err.Error())
+	}
+	return nil
+}
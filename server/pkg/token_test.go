@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"context"
+	"easy-chat/server/object"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenManager 测试用的令牌管理器
+func tokenManager(secret string) *TokenManager {
+	var config object.Config
+	config.Token.Secret = secret
+	config.Token.TTL = 1
+	return NewTokenManager(config)
+}
+
+// signClaims 用管理器的密钥签名任意内容
+func signClaims(t *testing.T, m *TokenManager, claims TokenClaims) string {
+	t.Helper()
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + m.sign(payload)
+}
+
+// signClaimsRaw 用管理器的密钥签名原始内容
+func signClaimsRaw(m *TokenManager, data string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(data))
+	return payload + "." + m.sign(payload)
+}
+
+func TestTokenIssueParse(t *testing.T) {
+	m := tokenManager("s3cret")
+	token, claims, err := m.Issue("teamA", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Workspace != "teamA" || claims.Subject != "alice" || claims.ExpiresAt-claims.IssuedAt != 3600 {
+		t.Errorf("Issue() claims = %+v", claims)
+	}
+	got, err := m.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *claims {
+		t.Errorf("Parse() = %+v, want %+v", got, claims)
+	}
+	// 编号每次不同
+	if _, other, _ := m.Issue("teamA", "alice"); other.ID == claims.ID {
+		t.Error("两次签发的令牌编号相同")
+	}
+
+	disabled := tokenManager("")
+	if _, _, err = disabled.Issue("teamA", "alice"); err != ErrTokenDisabled {
+		t.Errorf("未配置密钥时 Issue() error = %v, want %v", err, ErrTokenDisabled)
+	}
+	if _, err = disabled.Parse(token); err != ErrTokenDisabled {
+		t.Errorf("未配置密钥时 Parse() error = %v, want %v", err, ErrTokenDisabled)
+	}
+}
+
+func TestTokenParseInvalid(t *testing.T) {
+	m := tokenManager("s3cret")
+	token, _, err := m.Issue("default", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	now := time.Now().Unix()
+	forged, _ := json.Marshal(TokenClaims{ID: "x", Workspace: "default", Subject: "root", IssuedAt: now, ExpiresAt: now + 3600})
+	other, _, _ := tokenManager("other").Issue("default", "alice")
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "篡改签名", token: payload + "." + strings.Repeat("A", len(sig)), err: ErrTokenInvalid},
+		{name: "篡改内容", token: base64.RawURLEncoding.EncodeToString(forged) + "." + sig, err: ErrTokenInvalid},
+		{name: "其他密钥签名", token: other, err: ErrTokenInvalid},
+		{name: "缺少签名", token: payload, err: ErrTokenInvalid},
+		{name: "内容不是 JSON", token: signClaimsRaw(m, "not json"), err: ErrTokenInvalid},
+		{name: "缺少用户", token: signClaims(t, m, TokenClaims{ID: "x", ExpiresAt: now + 60}), err: ErrTokenInvalid},
+		{name: "已过期", token: signClaims(t, m, TokenClaims{ID: "x", Subject: "alice", IssuedAt: now - 60, ExpiresAt: now}), err: ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		if _, err := m.Parse(tt.token); err != tt.err {
+			t.Errorf("%s: Parse() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCheckRevoked(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(object.Config{})
+	now := time.Now().Unix()
+	alice := &TokenClaims{ID: "a1", Subject: "alice", IssuedAt: now - 10, ExpiresAt: now + 3600}
+	alice2 := &TokenClaims{ID: "a2", Subject: "alice", IssuedAt: now - 10, ExpiresAt: now + 3600}
+	bob := &TokenClaims{ID: "b1", Subject: "bob", IssuedAt: now - 10, ExpiresAt: now + 3600}
+	if err := CheckRevoked(ctx, s, alice); err != nil {
+		t.Fatalf("未吊销时 CheckRevoked() = %v", err)
+	}
+
+	// 吊销单个令牌只影响该令牌，并清理已过期的吊销记录
+	_ = s.HashSet(ctx, keyRevokedTokens, "old", "1")
+	if err := RevokeToken(ctx, s, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRevoked(ctx, s, alice); err != ErrTokenRevoked {
+		t.Errorf("吊销后 CheckRevoked() = %v, want %v", err, ErrTokenRevoked)
+	}
+	if err := CheckRevoked(ctx, s, alice2); err != nil {
+		t.Errorf("同一用户的其他令牌 CheckRevoked() = %v", err)
+	}
+	if _, ok, _ := s.HashGet(ctx, keyRevokedTokens, "old"); ok {
+		t.Error("已过期的吊销记录没有被清理")
+	}
+
+	// 吊销用户之前签发的全部令牌，之后签发的令牌不受影响
+	if err := RevokeUser(ctx, s, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRevoked(ctx, s, alice2); err != ErrTokenRevoked {
+		t.Errorf("吊销用户后 CheckRevoked() = %v, want %v", err, ErrTokenRevoked)
+	}
+	if err := CheckRevoked(ctx, s, bob); err != nil {
+		t.Errorf("其他用户 CheckRevoked() = %v", err)
+	}
+	later := &TokenClaims{ID: "a3", Subject: "alice", IssuedAt: now + 10, ExpiresAt: now + 3600}
+	if err := CheckRevoked(ctx, s, later); err != nil {
+		t.Errorf("吊销后签发的令牌 CheckRevoked() = %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"easy-chat/server/object"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 外发 Webhook 事件
+const (
+	WebhookMessage = "message" // 聊天消息投递后
+	WebhookJoin    = "join"    // 用户进入聊天室
+	WebhookLeave   = "leave"   // 用户退出聊天室
+)
+
+// WebhookSignatureHeader 请求签名的请求头，值为 sha256=<请求体的 HMAC-SHA256 十六进制>
+const WebhookSignatureHeader = "X-EasyChat-Signature"
+
+const (
+	defaultWebhookTimeout  = 5 * time.Second
+	defaultWebhookRetryMin = 500 * time.Millisecond
+	defaultWebhookRetryMax = 30 * time.Second
+	defaultWebhookQueue    = 100
+)
+
+// WebhookEvent 发送给 Webhook 的事件
+type WebhookEvent struct {
+	ID        string `json:"id"` // 事件编号，重试时不变，接收方可用于去重
+	Webhook   string `json:"webhook"`
+	Event     string `json:"event"`
+	Workspace string `json:"workspace"`
+	Room      string `json:"room,omitempty"`
+	From      string `json:"from,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Time      int64  `json:"time"`
+}
+
+// Webhook 外发 Webhook，事件放入有界队列后由单独的协程发送，队列已满时丢弃事件
+// 请求失败或接收方返回 5xx、429 时按指数退避重试
+type Webhook struct {
+	Name      string
+	url       string
+	workspace string
+	secret    []byte
+	events    map[string]bool
+	rooms     map[string]bool
+	pattern   *regexp.Regexp
+	retries   int
+	retryMin  time.Duration
+	retryMax  time.Duration
+	client    *http.Client
+	queue     chan WebhookEvent
+	logger    *logrus.Logger
+
+	mu      sync.Mutex
+	nextID  uint64
+	sent    int
+	retried int
+	failed  int
+	dropped int
+	lastErr string
+}
+
+// NewWebhook 根据配置创建 Webhook，调用 Start 后开始发送
+func NewWebhook(name string, conf object.WebhookConfig, logger *logrus.Logger) (*Webhook, error) {
+	if !strings.HasPrefix(conf.URL, "http://") && !strings.HasPrefix(conf.URL, "https://") {
+		return nil, errors.New("Webhook " + name + " 的 url 需要以 http:// 或 https:// 开头")
+	}
+	h := &Webhook{
+		Name:      name,
+		url:       conf.URL,
+		workspace: conf.Workspace,
+		secret:    []byte(conf.Secret),
+		events:    make(map[string]bool),
+		rooms:     make(map[string]bool),
+		retries:   conf.Retries,
+		retryMin:  time.Duration(conf.RetryMin) * time.Millisecond,
+		retryMax:  time.Duration(conf.RetryMax) * time.Millisecond,
+		logger:    logger,
+	}
+	if len(conf.Events) == 0 {
+		conf.Events = []string{WebhookMessage}
+	}
+	for _, event := range conf.Events {
+		event = strings.TrimSpace(event)
+		switch event {
+		case WebhookMessage, WebhookJoin, WebhookLeave:
+			h.events[event] = true
+		default:
+			return nil, errors.New("Webhook " + name + " 的事件有误: " + event + "，可选 message、join、leave")
+		}
+	}
+	for _, room := range conf.Rooms {
+		if room = strings.TrimSpace(room); room != "" {
+			h.rooms[room] = true
+		}
+	}
+	if conf.Pattern != "" {
+		re, err := regexp.Compile(conf.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Webhook %s 的 pattern 有误: %v", name, err)
+		}
+		h.pattern = re
+	}
+	timeout := time.Duration(conf.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	h.client = &http.Client{Timeout: timeout}
+	if h.retryMin <= 0 {
+		h.retryMin = defaultWebhookRetryMin
+	}
+	if h.retryMax < h.retryMin {
+		h.retryMax = defaultWebhookRetryMax
+	}
+	size := conf.QueueSize
+	if size <= 0 {
+		size = defaultWebhookQueue
+	}
+	h.queue = make(chan WebhookEvent, size)
+	return h, nil
+}
+
+// Start 开始发送队列中的事件
+func (h *Webhook) Start() {
+	go func() {
+		for event := range h.queue {
+			h.deliver(event)
+		}
+	}()
+}
+
+// Match 事件是否需要发送给该 Webhook，只匹配所属工作区的事件，pattern 只匹配消息事件
+func (h *Webhook) Match(event WebhookEvent) bool {
+	if event.Workspace != h.workspace || !h.events[event.Event] {
+		return false
+	}
+	if len(h.rooms) > 0 && !h.rooms[event.Room] {
+		return false
+	}
+	return event.Event != WebhookMessage || h.pattern == nil || h.pattern.MatchString(event.Text)
+}
+
+// Enqueue 事件匹配时放入发送队列，不等待发送结果，队列已满时丢弃并返回 false
+func (h *Webhook) Enqueue(event WebhookEvent) bool {
+	if !h.Match(event) {
+		return true
+	}
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	event.ID = strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(h.nextID, 10)
+	event.Webhook = h.Name
+	select {
+	case h.queue <- event:
+		return true
+	default:
+		h.dropped++
+		return false
+	}
+}
+
+// deliver 发送事件，失败时按指数退避重试
+func (h *Webhook) deliver(event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		h.logger.WithField("webhook", h.Name).Error("encode webhook event failed, err:", err)
+		return
+	}
+	delay := h.retryMin
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(event, body)
+		h.mu.Lock()
+		switch {
+		case err == nil:
+			h.sent++
+		case !retry || attempt >= h.retries:
+			h.failed++
+			h.lastErr = err.Error()
+		default:
+			h.retried++
+		}
+		h.mu.Unlock()
+		if err == nil {
+			return
+		}
+		entry := h.logger.WithFields(logrus.Fields{"webhook": h.Name, "event": event.ID})
+		if !retry || attempt >= h.retries {
+			entry.Error("deliver webhook failed, err:", err)
+			return
+		}
+		entry.Warn("deliver webhook failed, retry in ", delay, ", err:", err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > h.retryMax {
+			delay = h.retryMax
+		}
+	}
+}
+
+// post 发送一次请求，返回失败后是否需要重试
+func (h *Webhook) post(event WebhookEvent, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-EasyChat-Event", event.Event)
+	req.Header.Set("X-EasyChat-Delivery", event.ID)
+	if len(h.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(h.secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, errors.New("接收方返回 " + resp.Status)
+	default:
+		return false, errors.New("接收方返回 " + resp.Status)
+	}
+}
+
+// SignWebhook 请求体的 HMAC-SHA256 签名(十六进制)
+func SignWebhook(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// String Webhook 的状态
+func (h *Webhook) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg := fmt.Sprintf("%s  工作区: %s  %s  排队: %d  成功: %d  重试: %d  失败: %d  丢弃: %d",
+		h.Name, h.workspace, h.url, len(h.queue), h.sent, h.retried, h.failed, h.dropped)
+	if h.lastErr != "" {
+		msg += "\n    最近错误: " + h.lastErr
+	}
+	return msg
+}
@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		{secret: "", body: "", want: "b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+		{secret: "key", body: "The quick brown fox jumps over the lazy dog", want: "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+	}
+	for _, tt := range tests {
+		if got := SignWebhook([]byte(tt.secret), []byte(tt.body)); got != tt.want {
+			t.Errorf("SignWebhook(%q, %q) = %s, want %s", tt.secret, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tests := []struct {
+		name   string
+		secret string
+		status int
+		retry  bool
+		err    bool
+	}{
+		{name: "签名", secret: "s3cret", status: http.StatusOK},
+		{name: "不签名", secret: "", status: http.StatusNoContent},
+		{name: "5xx 重试", status: http.StatusBadGateway, retry: true, err: true},
+		{name: "429 重试", status: http.StatusTooManyRequests, retry: true, err: true},
+		{name: "4xx 不重试", status: http.StatusBadRequest, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			h, err := NewWebhook("ci", object.WebhookConfig{URL: server.URL, Workspace: "default", Secret: tt.secret}, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload := []byte(`{"id":"ci-1","event":"message"}`)
+			retry, err := h.post(WebhookEvent{ID: "ci-1", Event: WebhookMessage}, payload)
+			if retry != tt.retry || (err != nil) != tt.err {
+				t.Errorf("post() = %v, %v, want %v, err %v", retry, err, tt.retry, tt.err)
+			}
+			if string(body) != string(payload) {
+				t.Errorf("请求体 = %s, want %s", body, payload)
+			}
+			want := ""
+			if tt.secret != "" {
+				want = "sha256=" + SignWebhook([]byte(tt.secret), payload)
+			}
+			if got := header.Get(WebhookSignatureHeader); got != want {
+				t.Errorf("%s = %q, want %q", WebhookSignatureHeader, got, want)
+			}
+			if header.Get("X-EasyChat-Delivery") != "ci-1" || header.Get("X-EasyChat-Event") != WebhookMessage {
+				t.Errorf("事件请求头有误: %v", header)
+			}
+		})
+	}
+}
+
+func TestWebhookMatch(t *testing.T) {
+	h, err := NewWebhook("ci", object.WebhookConfig{
+		URL:       "http://localhost/hook",
+		Workspace: "default",
+		Events:    []string{WebhookMessage, WebhookJoin},
+		Rooms:     []string{"lobby", "dev"},
+		Pattern:   `^deploy`,
+	}, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		event WebhookEvent
+		want  bool
+	}{
+		{name: "匹配的消息", event: WebhookEvent{Workspace: "default", Event: WebhookMessage, Room: "dev", Text: "deploy v1"}, want: true},
+		{name: "其他工作区", event: WebhookEvent{Workspace: "teamA", Event: WebhookMessage, Room: "dev", Text: "deploy v1"}, want: false},
+		{name: "其他房间", event: WebhookEvent{Workspace: "default", Event: WebhookMessage, Room: "random", Text: "deploy v1"}, want: false},
+		{name: "内容不匹配", event: WebhookEvent{Workspace: "default", Event: WebhookMessage, Room: "dev", Text: "hello"}, want: false},
+		{name: "pattern 不限制进入事件", event: WebhookEvent{Workspace: "default", Event: WebhookJoin, Room: "lobby"}, want: true},
+		{name: "未订阅的事件", event: WebhookEvent{Workspace: "default", Event: WebhookLeave, Room: "lobby"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := h.Match(tt.event); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
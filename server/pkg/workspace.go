@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"easy-chat/server/object"
+	"errors"
+	"strings"
+)
+
+// Workspace 工作区，每个工作区拥有独立的存储命名空间、房间与排行榜
+type Workspace struct {
+	Name  string
+	Store Store
+}
+
+// LoadWorkspaces 按配置创建工作区，第一个为默认工作区
+func LoadWorkspaces(config object.Config) ([]*Workspace, error) {
+	names := config.App.Workspaces
+	if len(names) == 0 {
+		names = []string{"default"}
+	}
+	workspaces := make([]*Workspace, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			return nil, errors.New("工作区名称为空或重复: " + name)
+		}
+		seen[name] = true
+		store, err := NewStore(config, name)
+		if err != nil {
+			return nil, errors.New("创建工作区 " + name + " 的存储失败: " + err.Error())
+		}
+		workspaces = append(workspaces, &Workspace{Name: name, Store: store})
+	}
+	return workspaces, nil
+}
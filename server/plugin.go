@@ -0,0 +1,214 @@
+package main
+
+import (
+	"easy-chat/proto"
+	"easy-chat/server/object"
+	"easy-chat/server/pkg"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadPlugins 按配置创建外部插件，按名称排序，同一个命令只能由一个插件处理
+func loadPlugins(conf map[string]object.PluginConfig) ([]*pkg.Plugin, map[string]*pkg.Plugin, error) {
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*pkg.Plugin, 0, len(names))
+	commands := make(map[string]*pkg.Plugin)
+	for _, name := range names {
+		p, err := pkg.NewPlugin(name, conf[name], handlePluginAction, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, command := range p.Commands() {
+			if other, ok := commands[command]; ok {
+				return nil, nil, errors.New("命令 " + command + " 同时注册在插件 " + other.Name + " 与 " + name + " 中")
+			}
+			commands[command] = p
+		}
+		list = append(list, p)
+	}
+	return list, commands, nil
+}
+
+// pluginMiddleware 消息投递后发送给订阅了消息事件的插件
+func pluginMiddleware(mc *pkg.MessageContext, next func()) {
+	next()
+	if !mc.Delivered() {
+		return
+	}
+	dispatchPlugins(pkg.PluginEvent{
+		Event:     pkg.PluginMessage,
+		Workspace: mc.Workspace.Name,
+		Room:      mc.Frame.Room,
+		From:      mc.Frame.From,
+		Text:      mc.Frame.Text,
+		Time:      mc.Frame.Time,
+	})
+}
+
+// dispatchPlugins 将事件发送给所有插件，插件未运行时丢弃事件，插件不会收到自己发送的消息
+func dispatchPlugins(event pkg.PluginEvent) {
+	for _, p := range plugins {
+		if event.Event == pkg.PluginMessage && event.From == p.Nick() {
+			continue
+		}
+		err := p.Send(event)
+		if err != nil && !errors.Is(err, pkg.ErrPluginNotRunning) {
+			logger.Warn("send event to plugin ", p.Name, " failed, err:", err)
+		}
+	}
+}
+
+// pluginCommand 将客户端命令交给注册了该命令的插件，插件通过 reply 动作回复，未注册时返回 false
+func pluginCommand(ws *pkg.Workspace, frame proto.Frame, args []string) (string, bool) {
+	p, ok := pluginCommands[args[0]]
+	if !ok {
+		return "", false
+	}
+	err := p.Send(pkg.PluginEvent{
+		Event:     pkg.PluginCommand,
+		Workspace: ws.Name,
+		Room:      frame.Room,
+		From:      frame.From,
+		Command:   args[0],
+		Args:      args[1:],
+		Time:      frame.Time,
+	})
+	if err != nil {
+		return "插件 " + p.Name + " 暂不可用: " + err.Error(), true
+	}
+	return "", true
+}
+
+// handlePluginAction 执行插件返回的动作，插件主动执行的动作作用于默认工作区
+func handlePluginAction(p *pkg.Plugin, event *pkg.PluginEvent, action pkg.PluginAction) {
+	ws, room, from := defaultWorkspace, pkg.DefaultRoom, ""
+	if event != nil {
+		ws, room, from = workspaces[event.Workspace], event.Room, event.From
+	}
+	if ws == nil {
+		return
+	}
+	if action.Room != "" {
+		room = action.Room
+	}
+	if room == "" {
+		room = pkg.DefaultRoom
+	}
+	actor := "plugin:" + p.Name
+	entry := logger.WithField("plugin", p.Name)
+	result := ""
+	switch action.Action {
+	case pkg.PluginSend:
+		if action.Text == "" {
+			result = "消息为空"
+			break
+		}
+		if result = checkRoom(ws, room); result != "" {
+			break
+		}
+		// 与入站 Webhook 一样经过中间件链，过滤、禁言等规则同样适用于插件
+		frame := proto.Frame{Type: proto.TypeMsg, From: p.Nick(), Room: room, Text: action.Text, Time: time.Now().Unix()}
+		mc := pkg.NewMessageContext(ctx, ws, nil, frame, false)
+		mc.Bot = true
+		messageChain.Run(mc)
+		for _, f := range mc.Emitted() {
+			publish(ws, f)
+		}
+		if !mc.Delivered() {
+			result = "消息未发送: " + mc.DropReason()
+			if replies := mc.Replies(); len(replies) > 0 {
+				result = "消息未发送: " + replies[0].Text
+			}
+		}
+	case pkg.PluginReply:
+		if from == "" {
+			result = "没有可以回复的用户"
+			break
+		}
+		conn, err := connList.GetConnByNickName(ws.Name, from)
+		if err != nil {
+			result = "用户 " + from + " 不在本节点"
+			break
+		}
+		err = connList.Send(conn, proto.Frame{Type: proto.TypeSys, Text: "[" + p.Nick() + "] " + action.Text})
+		if err != nil {
+			result = err.Error()
+		}
+	case pkg.PluginKick:
+		if !pkg.HasPermission(p.Role(), pkg.PermKick) {
+			result = "权限不足，" + pkg.RoleName(p.Role()) + "没有 " + pkg.PermKick + " 权限"
+			break
+		}
+		result = kickUser(ws, actor, p.Role(), action.Target, action.Reason)
+	case pkg.PluginTopic:
+		if !pkg.HasPermission(p.Role(), pkg.PermTopic) {
+			result = "权限不足，" + pkg.RoleName(p.Role()) + "没有 " + pkg.PermTopic + " 权限"
+			break
+		}
+		if result = checkRoom(ws, room); result != "" {
+			break
+		}
+		topic := strings.TrimSpace(action.Text)
+		if topic == "" {
+			result = "话题为空"
+			break
+		}
+		if err := pkg.SetRoomMeta(ctx, ws.Store, room, "topic", topic); err != nil {
+			result = err.Error()
+			break
+		}
+		publish(ws, proto.Frame{Type: proto.TypeSys, Room: room, Text: p.Nick() + " 将房间话题设置为: " + topic})
+	default:
+		result = "未知的动作 " + action.Action
+	}
+	if result != "" {
+		entry.Info("plugin action ", action.Action, ": ", result)
+	}
+}
+
+// checkRoom 房间不存在时返回提示
+func checkRoom(ws *pkg.Workspace, room string) string {
+	meta, err := pkg.GetRoomMeta(ctx, ws.Store, room)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	if meta["created"] == "" {
+		return "房间 " + room + " 不存在"
+	}
+	return ""
+}
+
+// pluginNameReason 昵称与插件发送消息时使用的昵称相同或容易混淆时返回原因
+// 插件的昵称作为消息的发送者，保留给插件使用以免被用户冒充
+func pluginNameReason(nickName string) string {
+	names := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		if p.Nick() == nickName {
+			return "昵称已被插件使用"
+		}
+		names = append(names, p.Nick())
+	}
+	if name := nickPolicy.Confusable(nickName, names); name != "" {
+		return "昵称与插件 " + name + " 过于相似"
+	}
+	return ""
+}
+
+// pluginsCommand 服务端终端查看插件状态
+func pluginsCommand() string {
+	if len(plugins) == 0 {
+		return "未配置外部插件"
+	}
+	lines := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		lines = append(lines, p.String())
+	}
+	return "外部插件:\n" + strings.Join(lines, "\n")
+}
@@ -0,0 +1,25 @@
+#!/usr/bin/env python3
+# 示例插件：/roll [面数] 掷骰子，有人进入聊天室时打招呼
+# 每行从标准输入读取一个事件，处理后向标准输出写一行响应，响应需带上事件的 id
+import json
+import random
+import sys
+
+
+def respond(event_id, *actions):
+    print(json.dumps({"id": event_id, "actions": list(actions)}, ensure_ascii=False), flush=True)
+
+
+for line in sys.stdin:
+    event = json.loads(line)
+    kind = event["event"]
+    if kind == "command" and event["command"] == "/roll":
+        args = event.get("args") or []
+        sides = int(args[0]) if args and args[0].isdigit() and int(args[0]) > 1 else 6
+        point = random.randint(1, sides)
+        respond(event["id"], {"action": "send", "text": "%s 掷出了 %d 点(1-%d)" % (event["from"], point, sides)})
+    elif kind == "join":
+        respond(event["id"], {"action": "reply", "text": "欢迎 %s，输入 /roll 掷骰子" % event["from"]})
+    else:
+        # 不需要处理的事件也要响应，否则会被视为超时
+        respond(event["id"])
@@ -6,13 +6,17 @@ import (
 	"easy-chat/proto"
 	"easy-chat/server/object"
 	"easy-chat/server/pkg"
+	"errors"
 	"fmt"
 	"github.com/go-ini/ini"
 	"github.com/sirupsen/logrus"
 	"io"
 	"log"
+	"math"
 	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,10 +26,28 @@ var (
 	listener  *pkg.MyListener
 	console   *pkg.LocalMsg
 	broadcast *pkg.BroadcastMsg
-	rdb       *pkg.RedisHandler
 	logger    *logrus.Logger
 	config    object.Config
 	ctx       = context.Background()
+
+	scorePolicy   *pkg.ScorePolicy   // 活跃度计分策略
+	accountPolicy *pkg.AccountPolicy // 账号策略
+	loginGuard    *pkg.LoginGuard    // 登录失败退避
+	nickPolicy    *pkg.NickPolicy    // 昵称策略
+	rateLimit     *pkg.RateLimit     // 限流策略
+	botRateLimit  *pkg.RateLimit     // 机器人账号的限流策略
+	contentFilter *pkg.ContentFilter // 内容过滤，未开启时为 nil
+	messageChain  *pkg.MessageChain  // 消息中间件链
+	tokenManager  *pkg.TokenManager  // 会话令牌
+	auditLog      *pkg.AuditLog      // 审计日志，未开启时为 nil
+
+	plugins        []*pkg.Plugin          // 外部插件
+	pluginCommands map[string]*pkg.Plugin // 命令->处理该命令的插件
+	webhooks       []*pkg.Webhook         // 外发 Webhook
+
+	workspaces       map[string]*pkg.Workspace // 工作区
+	defaultWorkspace *pkg.Workspace            // 默认工作区，登录时未指定工作区则进入默认工作区
+	current          *pkg.Workspace            // 服务端终端当前查看的工作区
 )
 
 // init 初始化
@@ -36,30 +58,152 @@ func init() {
 	console = pkg.CreateLocalMsg()
 	broadcast = pkg.CreateBroadcastMsg()
 	logger = pkg.LogInit(config)
-	rdb = pkg.NewRedisHandler(config)
-	// 启动时清理旧 redis 数据
-	err := rdb.Clean(ctx)
+	if config.App.Node == "" {
+		hostname, _ := os.Hostname()
+		config.App.Node = hostname + ":" + config.App.Port
+	}
+	var err error
+	scorePolicy, err = pkg.NewScorePolicy(config)
+	if err != nil {
+		log.Fatalf("load score policy failed: %v", err)
+	}
+	accountPolicy, err = pkg.NewAccountPolicy(config)
+	if err != nil {
+		log.Fatalf("load account policy failed: %v", err)
+	}
+	loginGuard = pkg.NewLoginGuard(config)
+	nickPolicy, err = pkg.NewNickPolicy(config)
+	if err != nil {
+		log.Fatalf("load nick policy failed: %v", err)
+	}
+	rateLimit, err = pkg.NewRateLimit(config.RateLimit)
+	if err != nil {
+		log.Fatalf("load rate limit failed: %v", err)
+	}
+	botRateLimit, err = pkg.NewRateLimit(config.BotRateLimit)
+	if err != nil {
+		log.Fatalf("load bot rate limit failed: %v", err)
+	}
+	contentFilter, err = pkg.LoadContentFilter(config.Filter.File)
+	if err != nil {
+		log.Fatalf("load content filter failed: %v", err)
+	}
+	plugins, pluginCommands, err = loadPlugins(config.Plugins)
+	if err != nil {
+		log.Fatalf("load plugins failed: %v", err)
+	}
+	list, err := pkg.LoadWorkspaces(config)
+	if err != nil {
+		log.Fatalf("create workspaces failed: %v", err)
+	}
+	webhooks, err = loadWebhooks(config.Webhooks, list)
+	if err != nil {
+		log.Fatalf("load webhooks failed: %v", err)
+	}
+	messageChain = newMessageChain()
+	tokenManager = pkg.NewTokenManager(config)
+	auditLog, err = pkg.OpenAuditLog(config.Audit.File)
+	if err != nil {
+		log.Fatalf("open audit log failed: %v", err)
+	}
+	workspaces = make(map[string]*pkg.Workspace, len(list))
+	for _, ws := range list {
+		workspaces[ws.Name] = ws
+		initWorkspace(ws)
+	}
+	defaultWorkspace = list[0]
+	current = defaultWorkspace
+}
+
+// initWorkspace 初始化工作区
+func initWorkspace(ws *pkg.Workspace) {
+	// Redis 故障降级与恢复提示
+	if n, ok := ws.Store.(pkg.HealthNotifier); ok {
+		n.OnHealthChange(func(healthy bool, err error) {
+			if healthy {
+				console.Add("工作区 " + ws.Name + " 的 Redis 已恢复，消息重新通过 Redis 投递")
+				logger.Info("redis recovered, workspace:", ws.Name)
+				return
+			}
+			console.Add("工作区 " + ws.Name + " 的 Redis 连接异常，已降级为本节点内部投递，正在尝试重连...")
+			logger.Warn("redis degraded, workspace:", ws.Name, " err:", err)
+		})
+	}
+	// 启动时存储不可用不退出，降级运行并在后台重试
+	if err := prepareWorkspace(ws); err != nil {
+		console.Add("工作区 " + ws.Name + " 的存储初始化失败，已降级运行，正在后台重试...")
+		logger.Warn("prepare workspace failed, workspace:", ws.Name, " err:", err)
+		go retryBackoff(func() error { return prepareWorkspace(ws) }, func() {
+			console.Add("工作区 " + ws.Name + " 的存储初始化完成")
+			logger.Info("prepare workspace done, workspace:", ws.Name)
+		})
+	}
+}
+
+// prepareWorkspace 清理本节点的旧数据，并记录默认房间的创建时间
+func prepareWorkspace(ws *pkg.Workspace) error {
+	err := ws.Store.Clean(ctx)
 	if err != nil {
-		log.Fatalf("clean redis data faild when start: %v", err)
+		return err
 	}
+	meta, err := pkg.GetRoomMeta(ctx, ws.Store, pkg.DefaultRoom)
+	if err != nil {
+		return err
+	}
+	if meta["created"] == "" {
+		return pkg.SetRoomMeta(ctx, ws.Store, pkg.DefaultRoom, "created", time.Now().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// 启动时存储不可用的重试间隔
+const (
+	retryMinInterval = time.Second
+	retryMaxInterval = 30 * time.Second
+)
+
+// retryBackoff 按指数退避重试 fn 直到成功，成功后调用 done
+func retryBackoff(fn func() error, done func()) {
+	backoff := retryMinInterval
+	for fn() != nil {
+		time.Sleep(backoff)
+		backoff = min(backoff*2, retryMaxInterval)
+	}
+	done()
 }
 
 func main() {
+	defer auditLog.Close()
 	defer func() {
-		if err := rdb.Clean(ctx); err != nil {
-			logger.Info("clean redis data failed when close: %v", err)
+		for _, ws := range workspaces {
+			if err := ws.Store.Clean(ctx); err != nil {
+				logger.Infof("clean store data failed when close: %v", err)
+			}
 		}
 	}()
 	// 消息处理
 	go console.Out()
 	go func() {
-		err := broadcast.SendMessage(connList.GetAllConn())
+		err := broadcast.SendMessage(connList)
 		if err != nil {
 			console.Add("广播发生错误")
 			logger.Error("广播错误,err:" + err.Error())
 		}
 	}()
-	go msgQueueProcess()
+	for _, ws := range workspaces {
+		go msgQueueProcess(ws)
+		go subscribeProcess(ws)
+	}
+	if contentFilter != nil {
+		go watchFilter()
+	}
+	for _, p := range plugins {
+		p.Start()
+		defer p.Stop()
+	}
+	for _, h := range webhooks {
+		h.Start()
+	}
 	// 起始界面
 	console.HomeText()
 	// 开始监听
@@ -68,7 +212,14 @@ func main() {
 		logger.Error("listen failed ,err=", err.Error())
 	}
 	defer listener.Close()
-	console.Add("监听端口成功，等待客户端连接...")
+	console.Add("监听端口成功，等待客户端连接...当前节点:" + config.App.Node)
+	// 开启管理接口
+	startAdminAPI()
+	defer func() {
+		if adminAPI != nil {
+			adminAPI.Close()
+		}
+	}()
 	logger.Info("app run")
 	// 接收连接
 	go waitConn()
@@ -86,26 +237,118 @@ func waitInput() {
 			continue
 		}
 		line = strings.Trim(line, " \r\n")
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
 
-		switch line {
+		switch args[0] {
 		case "/help":
 			console.Add("0. /help\t帮助\n" +
-				"1. /users\t查看用户列表\n" +
+				"1. /users\t查看用户列表(含所在节点)\n" +
 				"2. /heart\t查看用户最后心跳时间\n" +
-				"3. /rank\t查看用户活跃排行榜\n" +
-				"4. /exit\t关闭服务端程序")
+				"3. /rank [day|week|month|all] [room]\t查看用户活跃排行榜\n" +
+				"4. /history [n]\t查看最近 n 条历史消息\n" +
+				"5. /room\t查看房间信息\n" +
+				"6. /workspace [name]\t查看或切换终端当前的工作区\n" +
+				"7. /stats\t查看聊天统计\n" +
+				"8. /revoke <nick>\t吊销用户的全部会话令牌\n" +
+				"9. /role [nick] [role]\t查看权限矩阵、查看或指定用户角色\n" +
+				"10. /kick <nick> [reason]\t将用户踢出聊天室\n" +
+				"11. /mute <nick> <duration>\t禁言用户，时长如 30m、2h、7d，0 表示永久\n" +
+				"12. /unmute <nick>\t解除禁言\n" +
+				"13. /ban <nick|ip|cidr> <duration> [reason]\t封禁当前工作区的用户、IP 或网段\n" +
+				"14. /unban <nick|ip|cidr>\t解除封禁\n" +
+				"15. /bans\t查看封禁列表\n" +
+				"16. /netban <ip|cidr> <duration> [reason]\t全网封禁 IP 或网段，对所有工作区生效\n" +
+				"17. /netunban <ip|cidr>\t解除全网封禁\n" +
+				"18. /netbans\t查看全网封禁列表\n" +
+				"19. /audit [nick]\t查看最近的审计事件，可按昵称过滤\n" +
+				"20. /plugins\t查看外部插件状态\n" +
+				"21. /bot [nick] [on|off]\t查看机器人账号，标记或取消标记注册账号为机器人\n" +
+				"22. /webhook [create <room> <name> | delete <name>]\t查看 Webhook，创建或删除入站 Webhook\n" +
+				"23. /exit\t关闭服务端程序")
 		case "/users":
-			console.Add(connList.GetList())
+			presence, err := current.Store.GetPresence(ctx)
+			if err != nil {
+				console.Add("获取集群用户失败: " + err.Error())
+				logger.Error("get presence failed, err:", err)
+			}
+			bots, err := pkg.BotAccounts(ctx, current.Store)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+			console.Add(connList.GetClusterList(current.Name, config.App.Node, presence, bots))
 		case "/heart":
 			console.Add(connList.GetLastHeardTime())
 		case "/rank":
-			rank, err := rdb.ShowRank(ctx)
+			console.Add(rankCommand(current, args[1:]))
+		case "/stats":
+			console.Add(statsCommand(current))
+		case "/revoke":
+			if len(args) != 2 {
+				console.Add("用法: /revoke <nick>")
+				continue
+			}
+			err = pkg.RevokeUser(ctx, current.Store, args[1])
 			if err != nil {
 				console.Add(err.Error())
 				logger.Error(err.Error())
+				continue
+			}
+			console.Add("已吊销用户 " + args[1] + " 的全部会话令牌")
+		case "/role":
+			console.Add(roleCommand(current, args[1:]))
+		case "/audit":
+			console.Add(auditCommand(current, args[1:]))
+		case "/plugins":
+			console.Add(pluginsCommand())
+		case "/bot":
+			console.Add(botCommand(current, args[1:]))
+		case "/webhook":
+			console.Add(webhookCommand(current, args[1:]))
+		case "/kick", "/mute", "/unmute", "/ban", "/unban", "/bans":
+			console.Add(moderationCommand(current, consoleActor, pkg.RoleAdmin, args))
+		case "/netban", "/netunban", "/netbans":
+			console.Add(networkBanCommand(args))
+		case "/history":
+			n := 20
+			if len(args) > 1 {
+				n, err = strconv.Atoi(args[1])
+				if err != nil || n <= 0 {
+					console.Add("用法: /history [n]")
+					continue
+				}
+			}
+			history, err := pkg.ShowHistory(ctx, current.Store, pkg.DefaultRoom, n)
+			if err != nil {
+				console.Add(err.Error())
 			} else {
-				console.Add(rank)
+				console.Add(history)
 			}
+		case "/room":
+			room, err := pkg.ShowRoom(ctx, current.Store, pkg.DefaultRoom)
+			if err != nil {
+				console.Add(err.Error())
+				logger.Error(err.Error())
+			} else {
+				console.Add(room)
+			}
+		case "/workspace":
+			if len(args) > 1 {
+				ws, ok := workspaces[args[1]]
+				if !ok {
+					console.Add("工作区不存在: " + args[1])
+					continue
+				}
+				current = ws
+			}
+			names := make([]string, 0, len(workspaces))
+			for name := range workspaces {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			console.Add("当前工作区: " + current.Name + "\n全部工作区: " + strings.Join(names, ", "))
 		case "/exit":
 			console.Add("退出程序！")
 			os.Exit(0)
@@ -115,6 +358,9 @@ func waitInput() {
 	}
 }
 
+// legacyClientText 发给纯文本协议客户端的升级提示
+const legacyClientText = "客户端版本过旧，服务端已改用 JSON 消息帧协议，请更新客户端"
+
 // waitConn 循环接收客户端连接
 func waitConn() {
 	for {
@@ -124,6 +370,14 @@ func waitConn() {
 			logger.Error("Accept() err=", err)
 			continue
 		}
+		// 握手前拒绝被全网封禁的 IP 与网段，工作区内的封禁在登录时检查
+		if ban := findNetworkBan(conn.RemoteAddr().String()); ban != nil {
+			data, _ := proto.EncodeFrame(proto.Frame{Type: proto.TypeSys, Text: ban.String()})
+			_, _ = conn.Write(data)
+			_ = conn.Close()
+			console.Add("拒绝被封禁的客户端连接:" + conn.RemoteAddr().String())
+			continue
+		}
 		// 接收到连接后，起一个协程
 		go process(conn)
 		console.Add("有客户端连接,客户端地址:" + conn.RemoteAddr().String())
@@ -134,54 +388,195 @@ func waitConn() {
 func process(conn net.Conn) {
 	defer conn.Close()
 	defer func() {
-		console.Add(connList.Connections[conn].NickName + "退出聊天室！")
-		broadcast.Add(connList.Connections[conn].NickName + "退出聊天室！")
+		state, ok := connList.Get(conn)
+		if !ok {
+			return // 未完成握手
+		}
+		ws := workspaces[state.Workspace]
+		publish(ws, proto.Frame{Type: proto.TypeSys, Text: state.NickName + "退出聊天室！"})
+		dispatchPlugins(pkg.PluginEvent{Event: pkg.PluginLeave, Workspace: ws.Name, Room: state.Room, From: state.NickName})
+		dispatchWebhooks(pkg.WebhookEvent{Event: pkg.WebhookLeave, Workspace: ws.Name, Room: state.Room, From: state.NickName})
+		if err := pkg.RecordLeave(ctx, ws.Store, time.Since(state.LoginTime)); err != nil {
+			logger.Error("record leave failed, err:", err)
+		}
 		connList.Delete(conn)
 		console.Add(connList.GetList())
 	}()
 
 	reader := bufio.NewReader(conn)
 	var nickName string
+	var ws *pkg.Workspace
+	var registered bool
+	var claims *pkg.TokenClaims
 	for {
-		nickName, _ = proto.Decode(reader)
-		data, _ := proto.Encode("false")
-		flag := connList.IsNameExist(nickName)
-		if !flag {
-			data, _ = proto.Encode("true")
+		raw, err := proto.Decode(reader)
+		if err != nil {
+			return
 		}
-		_, err := conn.Write(data)
+		login, err := proto.ParseFrame(raw)
 		if err != nil {
+			// 早期客户端使用纯文本协议，直接发送昵称，按旧协议回复升级提示后断开
+			if data, err := proto.Encode(legacyClientText); err == nil {
+				_, _ = conn.Write(data)
+			}
+			console.Add("拒绝旧版客户端连接:" + conn.RemoteAddr().String())
+			return
+		}
+		nickName = login.From
+		reason := ""
+		claims = nil
+		ws = defaultWorkspace
+		if login.Workspace != "" {
+			ws = workspaces[login.Workspace]
+		}
+		// 使用令牌登录时，昵称与工作区以令牌为准
+		if login.Token != "" && login.Type == proto.TypeLogin {
+			tokenWs, tokenClaims, err := verifyToken(login.Token)
+			switch {
+			case err != nil:
+				reason = err.Error()
+			case login.Workspace != "" && tokenWs != ws:
+				reason = "令牌与工作区不匹配"
+			default:
+				ws, claims, nickName = tokenWs, tokenClaims, tokenClaims.Subject
+			}
+		}
+		switch {
+		case reason != "":
+		case login.Type != proto.TypeLogin && login.Type != proto.TypeRegister:
+			reason = "请先登录"
+		case ws == nil:
+			reason = "工作区不存在"
+		case nickPolicy.Check(nickName) != "":
+			reason = nickPolicy.Check(nickName)
+		case connList.IsNameExist(ws.Name, nickName):
+			reason = "昵称重复"
+		default:
+			// 握手期间新增的全网封禁同样生效
+			ban := findBan(ws, nickName, conn.RemoteAddr().String())
+			if ban == nil {
+				ban = findNetworkBan(conn.RemoteAddr().String())
+			}
+			if ban != nil {
+				reason = ban.String()
+				break
+			}
+			if reason = confusableReason(ws, nickName); reason != "" {
+				break
+			}
+			if reason = webhookNameReason(ws, nickName); reason != "" {
+				break
+			}
+			if reason = pluginNameReason(nickName); reason != "" {
+				break
+			}
+			// 在集群中登记昵称，保证昵称在工作区内全局唯一
+			ok, err := ws.Store.AddPresence(ctx, nickName)
+			if err != nil {
+				logger.Error("add presence failed, err:", err)
+			}
+			if !ok {
+				reason = "昵称重复"
+				break
+			}
+			if claims != nil {
+				registered = true
+				break
+			}
+			registered, reason = authenticate(ws, login, conn.RemoteAddr().String())
+			if reason != "" {
+				_ = ws.Store.DelPresence(ctx, nickName)
+			}
+		}
+		reply := proto.Frame{Type: proto.TypeLogin, OK: reason == "", Text: reason}
+		if ws != nil {
+			reply.Workspace = ws.Name
+		}
+		if reply.OK {
+			reply.From = nickName
+			// 注册用户使用密码登录后签发令牌，之后重连无需再次输入密码
+			if registered && claims == nil && tokenManager.Enabled() {
+				token, issued, err := tokenManager.Issue(ws.Name, nickName)
+				if err != nil {
+					logger.Error("issue token failed, err:", err)
+				} else {
+					reply.Token, claims = token, issued
+				}
+			}
+		}
+		data, _ := proto.EncodeFrame(reply)
+		_, err = conn.Write(data)
+		if err != nil {
+			if reply.OK {
+				_ = ws.Store.DelPresence(ctx, nickName)
+			}
 			console.Add("发送信息失败...")
 			logger.Error("sendMessage failed, go:process for1{}, err = ", err)
 			return
 		}
-		if !flag {
+		if reply.OK {
 			break
 		}
 	}
 
-	// 添加连接
-	connList.Add(conn, nickName)
-	console.Add("有用户进入聊天室，用户昵称:" + nickName)
+	// 添加连接，之后昵称以连接状态为准，/nick 改名后随之更新
+	bot := false
+	if registered {
+		account, err := pkg.GetAccount(ctx, ws.Store, nickName)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+		bot = account != nil && account.Bot
+	}
+	connList.Add(conn, ws.Name, nickName, registered)
+	connList.Update(conn, func(info *pkg.ConnInfo) {
+		info.Token = claims
+		info.Bot = bot
+		// 机器人账号使用单独的限流策略
+		info.Flood = rateLimit.NewGuard()
+		if bot {
+			info.Flood = botRateLimit.NewGuard()
+		}
+	})
+	state, _ := connList.Get(conn)
+	console.Add("有用户进入聊天室，工作区:" + ws.Name + "，用户昵称:" + nickName)
 	console.Add(connList.GetList())
 
-	// 添加用户到排行榜
-	err := rdb.AddScore(ctx, nickName)
-	if err != nil {
-		logger.Error("add user to rank failed,err:", err)
+	defer func() {
+		// 连接可能已被心跳检测移除，此时使用最近一次读取的昵称
+		if info, ok := connList.Get(conn); ok {
+			state = info
+		}
+		if err := ws.Store.DelPresence(ctx, state.NickName); err != nil {
+			logger.Error("del presence failed, err:", err)
+		}
+		scorePolicy.Forget(ws.Name, state.NickName)
+	}()
+
+	if err := pkg.RecordJoin(ctx, ws.Store, time.Now()); err != nil {
+		logger.Error("record join failed, err:", err)
+	}
+
+	// 加入聊天室得分，机器人不参与排行榜
+	if score := scorePolicy.Join(); score != 0 && !state.Bot {
+		err := pkg.AddActivity(ctx, ws.Store, scorePolicy, pkg.DefaultRoom, nickName, score)
+		if err != nil {
+			logger.Error("add user to rank failed,err:", err)
+		}
 	}
-	defer rdb.DelUserFromRank(ctx, nickName)
 
 	// 广播欢迎语
-	broadcast.Add("Welcome " + connList.Connections[conn].NickName + " joined the chat!")
+	publish(ws, proto.Frame{Type: proto.TypeSys, Text: "Welcome " + nickName + " joined the chat!"})
+	dispatchPlugins(pkg.PluginEvent{Event: pkg.PluginJoin, Workspace: ws.Name, Room: state.Room, From: nickName})
+	dispatchWebhooks(pkg.WebhookEvent{Event: pkg.WebhookJoin, Workspace: ws.Name, Room: state.Room, From: nickName})
 
 	// 开启心跳检测
 	go heartbeatChecker(conn)
 
 	// 循环接收客户端发送的数据
 	for {
-		message, err := proto.Decode(reader)
-		if err == io.EOF {
+		frame, err := proto.DecodeFrame(reader)
+		if err == io.EOF || errors.Is(err, net.ErrClosed) {
 			return
 		}
 		if err != nil {
@@ -189,24 +584,131 @@ func process(conn net.Conn) {
 			logger.Error("decode msg failed, go:process for2{}, err:", err)
 			return
 		}
-		msg := fmt.Sprintf(nickName + "!$|$|$!" + message)
-		err = rdb.MsgQueuePush(ctx, msg)
+		// 限流，心跳不计入
+		if frame.Type != proto.TypePing && !floodControl(ws, conn, frame) {
+			continue
+		}
+		// 注册命令直接处理，密码不进入消息队列
+		if frame.Type == proto.TypeCmd && strings.HasPrefix(frame.Text, "/register") {
+			registerCommand(ws, conn, frame.Text)
+			continue
+		}
+		// 发送者、房间与时间以服务端为准，消息编号、编辑与删除标记、父消息预览由服务端维护
+		if info, ok := connList.Get(conn); ok {
+			state = info
+		}
+		frame.From = state.NickName
+		frame.Room = state.Room
+		frame.Time = time.Now().Unix()
+		frame.ID, frame.Edited, frame.Deleted = "", 0, false
+		frame.Quote, frame.Bot = "", false
+		msg, err := frame.Marshal()
+		if err != nil {
+			logger.Error("encode msg failed, err:", err)
+			continue
+		}
+		err = ws.Store.MsgQueuePush(ctx, msg)
 		if err != nil {
 			logger.Error(err.Error())
 		}
 	}
 }
 
+// authenticate 注册账号或校验登录密码，返回是否以注册用户身份登录，失败时返回原因
+// 来源 IP 或昵称连续密码错误过多时需等待退避结束才会再次校验密码
+func authenticate(ws *pkg.Workspace, login proto.Frame, addr string) (bool, string) {
+	if login.Type == proto.TypeRegister {
+		reason, err := accountPolicy.Register(ctx, ws.Store, login.From, login.Password)
+		if err != nil {
+			logger.Error("register failed, err:", err)
+			return false, "注册失败，请稍后重试"
+		}
+		if reason == "" {
+			console.Add("新用户注册，工作区:" + ws.Name + "，用户昵称:" + login.From)
+		}
+		return reason == "", reason
+	}
+	keys := pkg.LoginKeys(addr, ws.Name, login.From)
+	if login.Password != "" {
+		if wait := loginGuard.Wait(keys, time.Now()); wait > 0 {
+			return false, backoffReason(wait)
+		}
+	}
+	registered, reason, err := accountPolicy.Login(ctx, ws.Store, login.From, login.Password)
+	if err != nil {
+		logger.Error("check account failed, err:", err)
+		return false, "登录失败，请稍后重试"
+	}
+	switch {
+	case reason == pkg.ReasonWrongPassword:
+		if wait := loginGuard.Fail(keys, time.Now()); wait > 0 {
+			logger.Warn("login backoff, workspace:", ws.Name, " nick:", login.From, " addr:", addr, " wait:", wait)
+		}
+	case registered:
+		loginGuard.Succeed(ws.Name, login.From)
+	}
+	return registered, reason
+}
+
+// backoffReason 登录退避期间的失败原因
+func backoffReason(wait time.Duration) string {
+	return "密码错误次数过多，请 " + strconv.Itoa(int(math.Ceil(wait.Seconds()))) + " 秒后重试"
+}
+
+// confusableReason 昵称与工作区在线用户容易混淆时返回原因
+// 获取在线用户失败时放行，昵称唯一性仍由 AddPresence 保证
+func confusableReason(ws *pkg.Workspace, nickName string) string {
+	presence, err := ws.Store.GetPresence(ctx)
+	if err != nil {
+		logger.Error("get presence failed, err:", err)
+		return ""
+	}
+	names := make([]string, 0, len(presence))
+	for name := range presence {
+		names = append(names, name)
+	}
+	if name := nickPolicy.Confusable(nickName, names); name != "" {
+		return "昵称与在线用户 " + name + " 过于相似"
+	}
+	return ""
+}
+
+// verifyToken 校验会话令牌，返回令牌所属的工作区，TCP 登录与 HTTP 接口共用
+func verifyToken(token string) (*pkg.Workspace, *pkg.TokenClaims, error) {
+	claims, err := tokenManager.Parse(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	ws, ok := workspaces[claims.Workspace]
+	if !ok {
+		return nil, nil, pkg.ErrTokenInvalid
+	}
+	err = pkg.CheckRevoked(ctx, ws.Store, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 账号不存在时令牌失效
+	account, err := pkg.GetAccount(ctx, ws.Store, claims.Subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account == nil {
+		return nil, nil, pkg.ErrTokenInvalid
+	}
+	return ws, claims, nil
+}
+
 // heartbeatChecker 心跳检测
 func heartbeatChecker(conn net.Conn) {
 	defer conn.Close()
 	defer connList.Delete(conn)
 	for {
 		time.Sleep(time.Duration(config.App.HeartbeatInterval) * time.Second)
-		if !connList.IsExist(conn) {
+		state, ok := connList.Get(conn)
+		if !ok {
 			return // 如果连接已经被删除，则退出
 		}
-		if time.Since(connList.Connections[conn].LastHeartTime) > time.Duration(config.App.TimeoutInterval)*time.Second {
+		if time.Since(state.LastHeartTime) > time.Duration(config.App.TimeoutInterval)*time.Second {
 			console.Add("客户端超时未发送心跳包，断开连接:" + conn.RemoteAddr().String())
 			logger.Error("heart timeOut:", conn.RemoteAddr().String())
 			return
@@ -214,37 +716,111 @@ func heartbeatChecker(conn net.Conn) {
 	}
 }
 
-// msgQueueProcess 消息队列中消息处理
-func msgQueueProcess() {
+// msgQueueProcess 工作区消息队列中消息处理
+func msgQueueProcess(ws *pkg.Workspace) {
 	for {
-		result, err := rdb.MsgQueuePop(ctx)
-		if err != nil || len(result) < 2 {
+		msg, err := ws.Store.MsgQueuePop(ctx)
+		if err == pkg.ErrQueueEmpty {
 			continue
 		}
-		fullMsg := result[1]
-		parts := strings.SplitN(fullMsg, "!$|$|$!", 2) // 按照 "!$|$|$!" 分割
-		if len(parts) == 2 {
-			nickName := parts[0]
-			message := parts[1]
-			conn, err := connList.GetConnByNickName(nickName)
-			if err != nil {
-				continue
-			}
-			if message == "###PING" {
-				// 更新最后心跳时间
-				connList.Connections[conn].LastHeartTime = time.Now()
-			} else {
-				console.Add(message)
-				broadcast.Add(message)
-				err = rdb.AddScore(ctx, nickName)
-				if err != nil {
-					logger.Error("add score failed,err:", err.Error())
+		if err != nil {
+			logger.Error("pop msg failed, err:", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		frame, err := proto.ParseFrame(msg)
+		if err != nil {
+			logger.Error("parse msg failed, err:", err)
+			continue
+		}
+		conn, err := connList.GetConnByNickName(ws.Name, frame.From)
+		if err != nil {
+			continue
+		}
+		switch frame.Type {
+		case proto.TypePing:
+			// 更新最后心跳时间
+			connList.Update(conn, func(info *pkg.ConnInfo) {
+				info.LastHeartTime = time.Now()
+			})
+		case proto.TypeMsg:
+			state, _ := connList.Get(conn)
+			mc := pkg.NewMessageContext(ctx, ws, conn, frame, state.Registered)
+			mc.Bot = state.Bot
+			messageChain.Run(mc)
+			for _, reply := range mc.Replies() {
+				if err = connList.Send(conn, reply); err != nil {
+					logger.Error("send reply failed, err:", err)
 				}
 			}
+			for _, f := range mc.Emitted() {
+				publish(ws, f)
+			}
+		case proto.TypeCmd:
+			handleCommand(ws, conn, frame)
 		}
 	}
 }
 
+// subscribeProcess 订阅工作区的集群广播，订阅失败或断开时按指数退避重新订阅
+func subscribeProcess(ws *pkg.Workspace) {
+	for {
+		var sub <-chan string
+		retryBackoff(func() error {
+			var err error
+			sub, err = ws.Store.Subscribe(ctx)
+			if err != nil {
+				logger.Error("subscribe broadcast failed, workspace:", ws.Name, " err:", err)
+			}
+			return err
+		}, func() {})
+		forwardBroadcast(ws, sub)
+		if ctx.Err() != nil {
+			return
+		}
+		console.Add("工作区 " + ws.Name + " 的集群广播订阅已断开，正在重新订阅...")
+		logger.Error("broadcast subscription closed, workspace:", ws.Name)
+	}
+}
+
+// forwardBroadcast 将工作区的集群广播消息转发给本节点的客户端，订阅断开时返回
+func forwardBroadcast(ws *pkg.Workspace, sub <-chan string) {
+	for msg := range sub {
+		frame, err := proto.ParseFrame(msg)
+		if err != nil {
+			logger.Error("parse broadcast msg failed, err:", err)
+			continue
+		}
+		// 断开连接通知只在节点内部处理
+		switch frame.Type {
+		case proto.TypeKick:
+			kickLocal(ws, frame)
+			continue
+		case proto.TypeFlag:
+			notifyModerators(ws, frame)
+			continue
+		}
+		if len(workspaces) > 1 {
+			console.Add("[" + ws.Name + "] " + frame.String())
+		} else {
+			console.Add(frame.String())
+		}
+		broadcast.Add(ws.Name, frame)
+	}
+}
+
+// publish 向工作区的集群广播消息
+func publish(ws *pkg.Workspace, frame proto.Frame) {
+	msg, err := frame.Marshal()
+	if err == nil {
+		err = ws.Store.Publish(ctx, msg)
+	}
+	if err != nil {
+		console.Add("广播消息失败")
+		logger.Error(err.Error())
+	}
+}
+
 // loadConfig 加载配置文件
 func loadConfig(path string) object.Config {
 	load, err := ini.Load(path)
@@ -255,5 +831,24 @@ func loadConfig(path string) object.Config {
 	if err != nil {
 		panic("failed to map ini file to struct")
 	}
+	// 每个 [Plugin.<name>] 小节为一个外部插件，每个 [Webhook.<name>] 小节为一个外发 Webhook
+	config.Plugins = make(map[string]object.PluginConfig)
+	config.Webhooks = make(map[string]object.WebhookConfig)
+	for _, section := range load.Sections() {
+		if name, ok := strings.CutPrefix(section.Name(), "Plugin."); ok && name != "" {
+			var plugin object.PluginConfig
+			if err = section.MapTo(&plugin); err != nil {
+				panic("failed to map plugin section " + section.Name())
+			}
+			config.Plugins[name] = plugin
+		}
+		if name, ok := strings.CutPrefix(section.Name(), "Webhook."); ok && name != "" {
+			var webhook object.WebhookConfig
+			if err = section.MapTo(&webhook); err != nil {
+				panic("failed to map webhook section " + section.Name())
+			}
+			config.Webhooks[name] = webhook
+		}
+	}
 	return config
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"easy-chat/server/object"
+	"easy-chat/server/pkg"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadWebhooks 按配置创建外发 Webhook，按名称排序，未指定工作区的 Webhook 只接收默认工作区的事件
+func loadWebhooks(conf map[string]object.WebhookConfig, spaces []*pkg.Workspace) ([]*pkg.Webhook, error) {
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*pkg.Webhook, 0, len(names))
+	for _, name := range names {
+		c := conf[name]
+		if c.Workspace = strings.TrimSpace(c.Workspace); c.Workspace == "" {
+			c.Workspace = spaces[0].Name
+		}
+		if !hasWorkspace(spaces, c.Workspace) {
+			return nil, errors.New("Webhook " + name + " 的工作区不存在: " + c.Workspace)
+		}
+		h, err := pkg.NewWebhook(name, c, logger)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, nil
+}
+
+// hasWorkspace 工作区列表中是否有指定名称的工作区
+func hasWorkspace(spaces []*pkg.Workspace, name string) bool {
+	for _, ws := range spaces {
+		if ws.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookMiddleware 消息投递后发送给匹配的外发 Webhook
+func webhookMiddleware(mc *pkg.MessageContext, next func()) {
+	next()
+	if !mc.Delivered() {
+		return
+	}
+	dispatchWebhooks(pkg.WebhookEvent{
+		Event:     pkg.WebhookMessage,
+		Workspace: mc.Workspace.Name,
+		Room:      mc.Frame.Room,
+		From:      mc.Frame.From,
+		Text:      mc.Frame.Text,
+		Time:      mc.Frame.Time,
+	})
+}
+
+// dispatchWebhooks 将事件放入匹配的外发 Webhook 的队列，不等待发送
+func dispatchWebhooks(event pkg.WebhookEvent) {
+	for _, h := range webhooks {
+		if !h.Enqueue(event) {
+			logger.Warn("webhook ", h.Name, " queue is full, event dropped")
+		}
+	}
+}
+
+// webhookUsage 服务端终端 /webhook 的用法
+const webhookUsage = "用法: /webhook [create <room> <name> | delete <name>]"
+
+// webhookCommand 服务端终端查看外发 Webhook 状态与工作区的入站 Webhook，创建或删除入站 Webhook
+func webhookCommand(ws *pkg.Workspace, args []string) string {
+	switch {
+	case len(args) == 0:
+		return showWebhooks(ws)
+	case args[0] == "create" && len(args) == 3:
+		return createWebhook(ws, args[1], args[2])
+	case args[0] == "delete" && len(args) == 2:
+		ok, err := pkg.DeleteIncomingWebhook(ctx, ws.Store, args[1])
+		if err != nil {
+			logger.Error(err.Error())
+			return err.Error()
+		}
+		if !ok {
+			return "Webhook 不存在: " + args[1]
+		}
+		recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditWebhook, Actor: consoleActor, Target: args[1], Detail: "delete"})
+		return "已删除 Webhook " + args[1]
+	default:
+		return webhookUsage
+	}
+}
+
+// showWebhooks 外发 Webhook 状态与工作区的入站 Webhook
+func showWebhooks(ws *pkg.Workspace) string {
+	lines := []string{"外发 Webhook:"}
+	for _, h := range webhooks {
+		lines = append(lines, h.String())
+	}
+	if len(webhooks) == 0 {
+		lines = append(lines, "未配置")
+	}
+	lines = append(lines, "工作区 "+ws.Name+" 的入站 Webhook:")
+	hooks, err := pkg.ListIncomingWebhooks(ctx, ws.Store)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	for _, hook := range hooks {
+		created := time.Unix(hook.Created, 0).Format("2006-01-02 15:04:05")
+		lines = append(lines, hook.Name+"  房间: "+hook.Room+"  编号: "+hook.ID+"  创建: "+created+" by "+hook.Creator)
+	}
+	if len(hooks) == 0 {
+		lines = append(lines, "无")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// createWebhook 创建入站 Webhook，名称作为消息的发送者，不能与注册账号重名
+func createWebhook(ws *pkg.Workspace, room string, name string) string {
+	if reason := nickPolicy.Check(name); reason != "" {
+		return "名称不可用: " + reason
+	}
+	if reason := checkRoom(ws, room); reason != "" {
+		return reason
+	}
+	account, err := pkg.GetAccount(ctx, ws.Store, name)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	if account != nil {
+		return "名称不可用: 与注册账号重名"
+	}
+	// 名称作为消息的发送者，不能与在线用户相同或容易混淆
+	presence, err := ws.Store.GetPresence(ctx)
+	if err != nil {
+		logger.Error("get presence failed, err:", err)
+		return "获取在线用户失败: " + err.Error()
+	}
+	names := make([]string, 0, len(presence))
+	for nickName := range presence {
+		if nickName == name {
+			return "名称不可用: 与在线用户重名"
+		}
+		names = append(names, nickName)
+	}
+	if nickName := nickPolicy.Confusable(name, names); nickName != "" {
+		return "名称不可用: 与在线用户 " + nickName + " 过于相似"
+	}
+	hook, token, err := pkg.CreateIncomingWebhook(ctx, ws.Store, room, name, consoleActor)
+	if err != nil {
+		logger.Error(err.Error())
+		return err.Error()
+	}
+	recordAudit(ws, pkg.AuditEvent{Action: pkg.AuditWebhook, Actor: consoleActor, Target: name, Detail: "create " + room})
+	path := "/hooks/" + ws.Name + "/" + hook.ID + "/" + token
+	msg := "已创建 Webhook " + name + "，向房间 " + room + " 发送消息，地址只显示一次，请妥善保存:\n"
+	if config.Admin.Addr == "" {
+		return msg + path + "\n管理接口未开启，配置 [Admin] addr 后才能使用"
+	}
+	return msg + "POST http://" + config.Admin.Addr + path + `  {"text":"..."}`
+}
+
+// webhookNameReason 昵称与工作区的入站 Webhook 名称相同或容易混淆时返回原因
+// Webhook 的名称作为消息的发送者，保留给 Webhook 使用以免被用户冒充；获取 Webhook 失败时放行
+func webhookNameReason(ws *pkg.Workspace, nickName string) string {
+	hooks, err := pkg.ListIncomingWebhooks(ctx, ws.Store)
+	if err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+	names := make([]string, 0, len(hooks))
+	for _, hook := range hooks {
+		if hook.Name == nickName {
+			return "昵称已被 Webhook 使用"
+		}
+		names = append(names, hook.Name)
+	}
+	if name := nickPolicy.Confusable(nickName, names); name != "" {
+		return "昵称与 Webhook " + name + " 过于相似"
+	}
+	return ""
+}